@@ -0,0 +1,194 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/newcontext-oss/credhub-venafi/chclient"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
+	"github.com/newcontext-oss/credhub-venafi/vcclient"
+)
+
+// CertSpec describes the certificate requested by `cv create` independent of
+// the platform that generates it
+type CertSpec struct {
+	Name               string
+	CommonName         string
+	Organization       string
+	OrganizationalUnit []string
+	Country            string
+	State              string
+	Locality           string
+	DNSNames           []string
+	IPAddresses        []net.IP
+	EmailAddresses     []string
+	KeyType            certificate.KeyType
+	KeyLength          int
+	KeyCurve           certificate.EllipticCurve
+	KeyUsage           []string
+	ExtKeyUsage        []string
+	ValidityHours      int
+	CA                 string
+	IsCA               bool
+	SelfSign           bool
+	KeyPassword        string
+	IssuerHint         string
+	CsrOrigin          certificate.CSrOriginOption
+}
+
+// spec builds the unified certificate spec from the create flags. SANs from
+// -alternative-name are sorted into DNS names and IP addresses, -duration is
+// converted to hours.
+func (v *GenerateAndStoreCommand) spec() (*CertSpec, error) {
+	s := &CertSpec{
+		Name:               v.Name,
+		CommonName:         v.CommonName,
+		Organization:       v.OrganizationName,
+		OrganizationalUnit: v.OrganizationalUnit,
+		Country:            v.Country,
+		State:              v.State,
+		Locality:           v.Locality,
+		DNSNames:           append([]string{}, v.SANDNS...),
+		IPAddresses:        append([]net.IP{}, v.SANIP...),
+		EmailAddresses:     v.SANEmail,
+		KeyType:            v.KeyType,
+		KeyLength:          v.KeyLength,
+		KeyCurve:           v.KeyCurve,
+		KeyUsage:           v.KeyUsage,
+		ExtKeyUsage:        v.ExtKeyUsage,
+		ValidityHours:      v.ValidityHours,
+		CA:                 v.CA,
+		IsCA:               v.IsCA,
+		SelfSign:           v.SelfSign,
+		KeyPassword:        v.KeyPassword,
+		IssuerHint:         v.IssuerHint,
+		CsrOrigin:          vcclient.CsrOrigins[v.CsrOrigin],
+	}
+	for _, name := range v.AlternativeName {
+		if ip := net.ParseIP(name); ip != nil {
+			s.IPAddresses = append(s.IPAddresses, ip)
+		} else {
+			s.DNSNames = append(s.DNSNames, name)
+		}
+	}
+	if v.Duration != 0 {
+		hours := v.Duration * 24
+		if s.ValidityHours != 0 && s.ValidityHours != hours {
+			return nil, fmt.Errorf("duration of %d days and validity-hours %d disagree", v.Duration, s.ValidityHours)
+		}
+		s.ValidityHours = hours
+	}
+	if v.CSRFile != "" {
+		s.CsrOrigin = certificate.UserProvidedCSR
+	}
+	return s, nil
+}
+
+// VenafiArgs translates the spec for generation on Venafi
+func (s *CertSpec) VenafiArgs() (*vcclient.CertArgs, error) {
+	unsupported := []string{}
+	if len(s.KeyUsage) > 0 {
+		unsupported = append(unsupported, "key usage (set by the CA template of the zone)")
+	}
+	if len(s.ExtKeyUsage) > 0 {
+		unsupported = append(unsupported, "extended key usage (set by the CA template of the zone)")
+	}
+	if s.CA != "" {
+		unsupported = append(unsupported, "a CA (set by the zone)")
+	}
+	if s.IsCA {
+		unsupported = append(unsupported, "is-ca")
+	}
+	if s.SelfSign {
+		unsupported = append(unsupported, "self-sign")
+	}
+	if len(unsupported) > 0 {
+		return nil, &chclient.UnsupportedError{Platform: "Venafi", Fields: unsupported}
+	}
+	return &vcclient.CertArgs{
+		Name:               s.Name,
+		CommonName:         s.CommonName,
+		OrganizationName:   s.Organization,
+		SANDNS:             s.DNSNames,
+		KeyType:            s.KeyType,
+		KeyLength:          s.KeyLength,
+		KeyCurve:           s.KeyCurve,
+		OrganizationalUnit: s.OrganizationalUnit,
+		Country:            s.Country,
+		State:              s.State,
+		Locality:           s.Locality,
+		SANEmail:           s.EmailAddresses,
+		SANIP:              s.IPAddresses,
+		KeyPassword:        s.KeyPassword,
+		ValidityHours:      s.ValidityHours,
+		IssuerHint:         s.IssuerHint,
+		CsrOrigin:          s.CsrOrigin,
+	}, nil
+}
+
+// StoreParameters translates the spec for generation on the secret store,
+// CredHub or Vault
+func (s *CertSpec) StoreParameters() (secretstore.GenerateParameters, error) {
+	unsupported := []string{}
+	if len(s.EmailAddresses) > 0 {
+		unsupported = append(unsupported, "email SANs")
+	}
+	if s.KeyType != certificate.KeyTypeRSA {
+		unsupported = append(unsupported, s.KeyType.String()+" keys")
+	}
+	if s.KeyCurve != certificate.EllipticCurveNotSet {
+		unsupported = append(unsupported, "a key curve")
+	}
+	if s.ValidityHours%24 != 0 {
+		unsupported = append(unsupported, fmt.Sprintf("a validity of %d hours (only whole days)", s.ValidityHours))
+	}
+	if s.KeyPassword != "" {
+		unsupported = append(unsupported, "a key password")
+	}
+	if s.IssuerHint != "" {
+		unsupported = append(unsupported, "an issuer hint")
+	}
+	if s.CsrOrigin != certificate.LocalGeneratedCSR {
+		unsupported = append(unsupported, "a CSR generated by Venafi or supplied by the user")
+	}
+	if len(unsupported) > 0 {
+		return secretstore.GenerateParameters{}, &chclient.UnsupportedError{Platform: "the secret store", Fields: unsupported}
+	}
+
+	alternativeNames := append([]string{}, s.DNSNames...)
+	for _, ip := range s.IPAddresses {
+		alternativeNames = append(alternativeNames, ip.String())
+	}
+	return secretstore.GenerateParameters{
+		KeyLength:        s.KeyLength,
+		CommonName:       s.CommonName,
+		Organization:     s.Organization,
+		OrganizationUnit: strings.Join(s.OrganizationalUnit, ","),
+		Locality:         s.Locality,
+		State:            s.State,
+		Country:          s.Country,
+		AlternativeNames: alternativeNames,
+		ExtendedKeyUsage: s.ExtKeyUsage,
+		KeyUsage:         s.KeyUsage,
+		Duration:         s.ValidityHours / 24,
+		Ca:               s.CA,
+		SelfSign:         s.SelfSign,
+		IsCA:             s.IsCA,
+	}, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/newcontext-oss/credhub-venafi/chclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertSpecVenafi(t *testing.T) {
+	v := &GenerateAndStoreCommand{
+		CommonName:      "web.example.com",
+		SANDNS:          stringSlice{"a.example.com"},
+		AlternativeName: stringSlice{"b.example.com", "10.0.0.1"},
+		Duration:        30,
+	}
+	spec, err := v.spec()
+	assert.Nil(t, err)
+	args, err := spec.VenafiArgs()
+	assert.Nil(t, err, "It should translate the spec for Venafi")
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, args.SANDNS, "It should sort alternative names into DNS names")
+	assert.Equal(t, []net.IP{net.ParseIP("10.0.0.1")}, args.SANIP, "It should sort alternative names into IPs")
+	assert.Equal(t, 720, args.ValidityHours, "It should convert the duration to hours")
+
+	v.KeyUsage = stringSlice{"digital_signature"}
+	v.CA = "/ca"
+	spec, _ = v.spec()
+	_, err = spec.VenafiArgs()
+	assert.NotNil(t, err, "It should refuse fields Venafi cannot honor")
+	assert.Len(t, err.(*chclient.UnsupportedError).Fields, 2, "It should list every unsupported field")
+
+	v = &GenerateAndStoreCommand{CommonName: "web.example.com", Duration: 30, ValidityHours: 48}
+	_, err = v.spec()
+	assert.NotNil(t, err, "It should refuse a duration and validity that disagree")
+}
+
+func TestCertSpecCredhub(t *testing.T) {
+	v := &GenerateAndStoreCommand{
+		CommonName: "web.example.com",
+		SANDNS:     stringSlice{"a.example.com"},
+		SANIP:      ipSlice{net.ParseIP("10.0.0.1")},
+		KeyLength:  3072,
+		KeyUsage:   stringSlice{"digital_signature"},
+		SelfSign:   true,
+	}
+	v.ValidityHours = 48
+	spec, err := v.spec()
+	assert.Nil(t, err)
+	params, err := spec.StoreParameters()
+	assert.Nil(t, err, "It should translate the spec for the secret store")
+	assert.Equal(t, []string{"a.example.com", "10.0.0.1"}, params.AlternativeNames, "It should pass SANs as alternative names")
+	assert.Equal(t, 2, params.Duration, "It should convert the validity to days")
+	assert.Equal(t, 3072, params.KeyLength)
+	assert.True(t, params.SelfSign)
+
+	v.SANEmail = emailSlice{"a@example.com"}
+	v.KeyType = certificate.KeyTypeECDSA
+	v.ValidityHours = 36
+	spec, _ = v.spec()
+	_, err = spec.StoreParameters()
+	assert.NotNil(t, err, "It should refuse fields the secret store cannot honor")
+	assert.Len(t, err.(*chclient.UnsupportedError).Fields, 3, "It should list every unsupported field")
+}
@@ -0,0 +1,343 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chfake
+
+// This file contains the credential generation used by POST /api/v1/data.
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"golang.org/x/crypto/ssh"
+)
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digital_signature": x509.KeyUsageDigitalSignature,
+	"non_repudiation":   x509.KeyUsageContentCommitment,
+	"key_encipherment":  x509.KeyUsageKeyEncipherment,
+	"data_encipherment": x509.KeyUsageDataEncipherment,
+	"key_agreement":     x509.KeyUsageKeyAgreement,
+	"key_cert_sign":     x509.KeyUsageCertSign,
+	"crl_sign":          x509.KeyUsageCRLSign,
+	"encipher_only":     x509.KeyUsageEncipherOnly,
+	"decipher_only":     x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"server_auth":      x509.ExtKeyUsageServerAuth,
+	"client_auth":      x509.ExtKeyUsageClientAuth,
+	"code_signing":     x509.ExtKeyUsageCodeSigning,
+	"email_protection": x509.ExtKeyUsageEmailProtection,
+	"timestamping":     x509.ExtKeyUsageTimeStamping,
+}
+
+func (s *Server) generateData(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name       string          `json:"name"`
+		Type       string          `json:"type"`
+		Parameters json.RawMessage `json:"parameters"`
+		Overwrite  bool            `json:"overwrite"`
+		Mode       string          `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "The request could not be fulfilled because the request path or body did not meet expectation.")
+		return
+	}
+	if body.Name == "" {
+		writeError(w, http.StatusBadRequest, "A credential name must be provided. Please validate your input and retry your request.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.latest(body.Name); ok && !body.Overwrite {
+		writeJSON(w, http.StatusOK, existing)
+		return
+	}
+
+	var value interface{}
+	var err error
+	switch body.Type {
+	case "certificate":
+		params := generate.Certificate{}
+		if err = json.Unmarshal(body.Parameters, &params); err == nil {
+			value, err = s.generateCertificate(params)
+		}
+	case "rsa":
+		params := generate.RSA{}
+		if err = json.Unmarshal(body.Parameters, &params); err == nil {
+			value, err = generateRSA(params.KeyLength)
+		}
+	case "ssh":
+		params := generate.SSH{}
+		if err = json.Unmarshal(body.Parameters, &params); err == nil {
+			value, err = generateSSH(params.KeyLength, params.Comment)
+		}
+	default:
+		err = fmt.Errorf("The request does not include a valid type. Valid values for generate include 'password', 'user', 'certificate', 'ssh' and 'rsa'.")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.store(body.Name, body.Type, toJSONValue(value)))
+}
+
+// generateCertificate creates a certificate signed either by itself or by a
+// CA already stored in the server. The caller must hold s.mu.
+func (s *Server) generateCertificate(p generate.Certificate) (values.Certificate, error) {
+	if p.Ca == "" && !p.SelfSign && !p.IsCA {
+		return values.Certificate{}, errors.New("Certificate generation requires a 'ca', 'self_sign' or 'is_ca' parameter.")
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = 2048
+	}
+	if p.Duration == 0 {
+		p.Duration = 365
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, p.KeyLength)
+	if err != nil {
+		return values.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return values.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject(p),
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(0, 0, p.Duration),
+		BasicConstraintsValid: true,
+		IsCA:                  p.IsCA,
+	}
+	for _, name := range p.AlternativeNames {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	for _, u := range p.KeyUsage {
+		ku, ok := keyUsages[u]
+		if !ok {
+			return values.Certificate{}, fmt.Errorf("The provided key usage '%s' is not supported.", u)
+		}
+		template.KeyUsage |= ku
+	}
+	for _, u := range p.ExtendedKeyUsage {
+		eku, ok := extKeyUsages[u]
+		if !ok {
+			return values.Certificate{}, fmt.Errorf("The provided extended key usage '%s' is not supported.", u)
+		}
+		template.ExtKeyUsage = append(template.ExtKeyUsage, eku)
+	}
+	if p.IsCA {
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+
+	parent := template
+	var signer crypto.Signer = key
+	out := values.Certificate{}
+	if p.Ca != "" {
+		ca, ok := s.latest(p.Ca)
+		if !ok || ca.Type != "certificate" {
+			return values.Certificate{}, errors.New("The request could not be completed because the credential does not exist or you do not have sufficient authorization.")
+		}
+		caValue := certificateValue(ca.Value)
+		parent, signer, err = parseKeyPair(caValue.Certificate, caValue.PrivateKey)
+		if err != nil {
+			return values.Certificate{}, err
+		}
+		out.Ca = caValue.Certificate
+		out.CaName = ca.Name
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return values.Certificate{}, err
+	}
+	out.Certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	out.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	if p.Ca == "" {
+		out.Ca = out.Certificate
+	}
+	return out, nil
+}
+
+func subject(p generate.Certificate) pkix.Name {
+	name := pkix.Name{CommonName: p.CommonName}
+	if p.Organization != "" {
+		name.Organization = []string{p.Organization}
+	}
+	if p.OrganizationUnit != "" {
+		name.OrganizationalUnit = strings.Split(p.OrganizationUnit, ",")
+	}
+	if p.Locality != "" {
+		name.Locality = []string{p.Locality}
+	}
+	if p.State != "" {
+		name.Province = []string{p.State}
+	}
+	if p.Country != "" {
+		name.Country = []string{p.Country}
+	}
+	return name
+}
+
+func generateRSA(keyLength int) (values.RSA, error) {
+	if keyLength == 0 {
+		keyLength = 2048
+	}
+	key, err := rsa.GenerateKey(rand.Reader, keyLength)
+	if err != nil {
+		return values.RSA{}, err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return values.RSA{}, err
+	}
+	return values.RSA{
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})),
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+	}, nil
+}
+
+func generateSSH(keyLength int, comment string) (map[string]string, error) {
+	pair, err := generateRSA(keyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(pair.PrivateKey))
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	if comment != "" {
+		authorized += " " + comment
+	}
+	return map[string]string{
+		"public_key":             authorized,
+		"private_key":            pair.PrivateKey,
+		"public_key_fingerprint": ssh.FingerprintSHA256(pub),
+	}, nil
+}
+
+func parseKeyPair(certPEM, keyPEM string) (*x509.Certificate, crypto.Signer, error) {
+	certBlock, _ := pem.Decode([]byte(certPEM))
+	if certBlock == nil {
+		return nil, nil, errors.New("The provided certificate value is not a valid X509 certificate.")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode([]byte(keyPEM))
+	if keyBlock == nil {
+		return nil, nil, errors.New("Private key is malformed. Key could not be parsed.")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes); err == nil {
+		return cert, key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("Private key is malformed. Key could not be parsed.")
+	}
+	return cert, signer, nil
+}
+
+type certificateInfo struct {
+	expiry     string
+	isCA       bool
+	selfSigned bool
+	caName     string
+}
+
+func parseCertificateValue(v interface{}) certificateInfo {
+	value := certificateValue(v)
+	info := certificateInfo{caName: value.CaName}
+	block, _ := pem.Decode([]byte(value.Certificate))
+	if block == nil {
+		return info
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return info
+	}
+	info.expiry = cert.NotAfter.UTC().Format(time.RFC3339)
+	info.isCA = cert.IsCA
+	info.selfSigned = cert.CheckSignatureFrom(cert) == nil
+	return info
+}
+
+func validateCertificateValue(v interface{}) error {
+	value := certificateValue(v)
+	if value.Certificate == "" {
+		return nil
+	}
+	block, _ := pem.Decode([]byte(value.Certificate))
+	if block == nil {
+		return errors.New("The provided certificate value is not a valid X509 certificate.")
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return errors.New("The provided certificate value is not a valid X509 certificate.")
+	}
+	return nil
+}
+
+func certificateValue(v interface{}) values.Certificate {
+	out := values.Certificate{}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return out
+	}
+	json.Unmarshal(b, &out)
+	return out
+}
+
+func toJSONValue(v interface{}) interface{} {
+	var out interface{}
+	b, _ := json.Marshal(v)
+	json.Unmarshal(b, &out)
+	return out
+}
@@ -0,0 +1,517 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package chfake provides an in-memory CredHub and UAA server for tests.
+// It implements the subset of the CredHub API used by the credhub-cli
+// client: info, version, token, data, certificates and permissions.
+package chfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
+	"code.cloudfoundry.org/credhub-cli/credhub/permissions"
+)
+
+// UAAPath is the path prefix the fake UAA server is mounted under
+const UAAPath = "/uaa"
+
+// Version is the CredHub server version reported by /info
+var Version = "2.5.0"
+
+// Server is a fake CredHub server backed by in-memory storage
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	creds       map[string][]*credential
+	byID        map[string]*credential
+	permissions map[string][]permissions.V1_Permission
+	users       map[string]string
+	clients     map[string]string
+	tokens      map[string]bool
+	faults      []fault
+	nextID      int
+}
+
+type credential struct {
+	ID               string      `json:"id"`
+	Name             string      `json:"name"`
+	Type             string      `json:"type"`
+	VersionCreatedAt string      `json:"version_created_at"`
+	Value            interface{} `json:"value"`
+	Transitional     bool        `json:"-"`
+}
+
+type fault struct {
+	method string
+	path   string
+	status int
+	body   string
+	raw    bool
+}
+
+// NewServer starts a fake CredHub server. Callers must call Close when done.
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(s.handler())
+	return s
+}
+
+// NewTLSServer starts a fake CredHub server using TLS. The certificate of the
+// server can be retrieved with Certificate.
+func NewTLSServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewTLSServer(s.handler())
+	return s
+}
+
+func newServer() *Server {
+	return &Server{
+		creds:       map[string][]*credential{},
+		byID:        map[string]*credential{},
+		permissions: map[string][]permissions.V1_Permission{},
+		users:       map[string]string{},
+		clients:     map[string]string{},
+		tokens:      map[string]bool{},
+	}
+}
+
+// AuthURL returns the URL of the fake UAA server
+func (s *Server) AuthURL() string {
+	return s.URL + UAAPath
+}
+
+// AddUser registers a user for the UAA password grant
+func (s *Server) AddUser(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[username] = password
+}
+
+// AddClient registers a client for the UAA client credentials grant
+func (s *Server) AddClient(clientID, clientSecret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[clientID] = clientSecret
+}
+
+// ExpireTokens marks every issued access token as expired so that the next
+// request has to refresh it
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t := range s.tokens {
+		s.tokens[t] = false
+	}
+}
+
+// Fail makes the next request matching method and path return the given
+// status code with a CredHub error body
+func (s *Server) Fail(method, path string, status int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault{method: method, path: path, status: status, body: message})
+}
+
+// Respond makes the next request matching method and path return the given
+// status code and raw body, for example to simulate a server returning stale
+// or corrupted data
+func (s *Server) Respond(method, path string, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault{method: method, path: path, status: status, body: body, raw: true})
+}
+
+// Names returns the names of all stored credentials in sorted order
+func (s *Server) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for name := range s.creds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Versions returns the stored versions of a credential, newest first
+func (s *Server) Versions(name string) []credentials.Credential {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []credentials.Credential{}
+	versions := s.creds[normalizeName(name)]
+	for i := len(versions) - 1; i >= 0; i-- {
+		out = append(out, versions[i].public())
+	}
+	return out
+}
+
+// SetTransitional sets the transitional flag of a credential version
+func (s *Server) SetTransitional(id string, transitional bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.byID[id]; ok {
+		c.Transitional = transitional
+	}
+}
+
+// PutCertificate stores a certificate credential directly, bypassing the API
+func (s *Server) PutCertificate(name, ca, certificate, privateKey string) credentials.Credential {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.store(name, "certificate", map[string]interface{}{
+		"ca":          ca,
+		"certificate": certificate,
+		"private_key": privateKey,
+	})
+	return c.public()
+}
+
+func (c *credential) public() credentials.Credential {
+	out := credentials.Credential{Value: c.Value}
+	out.Id = c.ID
+	out.Name = c.Name
+	out.Type = c.Type
+	out.VersionCreatedAt = c.VersionCreatedAt
+	return out
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info", s.handleInfo)
+	mux.HandleFunc("/version", s.authorized(s.handleVersion))
+	mux.HandleFunc(UAAPath+"/info", s.handleUAAInfo)
+	mux.HandleFunc(UAAPath+"/oauth/token", s.handleToken)
+	mux.HandleFunc(UAAPath+"/oauth/token/revoke/", s.handleRevoke)
+	mux.HandleFunc("/api/v1/data", s.authorized(s.handleData))
+	mux.HandleFunc("/api/v1/data/", s.authorized(s.handleDataByID))
+	mux.HandleFunc("/api/v1/certificates/", s.authorized(s.handleCertificates))
+	mux.HandleFunc("/api/v1/permissions", s.authorized(s.handlePermissions))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f, ok := s.takeFault(r); ok {
+			if f.raw {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(f.status)
+				w.Write([]byte(f.body))
+				return
+			}
+			writeError(w, f.status, f.body)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) takeFault(r *http.Request) (fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.faults {
+		if f.method == r.Method && f.path == r.URL.Path {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			return f, true
+		}
+	}
+	return fault{}, false
+}
+
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		valid, known := s.tokens[token]
+		s.mu.Unlock()
+		switch {
+		case !known:
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token", "error_description": "Full authentication is required to access this resource"})
+		case !valid:
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "access_token_expired", "error_description": "Access token expired"})
+		default:
+			next(w, r)
+		}
+	}
+}
+
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"app":         map[string]string{"name": "CredHub", "version": Version},
+		"auth-server": map[string]string{"url": s.AuthURL()},
+	})
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"version": Version})
+}
+
+func (s *Server) handleData(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getData(w, r)
+	case http.MethodPost:
+		s.generateData(w, r)
+	case http.MethodPut:
+		s.setData(w, r)
+	case http.MethodDelete:
+		s.deleteData(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) getData(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := normalizeName(q.Get("name"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name == "/" {
+		// find by path, used by `credhub find`
+		found := []credentials.Base{}
+		path := q.Get("path")
+		for n, versions := range s.creds {
+			if strings.HasPrefix(n, path) {
+				latest := versions[len(versions)-1]
+				found = append(found, credentials.Base{Name: n, VersionCreatedAt: latest.VersionCreatedAt})
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"credentials": found})
+		return
+	}
+
+	versions, ok := s.creds[name]
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+
+	out := []*credential{}
+	for i := len(versions) - 1; i >= 0; i-- {
+		out = append(out, versions[i])
+	}
+	if q.Get("current") == "true" {
+		out = out[:1]
+	} else if v := q.Get("versions"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "The number of versions must be a positive integer.")
+			return
+		}
+		if n < len(out) {
+			out = out[:n]
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": out})
+}
+
+func (s *Server) handleDataByID(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/data/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.byID[id]
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (s *Server) setData(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name  string          `json:"name"`
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "The request could not be fulfilled because the request path or body did not meet expectation.")
+		return
+	}
+	if body.Name == "" {
+		writeError(w, http.StatusBadRequest, "A credential name must be provided. Please validate your input and retry your request.")
+		return
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body.Value, &value); err != nil {
+		writeError(w, http.StatusBadRequest, "The request includes an unrecognized parameter 'value'.")
+		return
+	}
+	if body.Type == "certificate" {
+		if err := validateCertificateValue(value); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, s.store(body.Name, body.Type, value))
+}
+
+func (s *Server) deleteData(w http.ResponseWriter, r *http.Request) {
+	name := normalizeName(r.URL.Query().Get("name"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions, ok := s.creds[name]
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	for _, v := range versions {
+		delete(s.byID, v.ID)
+	}
+	delete(s.creds, name)
+	delete(s.permissions, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleCertificates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filter := r.URL.Query().Get("name")
+	out := []credentials.CertificateMetadata{}
+	for _, name := range s.sortedNames() {
+		if filter != "" && name != normalizeName(filter) {
+			continue
+		}
+		versions := s.creds[name]
+		if versions[len(versions)-1].Type != "certificate" {
+			continue
+		}
+		md := credentials.CertificateMetadata{Id: versions[0].ID, Name: name, Signs: []string{}}
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := versions[i]
+			info := parseCertificateValue(v.Value)
+			md.SignedBy = info.caName
+			md.Versions = append(md.Versions, credentials.CertificateMetadataVersion{
+				Id:                   v.ID,
+				ExpiryDate:           info.expiry,
+				Transitional:         v.Transitional,
+				CertificateAuthority: info.isCA,
+				SelfSigned:           info.selfSigned,
+			})
+		}
+		out = append(out, md)
+	}
+	if filter != "" && len(out) == 0 {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"certificates": out})
+}
+
+func (s *Server) handlePermissions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		name := normalizeName(r.URL.Query().Get("credential_name"))
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.creds[name]; !ok {
+			writeNotFound(w)
+			return
+		}
+		perms := s.permissions[name]
+		if perms == nil {
+			perms = []permissions.V1_Permission{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"credential_name": name, "permissions": perms})
+	case http.MethodPost:
+		var body struct {
+			CredentialName string                      `json:"credential_name"`
+			Permissions    []permissions.V1_Permission `json:"permissions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "The request could not be fulfilled because the request path or body did not meet expectation.")
+			return
+		}
+		name := normalizeName(body.CredentialName)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.creds[name]; !ok {
+			writeNotFound(w)
+			return
+		}
+		s.permissions[name] = append(s.permissions[name], body.Permissions...)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// store appends a new version of a credential. The caller must hold s.mu.
+func (s *Server) store(name, credType string, value interface{}) *credential {
+	name = normalizeName(name)
+	s.nextID++
+	c := &credential{
+		ID:               fmt.Sprintf("00000000-0000-0000-0000-%012d", s.nextID),
+		Name:             name,
+		Type:             credType,
+		VersionCreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Value:            value,
+	}
+	s.creds[name] = append(s.creds[name], c)
+	s.byID[c.ID] = c
+	return c
+}
+
+func (s *Server) latest(name string) (*credential, bool) {
+	versions, ok := s.creds[normalizeName(name)]
+	if !ok {
+		return nil, false
+	}
+	return versions[len(versions)-1], true
+}
+
+func (s *Server) sortedNames() []string {
+	names := []string{}
+	for name := range s.creds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func normalizeName(name string) string {
+	if !strings.HasPrefix(name, "/") {
+		return "/" + name
+	}
+	return name
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, "The request could not be completed because the credential does not exist or you do not have sufficient authorization.")
+}
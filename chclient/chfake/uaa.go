@@ -0,0 +1,133 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chfake
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (s *Server) handleUAAInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"app":     map[string]string{"version": "4.30.0"},
+		"links":   map[string]string{"login": s.AuthURL()},
+		"prompts": map[string][]string{"passcode": {"password", "One Time Code"}},
+	})
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeUAAError(w, "invalid_request", err.Error())
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	clientSecret := r.PostForm.Get("client_secret")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refresh := ""
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+		secret, ok := s.clients[clientID]
+		if !ok || secret != clientSecret {
+			writeUAAError(w, "unauthorized", "Bad credentials")
+			return
+		}
+	case "password":
+		username := r.PostForm.Get("username")
+		password, ok := s.users[username]
+		if !ok || password != r.PostForm.Get("password") {
+			writeUAAError(w, "unauthorized", "Bad credentials")
+			return
+		}
+		refresh = s.issueToken(clientID, username+"-r")
+	case "refresh_token":
+		if _, ok := s.tokens[r.PostForm.Get("refresh_token")]; !ok {
+			writeUAAError(w, "invalid_token", "Invalid refresh token")
+			return
+		}
+		refresh = r.PostForm.Get("refresh_token")
+	default:
+		writeUAAError(w, "unsupported_grant_type", "Unsupported grant type")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  s.issueToken(clientID, "a"),
+		"refresh_token": refresh,
+		"token_type":    "bearer",
+		"expires_in":    43199,
+	})
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	jti := strings.TrimPrefix(r.URL.Path, UAAPath+"/oauth/token/revoke/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t := range s.tokens {
+		if tokenID(t) == jti {
+			delete(s.tokens, t)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// issueToken creates an unsigned JWT shaped token. The caller must hold s.mu.
+func (s *Server) issueToken(clientID, suffix string) string {
+	s.nextID++
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"jti":       fmt.Sprintf("%d-%s", s.nextID, suffix),
+		"client_id": clientID,
+		"exp":       time.Now().Add(12 * time.Hour).Unix(),
+	})
+	token := header + "." + base64.RawURLEncoding.EncodeToString(claims) + "."
+	s.tokens[token] = true
+	return token
+}
+
+func tokenID(token string) string {
+	segments := strings.Split(token, ".")
+	if len(segments) < 2 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(segments[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		JTI string `json:"jti"`
+	}
+	json.Unmarshal(payload, &claims)
+	return claims.JTI
+}
+
+func writeUAAError(w http.ResponseWriter, name, description string) {
+	writeJSON(w, http.StatusUnauthorized, map[string]string{"error": name, "error_description": description})
+}
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -31,7 +32,10 @@ import (
 	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
 	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
 	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
+	"github.com/newcontext-oss/credhub-venafi/httpclient"
 	"github.com/newcontext-oss/credhub-venafi/output"
+	"github.com/newcontext-oss/credhub-venafi/retry"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
 )
 
 // ConfigLoader has configuration location info and methods to load the config
@@ -72,11 +76,20 @@ type CVConfig struct {
 
 // ICredhubProxy defines the interface for the proxy to communicate with Credhub
 type ICredhubProxy interface {
-	GenerateCertificate(name string, parameters generate.Certificate, overwrite credhub.Mode) (credentials.Certificate, error)
-	PutCertificate(certName string, ca string, certificate string, privateKey string) error
-	DeleteCert(name string) error
-	List() ([]credentials.CertificateMetadata, error)
-	GetCertificate(name string) (credentials.Certificate, error)
+	secretstore.Store
+}
+
+var _ ICredhubProxy = (*CredhubProxy)(nil)
+
+// UnsupportedError lists the fields of a certificate request that a platform
+// cannot honor
+type UnsupportedError struct {
+	Platform string
+	Fields   []string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s cannot generate a certificate with %s", e.Platform, strings.Join(e.Fields, ", "))
 }
 
 // CredhubProxy contains the config information for the Credhub request proxy
@@ -92,13 +105,34 @@ type CredhubProxy struct {
 	Client            *credhub.CredHub
 	ConfigPath        string
 	SkipTLSValidation bool
+	// CACerts are PEM certificates trusted for CredHub and UAA in addition
+	// to the system roots
+	CACerts []string
+	// ClientCert and ClientKey are the files of a certificate presented to
+	// CredHub for mutual TLS
+	ClientCert string
+	ClientKey  string
+	// HTTP sets the proxy and timeouts for CredHub and UAA
+	HTTP httpclient.Settings
+}
+
+// Name returns CredHub
+func (cp *CredhubProxy) Name() string {
+	return "CredHub"
 }
 
 // GenerateCertificate generates a certificate in CredHub
-func (cp *CredhubProxy) GenerateCertificate(name string, parameters generate.Certificate, overwrite credhub.Mode) (credentials.Certificate, error) {
-	newCert, err := cp.Client.GenerateCertificate(name, parameters, overwrite)
+func (cp *CredhubProxy) GenerateCertificate(name string, parameters secretstore.GenerateParameters, overwrite bool) (secretstore.Certificate, error) {
+	mode := credhub.NoOverwrite
+	if overwrite {
+		mode = credhub.Overwrite
+	}
+	newCert, err := cp.Client.GenerateCertificate(name, toParameters(parameters), mode)
 	output.Verbose("newCert %+v", newCert)
-	return newCert, err
+	if err != nil {
+		return secretstore.Certificate{}, err
+	}
+	return toCertificate(newCert), cp.verifyCertificate(name, newCert.Value.Certificate)
 }
 
 // PutCertificate uploads a certificate to CredHub
@@ -107,36 +141,164 @@ func (cp *CredhubProxy) PutCertificate(certName string, ca string, certificate s
 	c.Ca = ca
 	c.Certificate = certificate
 	c.PrivateKey = privateKey
-	newCert, err := cp.Client.SetCertificate(certName, c)
-	_ = newCert
+	_, err := cp.Client.SetCertificate(certName, c)
+	if err != nil {
+		return fmt.Errorf("could not store certificate %s in CredHub: %s", certName, err)
+	}
+	return cp.verifyCertificate(certName, certificate)
+}
+
+// GetCertificateVersions returns all versions of a certificate, newest first
+func (cp *CredhubProxy) GetCertificateVersions(name string) ([]secretstore.Certificate, error) {
+	creds, err := cp.Client.GetAllVersions(name)
+	if err != nil {
+		return nil, storeError(name, err)
+	}
+	certs := []secretstore.Certificate{}
+	for _, cred := range creds {
+		if cred.Type != "certificate" {
+			continue
+		}
+		// the value of a generic credential is a map, so convert it through json
+		b, err := json.Marshal(cred)
+		if err != nil {
+			return nil, err
+		}
+		cert := credentials.Certificate{}
+		err = json.Unmarshal(b, &cert)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse version %s of %s: %s", cred.Id, name, err)
+		}
+		certs = append(certs, toCertificate(cert))
+	}
+	return certs, nil
+}
+
+// GetCertificateMetadata returns the metadata of a certificate, including the
+// expiry and transitional flag of each version
+func (cp *CredhubProxy) GetCertificateMetadata(name string) (secretstore.CertificateMetadata, error) {
+	resp, err := cp.Client.Request("GET", "/api/v1/certificates", url.Values{"name": []string{name}}, nil, true)
 	if err != nil {
-		return nil
+		return secretstore.CertificateMetadata{}, storeError(name, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Certificates []credentials.CertificateMetadata `json:"certificates"`
 	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return secretstore.CertificateMetadata{}, err
+	}
+	for _, md := range body.Certificates {
+		if md.Name == name || md.Name == "/"+strings.TrimPrefix(name, "/") {
+			return toMetadata(md), nil
+		}
+	}
+	return secretstore.CertificateMetadata{}, fmt.Errorf("no certificate metadata for %s", name)
+}
+
+// verifyCertificate reads back the latest version of a certificate and checks
+// that its thumbprint matches the one that was written
+func (cp *CredhubProxy) verifyCertificate(name string, expected string) error {
+	want, err := GetThumbprint(expected)
+	if err != nil {
+		return fmt.Errorf("could not calculate thumbprint of certificate written to %s: %s", name, err)
+	}
+	cred, err := cp.Client.GetLatestCertificate(name)
+	if err != nil {
+		return fmt.Errorf("could not read back certificate %s from CredHub: %s", name, err)
+	}
+	got, err := GetThumbprint(cred.Value.Certificate)
+	if err != nil {
+		return fmt.Errorf("could not calculate thumbprint of certificate read back from %s: %s", name, err)
+	}
+	if got != want {
+		return fmt.Errorf("verification of %s failed: CredHub has thumbprint %x, expected %x", name, got, want)
+	}
+	output.Verbose("verified %s in CredHub with thumbprint %x", name, got)
 	return nil
 }
 
 // DeleteCert deletes a certificate from CredHub
 func (cp *CredhubProxy) DeleteCert(name string) error {
-	return cp.Client.Delete(name)
+	return storeError(name, cp.Client.Delete(name))
 }
 
 // List lists certificates on CredHub
-func (cp *CredhubProxy) List() ([]credentials.CertificateMetadata, error) {
+func (cp *CredhubProxy) List() ([]secretstore.CertificateMetadata, error) {
 	certs, err := cp.Client.GetAllCertificatesMetadata()
 	if err != nil {
 		return nil, err
 	}
 
-	return certs, nil
+	out := []secretstore.CertificateMetadata{}
+	for _, md := range certs {
+		out = append(out, toMetadata(md))
+	}
+	return out, nil
 }
 
 // GetCertificate downloads a certificate from CredHub
-func (cp *CredhubProxy) GetCertificate(name string) (credentials.Certificate, error) {
+func (cp *CredhubProxy) GetCertificate(name string) (secretstore.Certificate, error) {
 	cred, err := cp.Client.GetLatestCertificate(name)
 	if err != nil {
-		return credentials.Certificate{}, err
+		return secretstore.Certificate{}, storeError(name, err)
+	}
+	return toCertificate(cred), nil
+}
+
+// storeError translates the error CredHub returns for a credential it does
+// not hold into secretstore.ErrNotFound
+func storeError(name string, err error) error {
+	if _, notFound := err.(*credhub.NotFoundError); notFound {
+		return fmt.Errorf("%s: %w", name, secretstore.ErrNotFound)
+	}
+	return err
+}
+
+func toCertificate(c credentials.Certificate) secretstore.Certificate {
+	return secretstore.Certificate{
+		ID:          c.Id,
+		Name:        c.Name,
+		CreatedAt:   c.VersionCreatedAt,
+		CA:          c.Value.Ca,
+		Certificate: c.Value.Certificate,
+		PrivateKey:  c.Value.PrivateKey,
+	}
+}
+
+func toMetadata(md credentials.CertificateMetadata) secretstore.CertificateMetadata {
+	out := secretstore.CertificateMetadata{ID: md.Id, Name: md.Name, SignedBy: md.SignedBy}
+	for _, v := range md.Versions {
+		out.Versions = append(out.Versions, secretstore.CertificateVersion{
+			ID:                   v.Id,
+			ExpiryDate:           v.ExpiryDate,
+			Transitional:         v.Transitional,
+			CertificateAuthority: v.CertificateAuthority,
+			SelfSigned:           v.SelfSigned,
+		})
+	}
+	return out
+}
+
+func toParameters(p secretstore.GenerateParameters) generate.Certificate {
+	return generate.Certificate{
+		KeyLength:        p.KeyLength,
+		CommonName:       p.CommonName,
+		Organization:     p.Organization,
+		OrganizationUnit: p.OrganizationUnit,
+		Locality:         p.Locality,
+		State:            p.State,
+		Country:          p.Country,
+		AlternativeNames: p.AlternativeNames,
+		ExtendedKeyUsage: p.ExtendedKeyUsage,
+		KeyUsage:         p.KeyUsage,
+		Duration:         p.Duration,
+		Ca:               p.Ca,
+		SelfSign:         p.SelfSign,
+		IsCA:             p.IsCA,
 	}
-	return cred, nil
 }
 
 // GetThumbprint calculates the thumbprint of a certificate in CredHub
@@ -153,34 +315,81 @@ func GetThumbprint(cert string) ([sha1.Size]byte, error) {
 	return sha1.Sum(data), nil
 }
 
-// AuthExisting authenticates an existing CredHub client
+// tlsOptions returns the options that configure TLS for CredHub and UAA
+func (cp *CredhubProxy) tlsOptions() []credhub.Option {
+	options := []credhub.Option{credhub.SkipTLSValidation(cp.SkipTLSValidation)}
+	if len(cp.CACerts) > 0 {
+		options = append(options, credhub.CaCerts(cp.CACerts...))
+	}
+	if cp.ClientCert != "" {
+		options = append(options, credhub.ClientCert(cp.ClientCert, cp.ClientKey))
+	}
+	return options
+}
+
+// AuthExisting authenticates an existing CredHub client. Without a token the
+// client relies on the client certificate alone.
 func (cp *CredhubProxy) AuthExisting() error {
+	options := cp.tlsOptions()
+	if cp.AccessToken != "" || cp.RefreshToken != "" || cp.ClientCert == "" {
+		options = append(options,
+			credhub.Auth(auth.Uaa(
+				cp.ClientID,
+				cp.ClientSecret,
+				cp.Username,
+				cp.Password,
+				cp.AccessToken,
+				cp.RefreshToken,
+				false,
+			)),
+			credhub.AuthURL(cp.AuthURL),
+		)
+	}
 	var err error
-	cp.Client, err = credhub.New(cp.BaseURL,
-		credhub.SkipTLSValidation(cp.SkipTLSValidation),
-		credhub.Auth(auth.Uaa(
-			cp.ClientID,
-			cp.ClientSecret,
-			cp.Username,
-			cp.Password,
-			cp.AccessToken,
-			cp.RefreshToken,
-			false,
-		)),
-		credhub.AuthURL(cp.AuthURL),
-	)
+	cp.Client, err = credhub.New(cp.BaseURL, options...)
+	if err != nil {
+		return err
+	}
+	cp.configureClient(cp.Client)
+	return nil
+}
 
-	return err
+// AuthClient authenticates a new CredHub client with the client credentials
+// grant. Unlike Auth the tokens are not saved, so that further CredHubs can
+// be used next to the one of `cv login`. Without a client id the client
+// relies on the client certificate alone.
+func (cp *CredhubProxy) AuthClient() error {
+	options := cp.tlsOptions()
+	if cp.ClientID != "" {
+		options = append(options, credhub.Auth(auth.UaaClientCredentials(cp.ClientID, cp.ClientSecret)))
+	}
+	var err error
+	cp.Client, err = credhub.New(cp.BaseURL, options...)
+	if err != nil {
+		return err
+	}
+	cp.configureClient(cp.Client)
+	return nil
+}
+
+// configureClient applies the HTTP settings to the client shared by CredHub
+// and UAA, and makes it return 429 and 5xx responses as a retry.StatusError.
+// The CredHub client drops their status otherwise, which is needed to tell
+// whether a failed call can be retried.
+func (cp *CredhubProxy) configureClient(ch *credhub.CredHub) {
+	client := ch.Client()
+	cp.HTTP.Apply(client)
+	client.Transport = &retry.Transport{Base: client.Transport}
 }
 
 // Auth authenticates a new CredHub client
 func (cp *CredhubProxy) Auth() error {
 	ch, err := credhub.New(cp.BaseURL,
-		credhub.SkipTLSValidation(cp.SkipTLSValidation),
-		credhub.Auth(auth.UaaPassword(cp.ClientID, cp.ClientSecret, cp.Username, cp.Password)))
+		append(cp.tlsOptions(), credhub.Auth(auth.UaaPassword(cp.ClientID, cp.ClientSecret, cp.Username, cp.Password)))...)
 	if err != nil {
 		return err
 	}
+	cp.configureClient(ch)
 	AuthURL, err := ch.AuthURL()
 	if err != nil {
 		return err
@@ -238,8 +447,7 @@ func (cp *CredhubProxy) Auth() error {
 	}
 
 	cp.Client, err = credhub.New(cp.BaseURL,
-		credhub.SkipTLSValidation(cp.SkipTLSValidation),
-		credhub.Auth(auth.Uaa(
+		append(cp.tlsOptions(), credhub.Auth(auth.Uaa(
 			cp.ClientID,
 			cp.ClientSecret,
 			cp.Username,
@@ -247,10 +455,10 @@ func (cp *CredhubProxy) Auth() error {
 			cp.AccessToken,
 			cp.RefreshToken,
 			false,
-		)),
-	)
+		)))...)
 	if err != nil {
 		return err
 	}
+	cp.configureClient(cp.Client)
 	return nil
 }
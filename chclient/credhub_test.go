@@ -0,0 +1,324 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chclient_test
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
+	"github.com/newcontext-oss/credhub-venafi/chclient"
+	"github.com/newcontext-oss/credhub-venafi/chclient/chfake"
+	"github.com/newcontext-oss/credhub-venafi/retry"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
+	"github.com/stretchr/testify/assert"
+)
+
+// withHome points the user home directory at a temporary directory
+func withHome(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cvhome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Getenv("HOME")
+	os.Setenv("HOME", dir)
+	t.Cleanup(func() {
+		os.Setenv("HOME", old)
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func newFakeProxy(t *testing.T) (*chfake.Server, *chclient.CredhubProxy) {
+	server := chfake.NewServer()
+	t.Cleanup(server.Close)
+	server.AddUser("credhub", "password")
+	server.AddClient("cv_client", "secret")
+	withHome(t)
+
+	cp := &chclient.CredhubProxy{BaseURL: server.URL, ClientID: "cv_client", ClientSecret: "secret", ConfigPath: ".cv"}
+	if err := cp.Auth(); err != nil {
+		t.Fatal(err)
+	}
+	return server, cp
+}
+
+func TestAuthWithPassword(t *testing.T) {
+	server := chfake.NewServer()
+	defer server.Close()
+	server.AddUser("credhub", "password")
+	home := withHome(t)
+
+	cp := &chclient.CredhubProxy{BaseURL: server.URL, Username: "credhub", Password: "password", ConfigPath: ".cv"}
+	err := cp.Auth()
+	assert.Nil(t, err, "It should authenticate with a username and password")
+	assert.NotEmpty(t, cp.AccessToken, "It should receive an access token")
+	assert.NotEmpty(t, cp.RefreshToken, "It should receive a refresh token")
+
+	loader := chclient.ConfigLoader{UserHomeDir: home, CVConfigDir: ".cv", ConfigFilename: "config.json"}
+	c, err := loader.ReadConfig()
+	assert.Nil(t, err, "It should write the config file")
+	assert.Equal(t, cp.AccessToken, c.AccessToken, "It should store the access token")
+	assert.Equal(t, server.AuthURL(), c.AuthURL, "It should store the auth URL")
+}
+
+func TestAuthWithBadPassword(t *testing.T) {
+	server := chfake.NewServer()
+	defer server.Close()
+	server.AddUser("credhub", "password")
+	withHome(t)
+
+	cp := &chclient.CredhubProxy{BaseURL: server.URL, Username: "credhub", Password: "wrong", ConfigPath: ".cv"}
+	assert.NotNil(t, cp.Auth(), "It should raise an error with bad credentials")
+}
+
+func TestAuthExisting(t *testing.T) {
+	server := chfake.NewServer()
+	defer server.Close()
+	server.AddUser("credhub", "password")
+	server.PutCertificate("/existing", "", "", "")
+	withHome(t)
+
+	cp := &chclient.CredhubProxy{BaseURL: server.URL, Username: "credhub", Password: "password", ConfigPath: ".cv"}
+	assert.Nil(t, cp.Auth())
+
+	existing := &chclient.CredhubProxy{BaseURL: server.URL, AuthURL: server.AuthURL(), ClientID: cp.ClientID, AccessToken: cp.AccessToken, RefreshToken: cp.RefreshToken}
+	assert.Nil(t, existing.AuthExisting(), "It should create a client from stored tokens")
+	certs, err := existing.List()
+	assert.Nil(t, err, "It should use the stored access token")
+	assert.Len(t, certs, 1)
+
+	server.ExpireTokens()
+	_, err = existing.List()
+	assert.Nil(t, err, "It should refresh an expired access token")
+}
+
+func TestGenerateAndGetCertificate(t *testing.T) {
+	_, cp := newFakeProxy(t)
+
+	params := secretstore.GenerateParameters{CommonName: "example.com", AlternativeNames: []string{"www.example.com"}, SelfSign: true}
+	generated, err := cp.GenerateCertificate("/cert", params, true)
+	assert.Nil(t, err, "It should generate a certificate")
+	assert.Contains(t, generated.Certificate, "BEGIN CERTIFICATE")
+
+	cert, err := cp.GetCertificate("/cert")
+	assert.Nil(t, err, "It should get the certificate")
+	assert.Equal(t, generated.Certificate, cert.Certificate)
+	assert.Equal(t, generated.PrivateKey, cert.PrivateKey)
+
+	_, err = cp.GetCertificate("/missing")
+	assert.True(t, errors.Is(err, secretstore.ErrNotFound), "It should raise ErrNotFound for a missing certificate")
+}
+
+func TestPutCertificateAndList(t *testing.T) {
+	server, cp := newFakeProxy(t)
+
+	ca, err := cp.GenerateCertificate("/ca", secretstore.GenerateParameters{CommonName: "ca", IsCA: true}, true)
+	assert.Nil(t, err)
+	leaf, err := cp.GenerateCertificate("/leaf", secretstore.GenerateParameters{CommonName: "leaf", Ca: "/ca"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, ca.Certificate, leaf.CA, "It should sign with the stored CA")
+
+	err = cp.PutCertificate("/copy", leaf.CA, leaf.Certificate, leaf.PrivateKey)
+	assert.Nil(t, err, "It should put a certificate")
+	assert.Len(t, server.Versions("/copy"), 1)
+
+	certs, err := cp.List()
+	assert.Nil(t, err, "It should list certificates")
+	names := []string{}
+	for _, c := range certs {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"/ca", "/copy", "/leaf"}, names)
+	assert.True(t, certs[0].Versions[0].CertificateAuthority, "It should report CA certificates")
+	assert.Equal(t, "/ca", certs[2].SignedBy, "It should report the signing CA")
+	assert.NotEmpty(t, certs[2].Versions[0].ExpiryDate)
+}
+
+func TestPutCertificateError(t *testing.T) {
+	server, cp := newFakeProxy(t)
+	server.Fail(http.MethodPut, "/api/v1/data", http.StatusInternalServerError, "boom")
+
+	err := cp.PutCertificate("/failed", "", "", "")
+	assert.NotNil(t, err, "It should raise an error when the server fails")
+	assert.Contains(t, err.Error(), "boom")
+	assert.Empty(t, server.Versions("/failed"), "It should not store a certificate when the server fails")
+}
+
+func TestPutCertificateVerification(t *testing.T) {
+	server, cp := newFakeProxy(t)
+	generated, err := cp.GenerateCertificate("/generated", secretstore.GenerateParameters{CommonName: "generated", SelfSign: true}, true)
+	assert.Nil(t, err, "It should verify a generated certificate")
+
+	assert.Nil(t, cp.PutCertificate("/verified", generated.CA, generated.Certificate, generated.PrivateKey), "It should verify a stored certificate")
+
+	_, err = cp.GenerateCertificate("/other", secretstore.GenerateParameters{CommonName: "other", SelfSign: true}, true)
+	assert.Nil(t, err)
+	stale, _ := json.Marshal(map[string]interface{}{"data": []interface{}{server.Versions("/other")[0]}})
+	server.Respond(http.MethodGet, "/api/v1/data", http.StatusOK, string(stale))
+	err = cp.PutCertificate("/verified", generated.CA, generated.Certificate, generated.PrivateKey)
+	assert.NotNil(t, err, "It should raise an error when the stored certificate does not match")
+	assert.Contains(t, err.Error(), "verification of /verified failed")
+
+	server.Fail(http.MethodGet, "/api/v1/data", http.StatusInternalServerError, "read failed")
+	err = cp.PutCertificate("/verified", generated.CA, generated.Certificate, generated.PrivateKey)
+	assert.NotNil(t, err, "It should raise an error when the certificate can't be read back")
+}
+
+func TestAuthWithCACert(t *testing.T) {
+	server := chfake.NewTLSServer()
+	t.Cleanup(server.Close)
+	server.AddClient("cv_client", "secret")
+	withHome(t)
+
+	cp := &chclient.CredhubProxy{BaseURL: server.URL, ClientID: "cv_client", ClientSecret: "secret", ConfigPath: ".cv"}
+	assert.NotNil(t, cp.Auth(), "It should not trust an unknown CA")
+
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	cp = &chclient.CredhubProxy{BaseURL: server.URL, ClientID: "cv_client", ClientSecret: "secret", ConfigPath: ".cv", CACerts: []string{ca}}
+	assert.Nil(t, cp.Auth(), "It should trust the configured CA for CredHub and UAA")
+	_, err := cp.List()
+	assert.Nil(t, err)
+
+	cp = &chclient.CredhubProxy{BaseURL: server.URL, CACerts: []string{ca}, ClientCert: "missing.crt", ClientKey: "missing.key"}
+	assert.NotNil(t, cp.AuthExisting(), "It should raise an error when the client certificate can't be read")
+}
+
+func TestRetryProxy(t *testing.T) {
+	server, cp := newFakeProxy(t)
+	r := retry.New("CredHub", retry.Policy{Attempts: 3, Budget: 10})
+	r.Sleep = func(time.Duration) {}
+	rp := &secretstore.RetryProxy{Proxy: cp, Retrier: r}
+	server.PutCertificate("/retried", "", "", "")
+
+	server.Fail(http.MethodGet, "/api/v1/data", http.StatusBadGateway, "bad gateway")
+	_, err := rp.GetCertificate("/retried")
+	assert.Nil(t, err, "It should retry a read that failed with a 502")
+
+	server.Fail(http.MethodDelete, "/api/v1/data", http.StatusInternalServerError, "boom")
+	err = rp.DeleteCert("/retried")
+	assert.NotNil(t, err, "It should not retry a delete the server may have processed")
+	assert.NotEmpty(t, server.Versions("/retried"))
+
+	server.Fail(http.MethodDelete, "/api/v1/data", http.StatusServiceUnavailable, "maintenance")
+	assert.Nil(t, rp.DeleteCert("/retried"), "It should retry a delete the server refused")
+	assert.Equal(t, 2, r.Retries())
+}
+
+func TestDeleteCert(t *testing.T) {
+	server, cp := newFakeProxy(t)
+	server.PutCertificate("/gone", "", "", "")
+
+	assert.Nil(t, cp.DeleteCert("/gone"), "It should delete a certificate")
+	assert.Empty(t, server.Names())
+	assert.NotNil(t, cp.DeleteCert("/gone"), "It should raise an error for a missing certificate")
+}
+
+func TestAuthClient(t *testing.T) {
+	server := chfake.NewServer()
+	defer server.Close()
+	server.AddClient("foundation_b", "secret")
+	server.PutCertificate("/existing", "", "", "")
+	home := withHome(t)
+
+	cp := &chclient.CredhubProxy{BaseURL: server.URL, ClientID: "foundation_b", ClientSecret: "secret"}
+	assert.Nil(t, cp.AuthClient(), "It should authenticate with client credentials")
+	certs, err := cp.List()
+	assert.Nil(t, err, "It should request a token for the client")
+	assert.Len(t, certs, 1)
+	_, err = os.Stat(filepath.Join(home, ".cv"))
+	assert.True(t, os.IsNotExist(err), "It should not save the tokens")
+
+	cp = &chclient.CredhubProxy{BaseURL: server.URL, ClientID: "foundation_b", ClientSecret: "wrong"}
+	assert.Nil(t, cp.AuthClient())
+	_, err = cp.List()
+	assert.NotNil(t, err, "It should raise an error with bad credentials")
+}
+
+func TestAuthWritesConfigDir(t *testing.T) {
+	server := chfake.NewServer()
+	defer server.Close()
+	server.AddClient("cv_client", "secret")
+	home := withHome(t)
+
+	cp := &chclient.CredhubProxy{BaseURL: server.URL, ClientID: "cv_client", ClientSecret: "secret", ConfigPath: ".cv"}
+	assert.Nil(t, cp.Auth(), "It should authenticate with client credentials")
+	_, err := os.Stat(filepath.Join(home, ".cv", "config.json"))
+	assert.Nil(t, err, "It should create the config file")
+}
+
+func TestGetCertificateVersions(t *testing.T) {
+	server, cp := newFakeProxy(t)
+	first, err := cp.GenerateCertificate("/versioned", secretstore.GenerateParameters{CommonName: "versioned", SelfSign: true}, true)
+	assert.Nil(t, err)
+	second, err := cp.GenerateCertificate("/versioned", secretstore.GenerateParameters{CommonName: "versioned", SelfSign: true}, true)
+	assert.Nil(t, err)
+	server.SetTransitional(first.ID, true)
+
+	versions, err := cp.GetCertificateVersions("/versioned")
+	assert.Nil(t, err, "It should get all versions")
+	assert.Len(t, versions, 2)
+	assert.Equal(t, second.Certificate, versions[0].Certificate, "It should return the newest version first")
+	assert.Equal(t, first.PrivateKey, versions[1].PrivateKey)
+
+	md, err := cp.GetCertificateMetadata("/versioned")
+	assert.Nil(t, err, "It should get the metadata of one certificate")
+	assert.Len(t, md.Versions, 2)
+	assert.Equal(t, first.ID, md.Versions[1].ID)
+	assert.True(t, md.Versions[1].Transitional)
+
+	_, err = cp.GetCertificateVersions("/missing")
+	assert.NotNil(t, err)
+	_, err = cp.GetCertificateMetadata("/missing")
+	assert.NotNil(t, err)
+}
+
+func TestListKeys(t *testing.T) {
+	_, cp := newFakeProxy(t)
+	rsaKey, err := cp.Client.GenerateRSA("/keys/rsa", generate.RSA{KeyLength: 2048}, credhub.Overwrite)
+	assert.Nil(t, err)
+	sshKey, err := cp.Client.GenerateSSH("/keys/ssh", generate.SSH{KeyLength: 2048, Comment: "cv"}, credhub.Overwrite)
+	assert.Nil(t, err)
+	_, err = cp.GenerateCertificate("/keys/cert", secretstore.GenerateParameters{CommonName: "cert", SelfSign: true}, true)
+	assert.Nil(t, err)
+	_, err = cp.Client.GenerateRSA("/other/rsa", generate.RSA{KeyLength: 2048}, credhub.Overwrite)
+	assert.Nil(t, err)
+
+	keys, err := cp.ListKeys("/keys", chclient.KeyTypes)
+	assert.Nil(t, err, "It should list the keys below the path")
+	assert.Len(t, keys, 2, "It should skip other credential types and paths")
+	byName := map[string]secretstore.Key{}
+	for _, k := range keys {
+		byName[k.Name] = k
+	}
+	assert.Equal(t, "rsa", byName["/keys/rsa"].Type)
+	assert.Equal(t, sshKey.Value.PublicKeyFingerprint, byName["/keys/ssh"].Fingerprint, "It should fingerprint ssh keys like ssh-keygen")
+	fp, err := chclient.KeyFingerprint(rsaKey.Value.PublicKey)
+	assert.Nil(t, err, "It should fingerprint PEM public keys")
+	assert.Equal(t, fp, byName["/keys/rsa"].Fingerprint)
+
+	keys, err = cp.ListKeys("/keys", []string{"ssh"})
+	assert.Nil(t, err)
+	assert.Len(t, keys, 1, "It should only list the requested types")
+	assert.Equal(t, "/keys/ssh", keys[0].Name)
+}
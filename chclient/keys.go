@@ -0,0 +1,99 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chclient
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyTypes are the CredHub credential types holding a key pair
+var KeyTypes = []string{"rsa", "ssh"}
+
+// ListKeys returns the latest version of every credential below path whose
+// type is one of types
+func (cp *CredhubProxy) ListKeys(path string, types []string) ([]secretstore.Key, error) {
+	if path == "" {
+		path = "/"
+	}
+	found, err := cp.Client.FindByPath(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := []secretstore.Key{}
+	for _, c := range found.Credentials {
+		cred, err := cp.Client.GetLatestVersion(c.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !contains(types, cred.Type) {
+			continue
+		}
+		value, ok := cred.Value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("could not read the value of %s", cred.Name)
+		}
+		publicKey, _ := value["public_key"].(string)
+		fp, err := KeyFingerprint(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the public key of %s: %s", cred.Name, err)
+		}
+		keys = append(keys, secretstore.Key{Name: cred.Name, Type: cred.Type, PublicKey: publicKey, Fingerprint: fp})
+	}
+	return keys, nil
+}
+
+// KeyFingerprint returns the SHA256 fingerprint of a public key in the PEM
+// format of rsa credentials or the authorized_keys format of ssh credentials
+func KeyFingerprint(publicKey string) (string, error) {
+	var pub ssh.PublicKey
+	if block, _ := pem.Decode([]byte(publicKey)); block != nil {
+		var key interface{}
+		var err error
+		if block.Type == "RSA PUBLIC KEY" {
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		} else {
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		}
+		if err != nil {
+			return "", err
+		}
+		pub, err = ssh.NewPublicKey(key)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		var err error
+		pub, _, _, _, err = ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(publicKey)))
+		if err != nil {
+			return "", err
+		}
+	}
+	return ssh.FingerprintSHA256(pub), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, each := range list {
+		if each == s {
+			return true
+		}
+	}
+	return false
+}
@@ -15,23 +15,36 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/newcontext-oss/credhub-venafi/chclient"
 	"github.com/newcontext-oss/credhub-venafi/config"
+	"github.com/newcontext-oss/credhub-venafi/httpclient"
+	"github.com/newcontext-oss/credhub-venafi/k8sclient"
 	"github.com/newcontext-oss/credhub-venafi/output"
+	"github.com/newcontext-oss/credhub-venafi/retry"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
+	"github.com/newcontext-oss/credhub-venafi/vaultclient"
 	"github.com/newcontext-oss/credhub-venafi/vcclient"
 
 	"github.com/Venafi/vcert/pkg/certificate"
 )
 
+// stdin is where confirmations are read from
+var stdin io.Reader = os.Stdin
+
 // Command represents a command line instruction from the user
 type Command interface {
 	validateFlags() error
@@ -39,6 +52,12 @@ type Command interface {
 	execute() error
 }
 
+// subcommander is implemented by commands such as "policy show" that take a
+// subcommand before their flags
+type subcommander interface {
+	setSubcommand(name string) error
+}
+
 func parseCommand() (Command, error) {
 	// don't use the logger until it has been setup to write to file
 	log.SetOutput(&NoopWriter{})
@@ -59,12 +78,39 @@ func parseCommand() (Command, error) {
 		v = &DeleteCommand{}
 	case "list":
 		v = &ListCommand{}
+	case "resume":
+		v = &ResumeCommand{}
+	case "history":
+		v = &HistoryCommand{}
+	case "show":
+		v = &ShowCommand{}
+	case "verify":
+		v = &VerifyCommand{}
+	case "rollback":
+		v = &RollbackCommand{}
+	case "policy":
+		v = &PolicyCommand{}
+	case "pending":
+		v = &PendingCommand{}
+	case "sync":
+		v = &SyncCommand{}
 	default:
 		return nil, fmt.Errorf("command not recognized %s", command)
 	}
 
+	rest := os.Args[2:]
+	if s, ok := v.(subcommander); ok {
+		if len(rest) == 0 || strings.HasPrefix(rest[0], "-") {
+			return nil, fmt.Errorf("%s requires a subcommand", command)
+		}
+		err := s.setSubcommand(rest[0])
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[1:]
+	}
 	newArgs := []string{os.Args[0]}
-	newArgs = append(newArgs, os.Args[2:]...)
+	newArgs = append(newArgs, rest...)
 	os.Args = newArgs
 
 	v.prepFlags()
@@ -79,56 +125,99 @@ func parseCommand() (Command, error) {
 	return v, nil
 }
 
-// ListCommand contains the information required to construct a call to list certificates
-type ListCommand struct {
-	ByThumbprint  bool
-	ByCommonName  bool
-	ByPath        bool
-	VenafiPrefix  string
-	CredhubPrefix string
-	VenafiRoot    string
-	CredhubRoot   string
-	VenafiLimit   int
-}
+// connect reads the configuration, authenticates against the secret store
+// and logs into Venafi
+func connect() (*CV, *config.YAMLConfig, error) {
+	userHomeDir, configYAML, err := readConfig()
+	if err != nil {
+		return nil, nil, err
+	}
 
-func (v *ListCommand) validateFlags() error {
-	return nil
+	configLoader := cvConfigLoader(userHomeDir)
+	policy := retryPolicy(configYAML.Retry)
+	var store secretstore.Store
+	if configYAML.VaultBaseURL != "" {
+		vp, err := newVaultProxy(configYAML)
+		if err != nil {
+			return nil, nil, err
+		}
+		store = &secretstore.RetryProxy{Proxy: vp, Retrier: retry.New("Vault", policy)}
+	} else {
+		cp, err := newCredhubProxy(configYAML, configLoader)
+		if err != nil {
+			return nil, nil, err
+		}
+		store = &secretstore.RetryProxy{Proxy: cp, Retrier: retry.New("CredHub", policy)}
+	}
+	cv := &CV{configLoader: configLoader, store: store}
+	err = cv.loginVenafi(configYAML, userHomeDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cv, configYAML, nil
 }
 
-func (v *ListCommand) prepFlags() {
-	flag.BoolVar(&v.ByThumbprint, "bythumbprint", false, "Compare by thumbprint. Note this will be slower due to the need to download each cert from CredHub.")
-	flag.BoolVar(&v.ByCommonName, "bycommonname", false, "Compare by certificate common name from Venafi and file basename on the CredHub side.")
-	flag.BoolVar(&v.ByPath, "bypath", false, "Compare by path")
-	flag.StringVar(&v.VenafiPrefix, "vprefix", "", "Venafi prefix to strip from returned values")
-	flag.StringVar(&v.CredhubPrefix, "cprefix", "", "Credhub prefix to strip from returned values")
-	flag.StringVar(&v.VenafiRoot, "vroot", "", "Subpath to search in Venafi")
-	flag.StringVar(&v.CredhubRoot, "croot", "", "Subpath to search in CredHub")
-	flag.IntVar(&v.VenafiLimit, "vlimit", 100, "(Default 100) Limits the number of Venafi results returned")
+// connectKubernetes reads the configuration, connects to Kubernetes and logs
+// into Venafi. CredHub is not used.
+func connectKubernetes() (*CV, *config.YAMLConfig, error) {
+	userHomeDir, configYAML, err := readConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	kc, err := newKubernetesClient(configYAML)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cv := &CV{
+		configLoader: cvConfigLoader(userHomeDir),
+		kubernetes:   &k8sclient.RetryProxy{Proxy: kc, Retrier: retry.New("Kubernetes", retryPolicy(configYAML.Retry))},
+	}
+	err = cv.loginVenafi(configYAML, userHomeDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cv, configYAML, nil
 }
 
-func (v *ListCommand) execute() error {
+func readConfig() (string, *config.YAMLConfig, error) {
 	userHomeDir, err := os.UserHomeDir()
 	if err != nil {
-		return err
+		return "", nil, err
 	}
-
 	configYAML, err := config.ReadConfig(userHomeDir, ConfigFile)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
+	return userHomeDir, configYAML, nil
+}
 
-	configLoader := chclient.ConfigLoader{
-		UserHomeDir:    userHomeDir,
-		CVConfigDir:    ".cv",
-		ConfigFilename: "config.json",
+// loginVenafi logs into Venafi with the settings of the config file
+func (c *CV) loginVenafi(configYAML *config.YAMLConfig, userHomeDir string) error {
+	vp, err := newVcertProxy(configYAML, userHomeDir)
+	if err != nil {
+		return err
 	}
-	config, err := configLoader.ReadConfig()
+	c.vcert = &vcclient.RetryProxy{Proxy: vp, Retrier: retry.New("Venafi", retryPolicy(configYAML.Retry))}
+
+	c.watchSignals()
+	err = c.vcert.Login()
 	if err != nil {
+		c.close()
 		return err
 	}
+	return nil
+}
 
-	if v.VenafiRoot == "" && configYAML.VcertZone != "" {
-		v.VenafiRoot = vcclient.PrependPolicyRoot(configYAML.VcertZone)
+// newCredhubProxy returns a CredHub proxy authenticated with the tokens of
+// `cv login` or the client certificate of the config file
+func newCredhubProxy(configYAML *config.YAMLConfig, configLoader chclient.ConfigLoader) (*chclient.CredhubProxy, error) {
+	config, err := configLoader.ReadConfig()
+	if err != nil && configYAML.CredhubClientCert != "" {
+		// a client certificate authenticates without `cv login`
+		config = &chclient.CVConfig{CredhubBaseURL: configYAML.CredhubEndpoint, SkipTLSValidation: configYAML.SkipTLSValidation}
+	} else if err != nil {
+		return nil, err
 	}
 
 	cp := &chclient.CredhubProxy{
@@ -140,32 +229,335 @@ func (v *ListCommand) execute() error {
 		ClientID:          configYAML.ClientID,
 		ClientSecret:      configYAML.ClientSecret,
 		ConfigPath:        ".cv",
+		HTTP:              httpSettings(configYAML, configYAML.CredhubProxy),
+	}
+	err = setCredhubTLS(cp, configYAML)
+	if err != nil {
+		return nil, err
 	}
+	return cp, cp.AuthExisting()
+}
 
-	cv := CV{
-		configLoader: configLoader,
-		credhub:      cp,
-		vcert: &vcclient.VcertProxy{
-			Username:      configYAML.VcertUsername,
-			Password:      configYAML.VcertPassword,
-			Zone:          configYAML.VcertZone,
-			AccessToken:   configYAML.VcertAccessToken,
-			LegacyAuth:    configYAML.VcertLegacyAuth,
-			BaseURL:       configYAML.VcertBaseURL,
-			ConnectorType: configYAML.ConnectorType,
-		},
+// newProfileStore returns the CredHub of a profile of the config file,
+// authenticated with its client credentials or client certificate
+func newProfileStore(configYAML *config.YAMLConfig, name string) (secretstore.Store, error) {
+	p, ok := configYAML.CredhubProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s is not configured in credhub_profiles", name)
+	}
+	if p.Endpoint == "" {
+		return nil, fmt.Errorf("credhub_endpoint is required for profile %s", name)
+	}
+	if (p.ClientCert == "") != (p.ClientKey == "") {
+		return nil, fmt.Errorf("credhub_client_cert and credhub_client_key must be set together for profile %s", name)
+	}
+	cp := &chclient.CredhubProxy{
+		BaseURL:           p.Endpoint,
+		SkipTLSValidation: configYAML.SkipTLSValidation,
+		ClientID:          p.ClientID,
+		ClientSecret:      p.ClientSecret,
+		ClientCert:        p.ClientCert,
+		ClientKey:         p.ClientKey,
+		HTTP:              httpSettings(configYAML, p.Proxy),
 	}
+	for _, path := range []string{p.CACert, p.UAACACert} {
+		ca, err := readPEMFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if ca != "" {
+			cp.CACerts = append(cp.CACerts, ca)
+		}
+	}
+	err := cp.AuthClient()
+	if err != nil {
+		return nil, err
+	}
+	return &secretstore.RetryProxy{Proxy: cp, Retrier: retry.New("CredHub "+name, retryPolicy(configYAML.Retry))}, nil
+}
 
-	err = cp.AuthExisting()
+// newVaultProxy returns a Vault proxy for the settings of the config file
+func newVaultProxy(configYAML *config.YAMLConfig) (*vaultclient.VaultProxy, error) {
+	if configYAML.VaultToken == "" || configYAML.VaultKVPath == "" {
+		return nil, fmt.Errorf("vault_token and vault_kv_path are required with vault_base_url")
+	}
+	ca, err := readPEMFile(configYAML.VaultCACert)
+	if err != nil {
+		return nil, err
+	}
+	vp := &vaultclient.VaultProxy{
+		BaseURL:           configYAML.VaultBaseURL,
+		Token:             configYAML.VaultToken,
+		KVPath:            configYAML.VaultKVPath,
+		PKIPath:           configYAML.VaultPKIPath,
+		Role:              configYAML.VaultRole,
+		SkipTLSValidation: configYAML.SkipTLSValidation,
+		HTTP:              httpSettings(configYAML, configYAML.VaultProxy),
+	}
+	if ca != "" {
+		vp.CACerts = []string{ca}
+	}
+	return vp, nil
+}
+
+// newKubernetesClient returns a Kubernetes client for the settings of the
+// config file, or for the service account of the pod cv runs in if no API
+// server is set. The namespace of the pod is the default namespace then.
+func newKubernetesClient(configYAML *config.YAMLConfig) (*k8sclient.Client, error) {
+	k := &configYAML.Kubernetes
+	if k.APIURL == "" {
+		kc, namespace, err := k8sclient.InCluster()
+		if err != nil {
+			return nil, err
+		}
+		if k.Namespace == "" {
+			k.Namespace = namespace
+		}
+		kc.HTTP = httpSettings(configYAML, k.Proxy)
+		return kc, nil
+	}
+
+	kc := &k8sclient.Client{
+		BaseURL:           k.APIURL,
+		SkipTLSValidation: configYAML.SkipTLSValidation,
+		HTTP:              httpSettings(configYAML, k.Proxy),
+	}
+	if k.TokenFile != "" {
+		token, err := ioutil.ReadFile(k.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the Kubernetes token file: %s", err)
+		}
+		kc.Token = strings.TrimSpace(string(token))
+	}
+	ca, err := readPEMFile(k.CACert)
+	if err != nil {
+		return nil, err
+	}
+	if ca != "" {
+		kc.CACerts = []string{ca}
+	}
+	return kc, nil
+}
+
+// newVcertProxy returns a Venafi proxy for the settings of the config file
+// that keeps its TPP session in the cv directory, unless tokens are revoked
+// after every command
+func newVcertProxy(configYAML *config.YAMLConfig, userHomeDir string) (*vcclient.VcertProxy, error) {
+	trustBundle, err := readPEMFile(configYAML.VcertTrustBundle)
+	if err != nil {
+		return nil, err
+	}
+	sessionFile := filepath.Join(userHomeDir, ".cv", "venafi.json")
+	if configYAML.VcertRevokeTokens {
+		sessionFile = ""
+	}
+	return &vcclient.VcertProxy{
+		Username:      configYAML.VcertUsername,
+		Password:      configYAML.VcertPassword,
+		Zone:          configYAML.VcertZone,
+		AccessToken:   configYAML.VcertAccessToken,
+		LegacyAuth:    configYAML.VcertLegacyAuth,
+		BaseURL:       configYAML.VcertBaseURL,
+		ConnectorType: configYAML.ConnectorType,
+		TrustBundle:   trustBundle,
+		ClientID:      configYAML.VcertClientID,
+		Scope:         configYAML.VcertScope,
+		SessionFile:   sessionFile,
+		HTTP:          httpSettings(configYAML, configYAML.VcertProxy),
+	}, nil
+}
+
+// setCredhubTLS applies the CA and client certificates of the config file to
+// a CredHub proxy
+func setCredhubTLS(cp *chclient.CredhubProxy, configYAML *config.YAMLConfig) error {
+	for _, path := range []string{configYAML.CredhubCACert, configYAML.UAACACert} {
+		ca, err := readPEMFile(path)
+		if err != nil {
+			return err
+		}
+		if ca != "" {
+			cp.CACerts = append(cp.CACerts, ca)
+		}
+	}
+	if (configYAML.CredhubClientCert == "") != (configYAML.CredhubClientKey == "") {
+		return fmt.Errorf("credhub_client_cert and credhub_client_key must be set together")
+	}
+	cp.ClientCert = configYAML.CredhubClientCert
+	cp.ClientKey = configYAML.CredhubClientKey
+	return nil
+}
+
+// httpSettings returns the connection settings of the config file for a
+// server reached through proxy
+func httpSettings(configYAML *config.YAMLConfig, proxy string) httpclient.Settings {
+	return httpclient.Settings{
+		Proxy:          proxy,
+		NoProxy:        configYAML.NoProxy,
+		ConnectTimeout: configYAML.ConnectTimeout,
+		RequestTimeout: configYAML.RequestTimeout,
+	}
+}
+
+// readPEMFile returns the content of a PEM file set in the config file, or
+// an empty string if path is empty
+func readPEMFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read certificate file: %s", err)
+	}
+	return string(b), nil
+}
+
+// retryPolicy fills the retry settings missing in the config file with the
+// defaults
+func retryPolicy(c config.Retry) retry.Policy {
+	p := retry.DefaultPolicy
+	if c.Attempts > 0 {
+		p.Attempts = c.Attempts
+	}
+	if c.BaseDelay > 0 {
+		p.BaseDelay = c.BaseDelay
+	}
+	if c.MaxDelay > 0 {
+		p.MaxDelay = c.MaxDelay
+	}
+	if c.Budget > 0 {
+		p.Budget = c.Budget
+	}
+	if c.BreakAfter > 0 {
+		p.BreakAfter = c.BreakAfter
+	}
+	return p
+}
+
+// cvConfigLoader returns the loader of the cv state kept in the home directory
+func cvConfigLoader(userHomeDir string) chclient.ConfigLoader {
+	return chclient.ConfigLoader{
+		UserHomeDir:    userHomeDir,
+		CVConfigDir:    ".cv",
+		ConfigFilename: "config.json",
+	}
+}
+
+// ListCommand contains the information required to construct a call to list certificates
+type ListCommand struct {
+	ByThumbprint  bool
+	ByCommonName  bool
+	ByPath        bool
+	VenafiPrefix  string
+	CredhubPrefix string
+	VenafiRoot    string
+	CredhubRoot   string
+	VenafiLimit   int
+	// Type selects the credentials to compare, certificates or the keys of
+	// the rsa and ssh types
+	Type string
+	// Profiles are the CredHubs compared in a matrix, default is the one of
+	// `cv login` and others are configured in credhub_profiles
+	Profiles stringSlice
+	Output   string
+	// Statuses, ExpiresWithin, NameRegex, Issuer, KeyType and CustomFields
+	// narrow the rows shown, see listFilter
+	Statuses      stringSlice
+	ExpiresWithin int
+	NameRegex     string
+	Issuer        string
+	KeyType       string
+	CustomFields  stringSlice
+}
+
+func (v *ListCommand) validateFlags() error {
+	switch v.Type {
+	case typeCertificate:
+	case typeRSA, typeSSH, typeKeys:
+		if v.ByThumbprint || v.ByCommonName || v.ByPath {
+			return fmt.Errorf("keys are compared by fingerprint, -bythumbprint, -bycommonname and -bypath only apply to certificates")
+		}
+		if v.matrix() {
+			return fmt.Errorf("-profile and -output json only apply to certificates")
+		}
+	default:
+		return fmt.Errorf("-type must be one of certificate, rsa, ssh or keys")
+	}
+	if v.Output != "" && v.Output != formatTable && v.Output != formatJSON {
+		return fmt.Errorf("-output must be table or json")
+	}
+	f, err := v.filter()
 	if err != nil {
 		return err
 	}
+	if f != nil && f.inspects() && keyTypes(v.Type) != nil {
+		return fmt.Errorf("-expires-within, -name-regex, -issuer, -key-type and -custom-field only apply to certificates")
+	}
+	seen := map[string]bool{}
+	for _, p := range v.Profiles {
+		if seen[p] {
+			return fmt.Errorf("profile %s is listed twice", p)
+		}
+		seen[p] = true
+	}
+	return nil
+}
 
-	err = cv.vcert.Login()
+// matrix tells whether the certificates are shown as a matrix across
+// profiles instead of side by side
+func (v *ListCommand) matrix() bool {
+	return len(v.Profiles) > 0 || v.Output == formatJSON
+}
+
+func (v *ListCommand) prepFlags() {
+	flag.BoolVar(&v.ByThumbprint, "bythumbprint", false, "Compare by thumbprint. Note this will be slower due to the need to download each cert from CredHub.")
+	flag.BoolVar(&v.ByCommonName, "bycommonname", false, "Compare by certificate common name from Venafi and file basename on the CredHub side.")
+	flag.BoolVar(&v.ByPath, "bypath", false, "Compare by path")
+	flag.StringVar(&v.VenafiPrefix, "vprefix", "", "Venafi prefix to strip from returned values")
+	flag.StringVar(&v.CredhubPrefix, "cprefix", "", "Credhub prefix to strip from returned values")
+	flag.StringVar(&v.VenafiRoot, "vroot", "", "Subpath to search in Venafi")
+	flag.StringVar(&v.CredhubRoot, "croot", "", "Subpath to search in CredHub")
+	flag.IntVar(&v.VenafiLimit, "vlimit", 100, "(Default 100) Limits the number of Venafi results returned")
+	flag.StringVar(&v.Type, "type", typeCertificate, "Credentials to compare: certificate, or rsa, ssh or keys (both) to compare CredHub keys with the Venafi SSH key inventory")
+	flag.Var(&v.Profiles, "profile", "CredHub profile to compare in a matrix, default or a name from credhub_profiles. Repeat to compare several CredHubs.")
+	flag.StringVar(&v.Output, "output", formatTable, "Output format: table, or json for the matrix")
+	flag.Var(&v.Statuses, "status", "Only show rows of a status: only-missing-in-venafi, only-missing-in-credhub, matched or drifted. Repeat to show several.")
+	flag.IntVar(&v.ExpiresWithin, "expires-within", 0, "Only show certificates expiring within this many days, including expired ones")
+	flag.StringVar(&v.NameRegex, "name-regex", "", "Only show certificates whose common name or a SAN matches this regular expression")
+	flag.StringVar(&v.Issuer, "issuer", "", "Only show certificates whose issuer DN matches this regular expression")
+	flag.StringVar(&v.KeyType, "key-type", "", "Only show certificates with rsa or ecdsa keys")
+	flag.Var(&v.CustomFields, "custom-field", "Only show certificates whose Venafi custom field has a value, given as name=value. Repeat to require several.")
+}
+
+func (v *ListCommand) execute() error {
+	cv, configYAML, err := connect()
 	if err != nil {
 		return err
 	}
-	_, err = cv.listBoth(v)
+	defer cv.close()
+
+	if v.VenafiRoot == "" && configYAML.VcertZone != "" {
+		v.VenafiRoot = vcclient.PrependPolicyRoot(configYAML.VcertZone)
+	}
+	if !v.matrix() {
+		_, err = cv.listBoth(v)
+		return err
+	}
+
+	if len(v.Profiles) == 0 {
+		v.Profiles = stringSlice{defaultProfile}
+	}
+	profiles := []profileStore{}
+	for _, name := range v.Profiles {
+		store := cv.store
+		if name != defaultProfile {
+			store, err = newProfileStore(configYAML, name)
+			if err != nil {
+				return err
+			}
+		}
+		profiles = append(profiles, profileStore{name: name, store: store})
+	}
+	_, err = cv.listMatrix(v, profiles)
 	return err
 }
 
@@ -237,26 +629,86 @@ type GenerateAndStoreCommand struct {
 	// --self-sign          [Certificate] The generated certificate will be self-signed
 	SelfSign bool // c
 
-	GenOnly bool
-	Credhub bool
+	ValidityHours int    // v
+	IssuerHint    string // v
+	CsrOrigin     string // v
+	CSRFile       string // v
+	KeyFile       string // v
+	Template      string
+
+	GenOnly   bool
+	Credhub   bool
+	OnFailure string
+	Async     bool          // v
+	Timeout   time.Duration // v
 }
 
 func (v *GenerateAndStoreCommand) validateFlags() error {
-	if v.CommonName == "" && len(v.SANDNS) == 0 {
+	if v.Template != "" {
+		t, err := loadTemplate(v.Template)
+		if err != nil {
+			return err
+		}
+		err = v.applyTemplate(t, setFlags())
+		if err != nil {
+			return err
+		}
+	}
+	if v.CSRFile != "" {
+		if v.Name == "" {
+			return errors.New("you must have a name when submitting a CSR")
+		}
+		if v.Credhub {
+			return errors.New("a CSR can only be submitted to Venafi")
+		}
+	} else if v.KeyFile != "" {
+		return errors.New("key can only be used together with csr")
+	} else if v.CommonName == "" && len(v.SANDNS) == 0 && len(v.AlternativeName) == 0 {
 		return errors.New("you must have a common name or san-dns")
 	}
 	re := regexp.MustCompile(`[^\w_\-\/]`)
 	if re.MatchString(v.Name) {
 		return errors.New("CredHub name can only contain alphanumeric characters plus forward slash, underscore and dash")
 	}
-	return nil
+	if v.OnFailure != OnFailureRollback && v.OnFailure != OnFailureResume {
+		return fmt.Errorf("on-failure must be %s or %s", OnFailureRollback, OnFailureResume)
+	}
+	origin, ok := vcclient.CsrOrigins[v.CsrOrigin]
+	switch {
+	case !ok:
+		return errors.New("csr-origin must be local, service or user")
+	case origin == certificate.ServiceGeneratedCSR && v.CSRFile != "":
+		return errors.New("csr-origin service cannot be used with csr")
+	case origin == certificate.UserProvidedCSR && v.CSRFile == "":
+		return errors.New("csr-origin user requires csr")
+	}
+	if v.ValidityHours < 0 {
+		return errors.New("validity-hours must not be negative")
+	}
+	if v.Async && (v.Credhub || v.GenOnly) {
+		return errors.New("async cannot be used with credhub or genonly")
+	}
+	if v.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+
+	spec, err := v.spec()
+	if err != nil {
+		return err
+	}
+	if v.Credhub {
+		_, err = spec.StoreParameters()
+	} else {
+		_, err = spec.VenafiArgs()
+	}
+	return err
 }
 
 func (v *GenerateAndStoreCommand) prepFlags() {
 	flag.StringVar(&v.Name, "name", "", "Credhub Name")
 
 	flag.StringVar(&v.CommonName, "cn", "", "(all) Common name")
-	flag.Var(&v.SANDNS, "san-dns", "(Venafi) San DNS")
+	flag.Var(&v.SANDNS, "san-dns", "(all) SAN DNS name (may be specified multiple times)")
 	flag.Var(&v.KeyType, "key-type", "(Venafi) Key type")
 	flag.Var(&v.KeyCurve, "key-curve", "(Venafi) Key curve")
 	flag.StringVar(&v.OrganizationName, "o", "", "(all) Organization Name")
@@ -264,18 +716,24 @@ func (v *GenerateAndStoreCommand) prepFlags() {
 	flag.StringVar(&v.Country, "c", "", "(all) Country")
 	flag.StringVar(&v.State, "st", "", "(all) State")
 	flag.StringVar(&v.Locality, "l", "", "(all) Locality")
-	flag.Var(&v.SANEmail, "san-email", "(Venafi) SAN Email")
-	flag.Var(&v.SANIP, "san-ip", "(Venafi) SAN IP")
-	flag.StringVar(&v.KeyPassword, "key-password", "", "(Venafi) Key Password")
+	flag.Var(&v.SANEmail, "san-email", "(Venafi) SAN Email (may be specified multiple times)")
+	flag.Var(&v.SANIP, "san-ip", "(all) SAN IP (may be specified multiple times)")
+	flag.StringVar(&v.KeyPassword, "key-password", "", "(Venafi) Key Password. Required with -csr-origin service to export the key from Venafi.")
+	flag.IntVar(&v.ValidityHours, "validity-hours", 0, "(all) Hours until the certificate expires. Must be whole days on CredHub.")
+	flag.StringVar(&v.IssuerHint, "issuer-hint", "", "(Venafi) Kind of CA behind the zone: microsoft, digicert or entrust. Needed for -validity-hours with these CAs.")
+	flag.StringVar(&v.CsrOrigin, "csr-origin", "local", "(Venafi) Where the key and CSR are generated: 'local', 'service' (by Venafi) or 'user' (requires -csr)")
+	flag.StringVar(&v.Template, "template", "", "(all) Name of a template in the config file with default values for the other flags. Flags given on the command line override the template.")
+	flag.StringVar(&v.CSRFile, "csr", "", "(Venafi) PEM file with a CSR to submit instead of generating a key")
+	flag.StringVar(&v.KeyFile, "key", "", "(Venafi) PEM file with the private key of the CSR to store in CredHub. Decrypted with -key-password if encrypted.")
 
 	// -O, --no-overwrite       Credential is not modified if stored value already exists
 	flag.BoolVar(&v.NoOverwrite, "no-overwrite", false, "(CredHub) NoOverwrite")
 	// -k, --key-length=        [Certificate, SSH, RSA] Bit length of the generated key (Default: 2048)
-	flag.IntVar(&v.KeyLength, "key-length", 2048, "(CredHub) KeyLength")
+	flag.IntVar(&v.KeyLength, "key-length", 0, "(all) Key length of RSA keys (default 2048)")
 	// -d, --duration=          [Certificate] Valid duration (in days) of the generated certificate (Default: 365)
-	flag.IntVar(&v.Duration, "duration", 365, "(CredHub) Duration")
+	flag.IntVar(&v.Duration, "duration", 0, "(all) Validity in days. By default 365 on CredHub, decided by the CA on Venafi.")
 	// -a, --alternative-name=  [Certificate] A subject alternative name of the generated certificate (may be specified multiple times)
-	flag.Var(&v.AlternativeName, "alternative-name", "(CredHub) AlternativeName")
+	flag.Var(&v.AlternativeName, "alternative-name", "(all) A SAN DNS name or IP (may be specified multiple times)")
 	// -g, --key-usage=         [Certificate] Key Usage extensions for the generated certificate (may be specified multiple times)
 	flag.Var(&v.KeyUsage, "key-usage", "(CredHub) KeyUsage")
 	// -e, --ext-key-usage=     [Certificate] Extended Key Usage extensions for the generated certificate (may be specified multiple times)
@@ -289,62 +747,17 @@ func (v *GenerateAndStoreCommand) prepFlags() {
 
 	flag.BoolVar(&v.GenOnly, "genonly", false, "(all) Only generate the cert. Do not copy it to the other platform. By default cert is copied from generated platform to other platform.")
 	flag.BoolVar(&v.Credhub, "credhub", false, "(CredHub) Generate the certificate on the CredHub platform. By default the certificate is generated on the Venafi platform.")
+	flag.BoolVar(&v.Async, "async", false, "(Venafi) Submit the request without waiting for the certificate. Complete it later with 'cv pending pickup'.")
+	flag.DurationVar(&v.Timeout, "timeout", vcclient.DefaultPickupTimeout, "(Venafi) How long to wait for the certificate to be issued, i.e. 10m. A request still pending after it is kept for 'cv pending pickup'.")
+	flag.StringVar(&v.OnFailure, "on-failure", OnFailureRollback, "(all) What to do when copying to the other platform fails: 'rollback' removes the generated cert, 'resume' records the copy so that 'cv resume' can complete it.")
 }
 
 func (v *GenerateAndStoreCommand) execute() error {
-	userHomeDir, err := os.UserHomeDir()
-	if err != nil {
-		return err
-	}
-
-	configYAML, err := config.ReadConfig(userHomeDir, ConfigFile)
-	if err != nil {
-		return err
-	}
-
-	configLoader := chclient.ConfigLoader{
-		UserHomeDir:    userHomeDir,
-		CVConfigDir:    ".cv",
-		ConfigFilename: "config.json",
-	}
-	config, err := configLoader.ReadConfig()
-	if err != nil {
-		return err
-	}
-
-	cp := &chclient.CredhubProxy{
-		BaseURL:           config.CredhubBaseURL,
-		AccessToken:       config.AccessToken,
-		RefreshToken:      config.RefreshToken,
-		AuthURL:           config.AuthURL,
-		SkipTLSValidation: config.SkipTLSValidation,
-		ClientID:          configYAML.ClientID,
-		ClientSecret:      configYAML.ClientSecret,
-		ConfigPath:        ".cv",
-	}
-
-	cv := CV{
-		configLoader: configLoader,
-		credhub:      cp,
-		vcert: &vcclient.VcertProxy{
-			Username:      configYAML.VcertUsername,
-			Password:      configYAML.VcertPassword,
-			AccessToken:   configYAML.VcertAccessToken,
-			LegacyAuth:    configYAML.VcertLegacyAuth,
-			Zone:          configYAML.VcertZone,
-			BaseURL:       configYAML.VcertBaseURL,
-			ConnectorType: configYAML.ConnectorType,
-		},
-	}
-	err = cp.AuthExisting()
-	if err != nil {
-		return err
-	}
-
-	err = cv.vcert.Login()
+	cv, _, err := connect()
 	if err != nil {
 		return err
 	}
+	defer cv.close()
 
 	if v.Name == "" {
 		v.Name = v.CommonName
@@ -356,8 +769,10 @@ func (v *GenerateAndStoreCommand) execute() error {
 	return cv.generateAndStore(v.Name, v, !v.GenOnly)
 }
 
-// LoginCommand contains the information required to construct a call to log in to the CredHub service
+// LoginCommand contains the information required to construct a call to log in to the CredHub service,
+// or to Venafi with -venafi
 type LoginCommand struct {
+	Venafi            bool
 	Username          string
 	Password          string
 	CredhubBaseURL    string
@@ -365,6 +780,31 @@ type LoginCommand struct {
 	ClientSecret      string
 	SkipTLSValidation bool
 	configYAML        *config.YAMLConfig
+	userHomeDir       string
+}
+
+// validateVenafi fills the TPP credentials missing from the flags with those
+// of the config file
+func (v *LoginCommand) validateVenafi() error {
+	if v.Username == "" {
+		v.Username = v.configYAML.VcertUsername
+	}
+	if v.Password == "" {
+		v.Password = v.configYAML.VcertPassword
+	}
+	if v.Username == "" || v.Password == "" {
+		return fmt.Errorf("username and password are required")
+	}
+	if v.configYAML.VcertBaseURL == "" {
+		return fmt.Errorf("vcert_base_url is required")
+	}
+	if v.configYAML.ConnectorType != "tpp" || v.configYAML.VcertLegacyAuth {
+		return fmt.Errorf("sessions are only kept for TPP with token authentication")
+	}
+	if v.configYAML.VcertRevokeTokens {
+		return fmt.Errorf("vcert_revoke_tokens is set, no session is kept")
+	}
+	return nil
 }
 
 func (v *LoginCommand) validateFlags() error {
@@ -377,6 +817,11 @@ func (v *LoginCommand) validateFlags() error {
 	if err != nil {
 		return err
 	}
+	v.userHomeDir = userHomeDir
+
+	if v.Venafi {
+		return v.validateVenafi()
+	}
 
 	if v.configYAML.CredhubUsername != "" && v.Username == "" {
 		v.Username = v.configYAML.CredhubUsername
@@ -420,9 +865,13 @@ func (v *LoginCommand) prepFlags() {
 	flag.StringVar(&v.ClientID, "clientid", "", "Client Id")
 	flag.StringVar(&v.ClientSecret, "clientsecret", "", "Client Secret")
 	flag.BoolVar(&v.SkipTLSValidation, "skip-tls-validation", false, "Skip tls validation for test purposes")
+	flag.BoolVar(&v.Venafi, "venafi", false, "Log in to Venafi TPP and keep the tokens for later commands")
 }
 
 func (v *LoginCommand) execute() error {
+	if v.Venafi {
+		return v.loginVenafi()
+	}
 	cp := &chclient.CredhubProxy{
 		BaseURL:           v.CredhubBaseURL,
 		Username:          v.Username,
@@ -431,14 +880,33 @@ func (v *LoginCommand) execute() error {
 		ClientSecret:      v.ClientSecret,
 		SkipTLSValidation: v.SkipTLSValidation,
 		ConfigPath:        ".cv",
+		HTTP:              httpSettings(v.configYAML, v.configYAML.CredhubProxy),
+	}
+	err := setCredhubTLS(cp, v.configYAML)
+	if err != nil {
+		return err
 	}
-	err := cp.Auth()
+	err = cp.Auth()
 	if err == nil {
 		output.Status("Login Successful\n")
 	}
 	return err
 }
 
+func (v *LoginCommand) loginVenafi() error {
+	vp, err := newVcertProxy(v.configYAML, v.userHomeDir)
+	if err != nil {
+		return err
+	}
+	vp.Username = v.Username
+	vp.Password = v.Password
+	err = vp.NewSession()
+	if err == nil {
+		output.Status("Venafi Login Successful\n")
+	}
+	return err
+}
+
 // HelpCommand implements the "help" cli command
 type HelpCommand struct {
 }
@@ -456,86 +924,363 @@ func (v *HelpCommand) execute() error {
   cv [command]
 
 Available commands:
-  login              Log in to CredHub
+  login              Log in to CredHub, or to Venafi with -venafi
   create             Generate a credential and upload to counterpart system
   list               List credentials in each system
   delete             Delete a credential
+  resume             Complete copies left pending by a failed create
+  show               Show the details of a certificate and its counterpart on the other platform
+  verify             Check CredHub private keys and chains and that Venafi holds the same certificates
+  history            List the CredHub versions of a certificate
+  rollback           Make an earlier CredHub version of a certificate current
+  policy show        Show the policy of a Venafi zone
+  pending list       List requests and copies that have not completed
+  pending pickup     Pick up certificates of pending Venafi requests and upload them to CredHub
+  sync               Copy Venafi certificates and their keys to Kubernetes TLS Secrets
 `)
 	return nil
 }
 
 // DeleteCommand contains the information required to construct a call to delete a cert
 type DeleteCommand struct {
-	Name string
+	Name       string
+	Thumbprint string
+	VenafiDN   string
+	Match      string
+	Reason     string
+	Comment    string
+	Disable    bool
+	Only       string
+	Yes        bool
+	DryRun     bool
 }
 
 func (v *DeleteCommand) validateFlags() error {
-	if v.Name == "" {
-		return fmt.Errorf("name is required")
+	selectors := 0
+	for _, s := range []string{v.Name, v.Thumbprint, v.VenafiDN, v.Match} {
+		if s != "" {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return fmt.Errorf("one of name, thumbprint, venafi-dn or match is required")
+	}
+	if v.Only != "" && v.Only != targetVenafi && v.Only != targetCredhub {
+		return fmt.Errorf("only must be %s or %s", targetVenafi, targetCredhub)
+	}
+	if v.Only == targetCredhub && (v.Reason != "" || v.Comment != "" || v.Disable) {
+		return fmt.Errorf("reason, comment and disable only apply to Venafi")
+	}
+	if _, ok := vcclient.RevocationReasons[v.Reason]; v.Reason != "" && !ok {
+		return fmt.Errorf("unknown revocation reason '%s'", v.Reason)
 	}
 	return nil
 }
 
 func (v *DeleteCommand) prepFlags() {
-	flag.StringVar(&v.Name, "name", "", "Name")
+	flag.StringVar(&v.Name, "name", "", "CredHub name of the certificate")
+	flag.StringVar(&v.Thumbprint, "thumbprint", "", "SHA-1 thumbprint of the certificate")
+	flag.StringVar(&v.VenafiDN, "venafi-dn", "", "Venafi DN of the certificate, i.e. \\VED\\Policy\\Certificates\\mycert")
+	flag.StringVar(&v.Match, "match", "", "Glob pattern matching CredHub names, i.e. /dev/*")
+	flag.StringVar(&v.Reason, "reason", "", "(Venafi) Revoke the certificate with this reason before removing it: unspecified, key-compromise, ca-compromise, affiliation-changed, superseded or cessation")
+	flag.StringVar(&v.Comment, "comment", "", "(Venafi) Revocation comment")
+	flag.BoolVar(&v.Disable, "disable", false, "(Venafi) Revoke and disable the certificate instead of deleting it from Venafi")
+	flag.StringVar(&v.Only, "only", "", "Only delete from one platform: venafi or credhub")
+	flag.BoolVar(&v.Yes, "y", false, "Do not ask for confirmation")
+	flag.BoolVar(&v.DryRun, "dry-run", false, "Only show the certificates that would be deleted")
+}
+
+func (v *DeleteCommand) venafiAction() string {
+	switch {
+	case v.Disable:
+		return "revoke and disable"
+	case v.Reason != "":
+		return "revoke and delete"
+	}
+	return "delete"
 }
 
 func (v *DeleteCommand) execute() error {
-	userHomeDir, err := os.UserHomeDir()
+	cv, _, err := connect()
 	if err != nil {
 		return err
 	}
+	defer cv.close()
+	return cv.deleteCert(v)
+}
 
-	configYAML, err := config.ReadConfig(userHomeDir, ConfigFile)
+// ResumeCommand contains the information required to complete pending operations
+type ResumeCommand struct {
+	ID string
+}
+
+func (v *ResumeCommand) validateFlags() error {
+	return nil
+}
+
+func (v *ResumeCommand) prepFlags() {
+	flag.StringVar(&v.ID, "id", "", "Id of the pending operation to complete. By default all pending operations are attempted.")
+}
+
+func (v *ResumeCommand) execute() error {
+	cv, _, err := connect()
 	if err != nil {
 		return err
 	}
+	defer cv.close()
+	return cv.resume(v.ID)
+}
 
-	configLoader := chclient.ConfigLoader{
-		UserHomeDir:    userHomeDir,
-		CVConfigDir:    ".cv",
-		ConfigFilename: "config.json",
+// HistoryCommand contains the information required to list the versions of a cert
+type HistoryCommand struct {
+	Name string
+}
+
+func (v *HistoryCommand) validateFlags() error {
+	if v.Name == "" {
+		return fmt.Errorf("name is required")
 	}
-	config, err := configLoader.ReadConfig()
+	return nil
+}
+
+func (v *HistoryCommand) prepFlags() {
+	flag.StringVar(&v.Name, "name", "", "CredHub name of the certificate")
+}
+
+func (v *HistoryCommand) execute() error {
+	cv, _, err := connect()
 	if err != nil {
 		return err
 	}
+	defer cv.close()
+	_, err = cv.history(v.Name)
+	return err
+}
 
-	cp := &chclient.CredhubProxy{
-		BaseURL:           config.CredhubBaseURL,
-		AccessToken:       config.AccessToken,
-		RefreshToken:      config.RefreshToken,
-		AuthURL:           config.AuthURL,
-		SkipTLSValidation: config.SkipTLSValidation,
-		ClientID:          configYAML.ClientID,
-		ClientSecret:      configYAML.ClientSecret,
-		ConfigPath:        ".cv",
+// ShowCommand contains the information required to inspect a cert and its counterpart
+type ShowCommand struct {
+	Name     string
+	VenafiDN string
+	Zone     string
+}
+
+func (v *ShowCommand) validateFlags() error {
+	if (v.Name == "") == (v.VenafiDN == "") {
+		return fmt.Errorf("one of name or venafi-dn is required")
+	}
+	return nil
+}
+
+func (v *ShowCommand) prepFlags() {
+	flag.StringVar(&v.Name, "name", "", "CredHub name of the certificate")
+	flag.StringVar(&v.VenafiDN, "venafi-dn", "", "DN of the Venafi certificate object")
+	flag.StringVar(&v.Zone, "zone", "", "Venafi zone to look for an object named like the CredHub certificate. By default the vcert_zone from the config file.")
+}
+
+func (v *ShowCommand) execute() error {
+	cv, configYAML, err := connect()
+	if err != nil {
+		return err
+	}
+	defer cv.close()
+	if v.Zone == "" {
+		v.Zone = configYAML.VcertZone
 	}
+	_, err = cv.show(v)
+	return err
+}
+
+// VerifyCommand contains the information required to check CredHub certs, their keys and Venafi copies
+type VerifyCommand struct {
+	Name string
+	All  bool
+}
 
-	cv := CV{
-		configLoader: configLoader,
-		credhub:      cp,
-		vcert: &vcclient.VcertProxy{
-			Username:      configYAML.VcertUsername,
-			Password:      configYAML.VcertPassword,
-			Zone:          configYAML.VcertZone,
-			AccessToken:   configYAML.VcertAccessToken,
-			LegacyAuth:    configYAML.VcertLegacyAuth,
-			BaseURL:       configYAML.VcertBaseURL,
-			ConnectorType: configYAML.ConnectorType,
-		},
+func (v *VerifyCommand) validateFlags() error {
+	if (v.Name == "") == !v.All {
+		return fmt.Errorf("one of name or all is required")
 	}
+	return nil
+}
+
+func (v *VerifyCommand) prepFlags() {
+	flag.StringVar(&v.Name, "name", "", "CredHub name of the certificate")
+	flag.BoolVar(&v.All, "all", false, "Verify every CredHub certificate")
+}
 
-	err = cp.AuthExisting()
+func (v *VerifyCommand) execute() error {
+	cv, _, err := connect()
 	if err != nil {
 		return err
 	}
+	defer cv.close()
+	_, err = cv.verify(v)
+	return err
+}
 
-	err = cv.vcert.Login()
+// RollbackCommand contains the information required to make an earlier version of a cert current
+type RollbackCommand struct {
+	Name    string
+	Version string
+}
+
+func (v *RollbackCommand) validateFlags() error {
+	if v.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if v.Version == "" {
+		return fmt.Errorf("version is required")
+	}
+	return nil
+}
+
+func (v *RollbackCommand) prepFlags() {
+	flag.StringVar(&v.Name, "name", "", "CredHub name of the certificate")
+	flag.StringVar(&v.Version, "version", "", "Id of the CredHub version to restore, as shown by 'cv history'")
+}
+
+func (v *RollbackCommand) execute() error {
+	cv, _, err := connect()
 	if err != nil {
 		return err
 	}
-	return cv.deleteCert(v.Name)
+	defer cv.close()
+	return cv.rollback(v.Name, v.Version)
+}
+
+// PolicyCommand contains the information required to show the policy of a zone
+type PolicyCommand struct {
+	Zone string
+}
+
+func (v *PolicyCommand) setSubcommand(name string) error {
+	if name != "show" {
+		return fmt.Errorf("unknown policy subcommand %s", name)
+	}
+	return nil
+}
+
+func (v *PolicyCommand) validateFlags() error {
+	return nil
+}
+
+func (v *PolicyCommand) prepFlags() {
+	flag.StringVar(&v.Zone, "zone", "", "Venafi zone to show. By default the vcert_zone from the config file.")
+}
+
+func (v *PolicyCommand) execute() error {
+	cv, configYAML, err := connect()
+	if err != nil {
+		return err
+	}
+	defer cv.close()
+	if v.Zone == "" {
+		v.Zone = configYAML.VcertZone
+	}
+	_, err = cv.showPolicy(v.Zone)
+	return err
+}
+
+// PendingCommand contains the information required to list or pick up pending operations
+type PendingCommand struct {
+	Subcommand  string
+	ID          string
+	Timeout     time.Duration
+	KeyPassword string
+}
+
+func (v *PendingCommand) setSubcommand(name string) error {
+	if name != "list" && name != "pickup" {
+		return fmt.Errorf("unknown pending subcommand %s", name)
+	}
+	v.Subcommand = name
+	return nil
+}
+
+func (v *PendingCommand) validateFlags() error {
+	if v.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+func (v *PendingCommand) prepFlags() {
+	if v.Subcommand != "pickup" {
+		return
+	}
+	flag.StringVar(&v.ID, "id", "", "Id of the pending request to pick up. By default all pending requests are attempted.")
+	flag.DurationVar(&v.Timeout, "timeout", vcclient.DefaultPickupTimeout, "How long to wait for each certificate to be issued, i.e. 10m. 0 tries once.")
+	flag.StringVar(&v.KeyPassword, "key-password", "", "Password to export keys generated by Venafi with -csr-origin service. It is not recorded with the request.")
+}
+
+func (v *PendingCommand) execute() error {
+	if v.Subcommand == "list" {
+		userHomeDir, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		cv := &CV{configLoader: cvConfigLoader(userHomeDir)}
+		_, err = cv.listPending()
+		return err
+	}
+
+	cv, _, err := connect()
+	if err != nil {
+		return err
+	}
+	defer cv.close()
+	return cv.pickupPending(v.ID, v.Timeout, v.KeyPassword)
+}
+
+// SyncCommand contains the information required to copy certs to Kubernetes Secrets
+type SyncCommand struct {
+	VenafiRoot  string
+	VenafiLimit int
+	DryRun      bool
+	// Namespace and Mappings are read from the config file
+	Namespace string
+	Mappings  []config.KubernetesMapping
+}
+
+func (v *SyncCommand) validateFlags() error {
+	if v.VenafiLimit < 1 {
+		return fmt.Errorf("vlimit must be positive")
+	}
+	return nil
+}
+
+func (v *SyncCommand) prepFlags() {
+	flag.StringVar(&v.VenafiRoot, "vroot", "", "Venafi policy folder to sync. By default the vcert_zone from the config file.")
+	flag.IntVar(&v.VenafiLimit, "vlimit", 100, "(Default 100) Limits the number of Venafi results returned")
+	flag.BoolVar(&v.DryRun, "dry-run", false, "Only show the Secrets that would be created or updated")
+}
+
+func (v *SyncCommand) execute() error {
+	cv, configYAML, err := connectKubernetes()
+	if err != nil {
+		return err
+	}
+	defer cv.close()
+
+	if v.VenafiRoot == "" {
+		v.VenafiRoot = configYAML.VcertZone
+	}
+	v.VenafiRoot = vcclient.PrependPolicyRoot(v.VenafiRoot)
+	v.Namespace = configYAML.Kubernetes.Namespace
+	v.Mappings = configYAML.Kubernetes.Mappings
+	_, err = cv.sync(v)
+	return err
+}
+
+// confirm asks the user a yes/no question on stdin
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
 
 // NoopWriter represents a Writer that just returns
@@ -0,0 +1,389 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/newcontext-oss/credhub-venafi/chclient"
+	"github.com/newcontext-oss/credhub-venafi/chclient/chfake"
+	"github.com/newcontext-oss/credhub-venafi/config"
+	"github.com/newcontext-oss/credhub-venafi/k8sclient/k8sfake"
+	"github.com/newcontext-oss/credhub-venafi/vaultclient/vaultfake"
+	"github.com/newcontext-oss/credhub-venafi/vcclient/vcfake"
+	"github.com/stretchr/testify/assert"
+)
+
+// testHome creates a temporary home directory containing the given .cv.conf
+func testHome(t *testing.T, conf string) string {
+	dir, err := ioutil.TempDir("", "cvhome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, ConfigFile), []byte(conf), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Getenv("HOME")
+	os.Setenv("HOME", dir)
+	config.Quiet = true
+	t.Cleanup(func() {
+		os.Setenv("HOME", old)
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+// runCommand parses and executes a cv command line the way main does
+func runCommand(args ...string) error {
+	flag.CommandLine = flag.NewFlagSet("cv", flag.ContinueOnError)
+	os.Args = append([]string{"cv"}, args...)
+	v, err := parseCommand()
+	if err != nil {
+		return err
+	}
+	return v.execute()
+}
+
+func TestLoginCommand(t *testing.T) {
+	server := chfake.NewServer()
+	defer server.Close()
+	server.AddUser("credhub", "password")
+	home := testHome(t, "credhub_endpoint: "+server.URL+"\n")
+
+	err := runCommand("login", "-u", "credhub", "-p", "password")
+	assert.Nil(t, err, "It should log in to CredHub")
+
+	loader := chclient.ConfigLoader{UserHomeDir: home, CVConfigDir: ".cv", ConfigFilename: "config.json"}
+	c, err := loader.ReadConfig()
+	assert.Nil(t, err, "It should write the CredHub config")
+	assert.Equal(t, server.URL, c.CredhubBaseURL)
+	assert.NotEmpty(t, c.AccessToken)
+
+	err = runCommand("login", "-u", "credhub", "-p", "wrong")
+	assert.NotNil(t, err, "It should fail with bad credentials")
+}
+
+func TestLoginCommandCACert(t *testing.T) {
+	server := chfake.NewTLSServer()
+	defer server.Close()
+	server.AddUser("credhub", "password")
+	home := testHome(t, "")
+	caFile := filepath.Join(home, "credhub-ca.pem")
+	err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := "credhub_endpoint: " + server.URL + "\ncredhub_ca_cert: " + caFile + "\n"
+	err = ioutil.WriteFile(filepath.Join(home, ConfigFile), []byte(conf), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, runCommand("login", "-u", "credhub", "-p", "password"), "It should trust the CredHub CA from the config file")
+
+	err = ioutil.WriteFile(filepath.Join(home, ConfigFile), []byte(conf+"credhub_client_cert: client.pem\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, runCommand("login", "-u", "credhub", "-p", "password"), "It should require the client key with the client certificate")
+}
+
+func TestLoginCommandVenafi(t *testing.T) {
+	server := vcfake.NewServer()
+	defer server.Close()
+	server.AddUser("tppadmin", "password")
+	home := testHome(t, "")
+	bundle := filepath.Join(home, "tpp.pem")
+	err := ioutil.WriteFile(bundle, []byte(server.TrustBundle()), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := "connector_type: tpp\nvcert_base_url: " + server.URL + "\nvcert_trust_bundle: " + bundle + "\nvcert_client_id: cv\n"
+	err = ioutil.WriteFile(filepath.Join(home, ConfigFile), []byte(conf), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NotNil(t, runCommand("login", "-venafi"), "It should require TPP credentials")
+	assert.Nil(t, runCommand("login", "-venafi", "-u", "tppadmin", "-p", "password"), "It should log in to Venafi")
+	info, err := os.Stat(filepath.Join(home, ".cv", "venafi.json"))
+	assert.Nil(t, err, "It should write the TPP session")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "It should keep the tokens private")
+
+	configYAML, err := config.ReadConfig(home, ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vp, err := newVcertProxy(configYAML, home)
+	assert.Nil(t, err)
+	assert.Nil(t, vp.Login(), "It should log in with the session and no password")
+	clientID, _, ok := server.TokenGrant(vp.AccessToken)
+	assert.True(t, ok, "It should use the token of the session")
+	assert.Equal(t, "cv", clientID, "It should request the configured client id")
+}
+
+func TestGenerateAndStoreCommandCSRFlags(t *testing.T) {
+	valid := GenerateAndStoreCommand{Name: "byo", CSRFile: "byo.csr", OnFailure: OnFailureRollback, CsrOrigin: "local"}
+	assert.Nil(t, valid.validateFlags(), "It should accept a CSR without a common name")
+
+	noName := valid
+	noName.Name = ""
+	assert.NotNil(t, noName.validateFlags(), "It should require a name with a CSR")
+
+	onCredhub := valid
+	onCredhub.Credhub = true
+	assert.NotNil(t, onCredhub.validateFlags(), "It should only submit CSRs to Venafi")
+
+	service := valid
+	service.CsrOrigin = "service"
+	assert.NotNil(t, service.validateFlags(), "It should not let Venafi generate the key of a CSR")
+
+	keyOnly := GenerateAndStoreCommand{Name: "byo", CommonName: "byo", KeyFile: "byo.key", OnFailure: OnFailureRollback, CsrOrigin: "local"}
+	assert.NotNil(t, keyOnly.validateFlags(), "It should require a CSR with a key")
+
+	user := GenerateAndStoreCommand{Name: "byo", CommonName: "byo", OnFailure: OnFailureRollback, CsrOrigin: "user"}
+	assert.NotNil(t, user.validateFlags(), "It should require a CSR with a user provided origin")
+}
+
+func TestPolicyCommandParse(t *testing.T) {
+	flag.CommandLine = flag.NewFlagSet("cv", flag.ContinueOnError)
+	os.Args = []string{"cv", "policy", "show", "-zone", "Certificates\\Other"}
+	v, err := parseCommand()
+	assert.Nil(t, err, "It should parse the show subcommand and its flags")
+	assert.Equal(t, "Certificates\\Other", v.(*PolicyCommand).Zone)
+
+	flag.CommandLine = flag.NewFlagSet("cv", flag.ContinueOnError)
+	os.Args = []string{"cv", "policy", "-zone", "Certificates"}
+	_, err = parseCommand()
+	assert.NotNil(t, err, "It should require a subcommand")
+
+	flag.CommandLine = flag.NewFlagSet("cv", flag.ContinueOnError)
+	os.Args = []string{"cv", "policy", "edit"}
+	_, err = parseCommand()
+	assert.NotNil(t, err, "It should reject an unknown subcommand")
+}
+
+func TestPendingCommandParse(t *testing.T) {
+	flag.CommandLine = flag.NewFlagSet("cv", flag.ContinueOnError)
+	os.Args = []string{"cv", "pending", "pickup", "-id", "3", "-timeout", "10m", "-key-password", "secret"}
+	v, err := parseCommand()
+	assert.Nil(t, err, "It should parse the pickup subcommand and its flags")
+	assert.Equal(t, "3", v.(*PendingCommand).ID)
+	assert.Equal(t, 10*time.Minute, v.(*PendingCommand).Timeout)
+	assert.Equal(t, "secret", v.(*PendingCommand).KeyPassword)
+
+	flag.CommandLine = flag.NewFlagSet("cv", flag.ContinueOnError)
+	os.Args = []string{"cv", "pending", "approve"}
+	_, err = parseCommand()
+	assert.NotNil(t, err, "It should reject an unknown subcommand")
+
+	async := GenerateAndStoreCommand{Name: "async", CommonName: "async", OnFailure: OnFailureRollback, CsrOrigin: "local", Async: true, GenOnly: true}
+	assert.NotNil(t, async.validateFlags(), "It should reject async with genonly")
+}
+
+func TestShowCommandFlags(t *testing.T) {
+	assert.Nil(t, (&ShowCommand{Name: "/cert"}).validateFlags(), "It should accept a CredHub name")
+	assert.Nil(t, (&ShowCommand{VenafiDN: "\\VED\\Policy\\cert"}).validateFlags(), "It should accept a Venafi DN")
+	assert.NotNil(t, (&ShowCommand{}).validateFlags(), "It should require a certificate")
+	assert.NotNil(t, (&ShowCommand{Name: "/cert", VenafiDN: "\\VED\\Policy\\cert"}).validateFlags(), "It should reject both selectors")
+}
+
+func TestVerifyCommandFlags(t *testing.T) {
+	assert.Nil(t, (&VerifyCommand{Name: "/cert"}).validateFlags(), "It should accept a CredHub name")
+	assert.Nil(t, (&VerifyCommand{All: true}).validateFlags(), "It should accept all")
+	assert.NotNil(t, (&VerifyCommand{}).validateFlags(), "It should require a selection")
+	assert.NotNil(t, (&VerifyCommand{Name: "/cert", All: true}).validateFlags(), "It should reject name with all")
+}
+
+func TestCommandsWithVault(t *testing.T) {
+	tpp := vcfake.NewServer()
+	defer tpp.Close()
+	tpp.AddUser("tppadmin", "password")
+	tpp.AddZone("Certificates", vcfake.OpenPolicy())
+	vault := vaultfake.NewServer("vault-token")
+	defer vault.Close()
+	vault.AddRole("web")
+	home := testHome(t, "")
+	bundle := filepath.Join(home, "tpp.pem")
+	err := ioutil.WriteFile(bundle, []byte(tpp.TrustBundle()), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := "connector_type: tpp\nvcert_base_url: " + tpp.URL + "\nvcert_trust_bundle: " + bundle +
+		"\nvcert_username: tppadmin\nvcert_password: password\nvcert_zone: Certificates\nvcert_revoke_tokens: true\n" +
+		"vault_base_url: " + vault.URL + "\nvault_token: vault-token\nvault_kv_path: secret/venafi\nvault_pki_path: pki\nvault_role: web\n"
+	err = ioutil.WriteFile(filepath.Join(home, ConfigFile), []byte(conf), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, runCommand("create", "-cn", "venafi.example.com", "-name", "/from-venafi"), "It should store a Venafi certificate in Vault without CredHub")
+	stored := vault.Versions("venafi/from-venafi")
+	assert.Len(t, stored, 1)
+	o := tpp.Objects()[0]
+	assert.Equal(t, strings.TrimSpace(o.Certificate), strings.TrimSpace(stored[0].Data["certificate"].(string)))
+
+	assert.Nil(t, runCommand("create", "-credhub", "-cn", "vault.example.com", "-name", "/from-vault"), "It should issue with the Vault role and import into Venafi")
+	assert.Len(t, vault.Versions("venafi/from-vault"), 1)
+	assert.Len(t, tpp.Objects(), 2)
+
+	assert.Nil(t, runCommand("list", "-bythumbprint"), "It should compare Venafi with Vault")
+}
+
+func TestSyncCommand(t *testing.T) {
+	tpp := vcfake.NewServer()
+	defer tpp.Close()
+	tpp.AddUser("tppadmin", "password")
+	tpp.AddZone("Certificates", vcfake.OpenPolicy())
+	kube := k8sfake.NewServer("sa-token")
+	defer kube.Close()
+	home := testHome(t, "")
+	bundle := filepath.Join(home, "tpp.pem")
+	token := filepath.Join(home, "token")
+	for path, content := range map[string]string{bundle: tpp.TrustBundle(), token: "sa-token\n"} {
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := "connector_type: tpp\nvcert_base_url: " + tpp.URL + "\nvcert_trust_bundle: " + bundle +
+		"\nvcert_username: tppadmin\nvcert_password: password\nvcert_zone: Certificates\nvcert_revoke_tokens: true\n" +
+		"kubernetes:\n  api_url: " + kube.URL + "\n  token_file: " + token + "\n  namespace: web\n" +
+		"  mappings:\n  - policy: Certificates\\Apps\n    namespace: apps\n"
+	err := ioutil.WriteFile(filepath.Join(home, ConfigFile), []byte(conf), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "api.example.com"}, NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	for _, name := range []string{"Apps\\api", "site"} {
+		_, err = tpp.AddCertificate("Certificates", name, certPEM, keyPEM)
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, runCommand("sync", "-dry-run"), "It should show the Secrets it would write")
+	assert.Empty(t, kube.Secrets())
+	assert.Nil(t, runCommand("sync"), "It should sync Venafi with Kubernetes without CredHub")
+	assert.Equal(t, []string{"apps/api", "web/site"}, kube.Secrets(), "It should apply the namespace mappings of the config file")
+	assert.NotNil(t, runCommand("sync", "-vlimit", "0"), "It should reject a limit below one")
+}
+
+func TestListCommandFlags(t *testing.T) {
+	assert.Nil(t, (&ListCommand{Type: typeCertificate, ByPath: true}).validateFlags(), "It should compare certificates by path")
+	assert.Nil(t, (&ListCommand{Type: typeSSH}).validateFlags(), "It should accept ssh keys")
+	assert.NotNil(t, (&ListCommand{Type: typeKeys, ByThumbprint: true}).validateFlags(), "It should reject certificate comparisons for keys")
+	assert.NotNil(t, (&ListCommand{Type: "password"}).validateFlags(), "It should reject unknown types")
+	assert.Nil(t, (&ListCommand{Type: typeCertificate, Profiles: stringSlice{"default", "b"}, Output: formatJSON}).validateFlags(), "It should compare profiles as JSON")
+	assert.NotNil(t, (&ListCommand{Type: typeCertificate, Profiles: stringSlice{"b", "b"}}).validateFlags(), "It should reject a profile listed twice")
+	assert.NotNil(t, (&ListCommand{Type: typeSSH, Profiles: stringSlice{"b"}}).validateFlags(), "It should reject profiles for keys")
+	assert.NotNil(t, (&ListCommand{Type: typeCertificate, Output: "yaml"}).validateFlags(), "It should reject unknown formats")
+	assert.Nil(t, (&ListCommand{Type: typeSSH, Statuses: stringSlice{statusMatched}}).validateFlags(), "It should filter keys by status")
+	assert.NotNil(t, (&ListCommand{Type: typeSSH, NameRegex: "web"}).validateFlags(), "It should reject certificate filters for keys")
+	assert.NotNil(t, (&ListCommand{Type: typeCertificate, Statuses: stringSlice{"missing"}}).validateFlags(), "It should reject unknown statuses")
+	assert.NotNil(t, (&ListCommand{Type: typeCertificate, NameRegex: "("}).validateFlags(), "It should reject invalid regular expressions")
+	assert.NotNil(t, (&ListCommand{Type: typeCertificate, KeyType: "dsa"}).validateFlags(), "It should reject unknown key types")
+	assert.NotNil(t, (&ListCommand{Type: typeCertificate, CustomFields: stringSlice{"Owner"}}).validateFlags(), "It should reject custom fields without a value")
+	assert.Nil(t, (&ListCommand{Type: typeCertificate, KeyType: "EC", ExpiresWithin: 30, CustomFields: stringSlice{"Owner=team-a"}}).validateFlags(), "It should accept certificate filters")
+}
+
+func TestListCommandProfiles(t *testing.T) {
+	tpp := vcfake.NewServer()
+	defer tpp.Close()
+	tpp.AddUser("tppadmin", "password")
+	tpp.AddZone("Certificates", vcfake.OpenPolicy())
+	ch := chfake.NewServer()
+	defer ch.Close()
+	ch.AddClient("cv_client", "secret")
+	other := chfake.NewServer()
+	defer other.Close()
+	other.AddClient("foundation_b", "secret")
+	home := testHome(t, "")
+	bundle := filepath.Join(home, "tpp.pem")
+	err := ioutil.WriteFile(bundle, []byte(tpp.TrustBundle()), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := "connector_type: tpp\nvcert_base_url: " + tpp.URL + "\nvcert_trust_bundle: " + bundle +
+		"\nvcert_username: tppadmin\nvcert_password: password\nvcert_zone: Certificates\nvcert_revoke_tokens: true\n" +
+		"credhub_endpoint: " + ch.URL + "\ncredhub_client_id: cv_client\ncredhub_client_secret: secret\n" +
+		"credhub_profiles:\n  b:\n    credhub_endpoint: " + other.URL + "\n    credhub_client_id: foundation_b\n    credhub_client_secret: secret\n" +
+		"  broken:\n    credhub_endpoint: " + other.URL + "\n    credhub_client_id: foundation_b\n    credhub_client_secret: wrong\n"
+	err = ioutil.WriteFile(filepath.Join(home, ConfigFile), []byte(conf), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, runCommand("login", "-clientid", "cv_client", "-clientsecret", "secret"))
+	other.PutCertificate("/b-only", "", tpp.CA(), "")
+
+	assert.Nil(t, runCommand("list", "-profile", "default", "-profile", "b"), "It should compare the configured profiles")
+	assert.Nil(t, runCommand("list", "-output", "json"), "It should write the comparison with CredHub as JSON")
+	assert.NotNil(t, runCommand("list", "-profile", "missing"), "It should reject an unknown profile")
+	assert.NotNil(t, runCommand("list", "-profile", "broken"), "It should raise an error when a profile can't authenticate")
+}
+
+func TestGenerateAndStoreCommandTemplate(t *testing.T) {
+	conf := `templates:
+  web-server:
+    organization: Acme
+    organizational_unit: [Web]
+    country: US
+    key_type: ecdsa
+    key_curve: p384
+    duration: 90
+    validity_hours: 2160
+`
+	testHome(t, conf)
+
+	flag.CommandLine = flag.NewFlagSet("cv", flag.ContinueOnError)
+	os.Args = []string{"cv", "create", "-cn", "web.example.com", "-template", "web-server", "-c", "DE", "-duration", "30"}
+	v, err := parseCommand()
+	assert.Nil(t, err, "It should apply the template")
+	cmd := v.(*GenerateAndStoreCommand)
+	assert.Equal(t, "Acme", cmd.OrganizationName)
+	assert.Equal(t, stringSlice{"Web"}, cmd.OrganizationalUnit)
+	assert.Equal(t, certificate.KeyTypeECDSA, cmd.KeyType)
+	assert.Equal(t, certificate.EllipticCurveP384, cmd.KeyCurve)
+	assert.Equal(t, "DE", cmd.Country, "It should let flags override the template")
+	assert.Equal(t, 30, cmd.Duration, "It should let flags override the template")
+	assert.Equal(t, 0, cmd.ValidityHours, "It should let duration override the template validity")
+
+	flag.CommandLine = flag.NewFlagSet("cv", flag.ContinueOnError)
+	os.Args = []string{"cv", "create", "-cn", "web.example.com", "-template", "missing"}
+	_, err = parseCommand()
+	assert.NotNil(t, err, "It should raise an error for an unknown template")
+}
@@ -21,14 +21,13 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
-	"code.cloudfoundry.org/credhub-cli/credhub"
-	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
-	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
-	"code.cloudfoundry.org/credhub-cli/credhub/credentials/values"
 	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
 	"github.com/newcontext-oss/credhub-venafi/chclient"
 	"github.com/newcontext-oss/credhub-venafi/output"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
 	"github.com/newcontext-oss/credhub-venafi/vcclient"
 )
 
@@ -56,31 +55,31 @@ func TestCmp3(t *testing.T) {
 
 type TestCertCollector struct {
 	values   []string
-	leftGet  func(certificate.CertificateInfo) string
-	rightGet func(credentials.CertificateMetadata) string
+	leftGet  func(Credential) string
+	rightGet func(Credential) string
 }
 
-func (m *TestCertCollector) CertificateInfo(ci certificate.CertificateInfo) {
+func (m *TestCertCollector) Left(ci Credential) {
 	if m.leftGet == nil {
-		m.leftGet = func(l certificate.CertificateInfo) string {
-			return l.CN
+		m.leftGet = func(l Credential) string {
+			return l.Label()
 		}
 	}
 	fmt.Printf(".")
 	m.values = append(m.values, m.leftGet(ci))
 }
 
-func (m *TestCertCollector) CertificateMetadata(cm credentials.CertificateMetadata) {
+func (m *TestCertCollector) Right(cm Credential) {
 	if m.rightGet == nil {
-		m.rightGet = func(r credentials.CertificateMetadata) string {
-			return r.Name
+		m.rightGet = func(r Credential) string {
+			return r.Label()
 		}
 	}
 	fmt.Printf(".")
 	m.values = append(m.values, m.rightGet(cm))
 }
 
-func (m *TestCertCollector) Equals(ci certificate.CertificateInfo, cm credentials.CertificateMetadata) {
+func (m *TestCertCollector) Equals(ci Credential, cm Credential) {
 	fmt.Printf(".")
 	m.values = append(m.values, m.leftGet(ci)+"="+m.rightGet(cm))
 }
@@ -131,20 +130,20 @@ func jsonUnmarshallFromFile(v interface{}, filename string) {
 
 func TestCompareCerts(t *testing.T) {
 	certInfo := []certificate.CertificateInfo{}
-	items := []credentials.CertificateMetadata{}
+	items := []secretstore.CertificateMetadata{}
 	jsonUnmarshallFromFile(&certInfo, "certinfo.json")
 	jsonUnmarshallFromFile(&items, "chitems.json")
 
 	ct := CommonNameStrategy{}
 	certCompare := compareCerts(&ct, certInfo, items, "", "")
 	assertLenEquals(t, len(certCompare), 4)
-	assertStringEquals(t, certCompare[0].Left.CN, "TestCertb")
-	assertStringContains(t, certCompare[0].Right.Name, "TestCertb")
-	assertStringEquals(t, certCompare[1].Left.CN, "TestCommonName")
-	assertStringContains(t, certCompare[1].Right.Name, "TestCommonName")
+	assertStringEquals(t, certCompare[0].Left.Label(), "TestCertb")
+	assertStringContains(t, certCompare[0].Right.ID(), "TestCertb")
+	assertStringEquals(t, certCompare[1].Left.Label(), "TestCommonName")
+	assertStringContains(t, certCompare[1].Right.ID(), "TestCommonName")
 	assertTrue(t, certCompare[2].Left == nil)
-	assertStringEquals(t, certCompare[2].Right.Name, "/aname")
-	assertStringEquals(t, certCompare[3].Left.CN, "localhost")
+	assertStringEquals(t, certCompare[2].Right.ID(), "/aname")
+	assertStringEquals(t, certCompare[3].Left.Label(), "localhost")
 	assertTrue(t, certCompare[3].Right == nil)
 }
 
@@ -222,22 +221,22 @@ func TestComparePathPrefixTransform(t *testing.T) {
 			for _, item := range test.left {
 				left = append(left, certificate.CertificateInfo{ID: item})
 			}
-			right := []credentials.CertificateMetadata{}
+			right := []secretstore.CertificateMetadata{}
 			for _, item := range test.right {
-				right = append(right, credentials.CertificateMetadata{Name: item})
+				right = append(right, secretstore.CertificateMetadata{Name: item})
 			}
 
 			comparison := buildCompareTransform(test.tct)
 
 			compare := func(
-				l []certificate.CertificateInfo,
-				r []credentials.CertificateMetadata,
-				comparison func(certificate.CertificateInfo, credentials.CertificateMetadata) int, tc CertCollector) {
+				l []Credential,
+				r []Credential,
+				comparison func(Credential, Credential) int, tc Collector) {
 				compareLists(l, r, comparison, tc, test.tct)
 			}
 
 			tc := &TestCertCollector{leftGet: test.tct.leftGet, rightGet: test.tct.rightGet}
-			compare(left, right, comparison, tc)
+			compare(venafiCertificates(left), credhubCertificates(right), comparison, tc)
 
 			assertStringSliceEqual(t, test.out, tc.values)
 		}
@@ -260,9 +259,9 @@ func TestCompareTransformCommonName(t *testing.T) {
 	comparison := buildCompareTransform(&tct)
 
 	compare := func(
-		l []certificate.CertificateInfo,
-		r []credentials.CertificateMetadata,
-		comparison func(certificate.CertificateInfo, credentials.CertificateMetadata) int, tc CertCollector) {
+		l []Credential,
+		r []Credential,
+		comparison func(Credential, Credential) int, tc Collector) {
 		compareLists(l, r, comparison, tc, &tct)
 	}
 	runTests := func() {
@@ -271,12 +270,12 @@ func TestCompareTransformCommonName(t *testing.T) {
 			for _, item := range test.left {
 				left = append(left, certificate.CertificateInfo{CN: item})
 			}
-			right := []credentials.CertificateMetadata{}
+			right := []secretstore.CertificateMetadata{}
 			for _, item := range test.right {
-				right = append(right, credentials.CertificateMetadata{Name: item})
+				right = append(right, secretstore.CertificateMetadata{Name: item})
 			}
 			tc := &TestCertCollector{leftGet: tct.leftGet, rightGet: tct.rightGet}
-			compare(left, right, comparison, tc)
+			compare(venafiCertificates(left), credhubCertificates(right), comparison, tc)
 			assertStringSliceEqual(t, test.out, tc.values)
 		}
 	}
@@ -285,9 +284,9 @@ func TestCompareTransformCommonName(t *testing.T) {
 	runTests()
 
 	compare = func(
-		l []certificate.CertificateInfo,
-		r []credentials.CertificateMetadata,
-		comparison func(certificate.CertificateInfo, credentials.CertificateMetadata) int, tc CertCollector) {
+		l []Credential,
+		r []Credential,
+		comparison func(Credential, Credential) int, tc Collector) {
 		compareSortedLists(l, r, comparison, tc)
 	}
 
@@ -309,14 +308,14 @@ func TestCompareFunc(t *testing.T) {
 		{[]string{"a", "b", "c"}, []string{"d"}, []string{"a", "b", "c", "d"}},
 	}
 
-	comparison := func(l certificate.CertificateInfo, r credentials.CertificateMetadata) int {
-		return strings.Compare(l.CN, r.Name)
+	comparison := func(l Credential, r Credential) int {
+		return strings.Compare(l.Label(), r.Label())
 	}
 
 	compare := func(
-		l []certificate.CertificateInfo,
-		r []credentials.CertificateMetadata,
-		comparison func(certificate.CertificateInfo, credentials.CertificateMetadata) int, tc CertCollector) {
+		l []Credential,
+		r []Credential,
+		comparison func(Credential, Credential) int, tc Collector) {
 		compareSortedLists(l, r, comparison, tc)
 	}
 	runTests := func() {
@@ -325,12 +324,12 @@ func TestCompareFunc(t *testing.T) {
 			for _, item := range test.left {
 				left = append(left, certificate.CertificateInfo{CN: item})
 			}
-			right := []credentials.CertificateMetadata{}
+			right := []secretstore.CertificateMetadata{}
 			for _, item := range test.right {
-				right = append(right, credentials.CertificateMetadata{Name: item})
+				right = append(right, secretstore.CertificateMetadata{Name: item})
 			}
 			tc := &TestCertCollector{}
-			compare(left, right, comparison, tc)
+			compare(venafiCertificates(left), credhubCertificates(right), comparison, tc)
 			assertStringSliceEqual(t, test.out, tc.values)
 		}
 	}
@@ -401,10 +400,10 @@ func TestRegexReplace(t *testing.T) {
 func TestCompareAndTransformThumbprint(t *testing.T) {
 	c := ThumbprintStrategy{}
 	// assertStringEquals(t, credname, "credname")
-	c.getCertificate = func(name string) (credentials.Certificate, error) {
-		return credentials.Certificate{Value: values.Certificate{Certificate: GetCert()}}, nil
+	c.getCertificate = func(name string) (secretstore.Certificate, error) {
+		return secretstore.Certificate{Certificate: GetCert()}, nil
 	}
-	credname := c.rightGet(credentials.CertificateMetadata{Name: "credname"})
+	credname := c.rightGet(&CredhubCertificate{Metadata: secretstore.CertificateMetadata{Name: "credname"}})
 	assertStringEquals(t, "ebdbe32ef98991695958ea2510287f0e6c52a483", credname)
 	// output := c.rightTransform("credname")
 	// fmt.Println("s", output)
@@ -424,25 +423,37 @@ func TestJoinRoot(t *testing.T) {
 
 type CredhubProxyMock struct {
 	CredhubProxy chclient.CredhubProxy
-	returnlist   []credentials.CertificateMetadata
+	returnlist   []secretstore.CertificateMetadata
 }
 
 // Need all of the methods for the Interface
-func (cp *CredhubProxyMock) List() ([]credentials.CertificateMetadata, error) {
+func (cp *CredhubProxyMock) Name() string {
+	return "CredHub"
+}
+func (cp *CredhubProxyMock) List() ([]secretstore.CertificateMetadata, error) {
 	return cp.returnlist, nil
 }
 func (cp *CredhubProxyMock) DeleteCert(name string) error {
 	return nil
 }
-func (cp *CredhubProxyMock) GenerateCertificate(name string, parameters generate.Certificate, overwrite credhub.Mode) (credentials.Certificate, error) {
-	return credentials.Certificate{}, nil
+func (cp *CredhubProxyMock) GenerateCertificate(name string, parameters secretstore.GenerateParameters, overwrite bool) (secretstore.Certificate, error) {
+	return secretstore.Certificate{}, nil
+}
+func (cp *CredhubProxyMock) GetCertificate(name string) (secretstore.Certificate, error) {
+	return secretstore.Certificate{}, nil
+}
+func (cp *CredhubProxyMock) GetCertificateVersions(name string) ([]secretstore.Certificate, error) {
+	return []secretstore.Certificate{}, nil
 }
-func (cp *CredhubProxyMock) GetCertificate(name string) (credentials.Certificate, error) {
-	return credentials.Certificate{}, nil
+func (cp *CredhubProxyMock) GetCertificateMetadata(name string) (secretstore.CertificateMetadata, error) {
+	return secretstore.CertificateMetadata{}, nil
 }
 func (cp *CredhubProxyMock) PutCertificate(name string, ca string, certificate string, privateKey string) error {
 	return nil
 }
+func (cp *CredhubProxyMock) ListKeys(path string, types []string) ([]secretstore.Key, error) {
+	return []secretstore.Key{}, nil
+}
 
 type VcertProxyMock struct {
 	VcertProxy vcclient.VcertProxy
@@ -452,18 +463,48 @@ type VcertProxyMock struct {
 func (v *VcertProxyMock) List(vlimit int, zone string) ([]certificate.CertificateInfo, error) {
 	return v.retCerts, nil
 }
-func (v *VcertProxyMock) Revoke(thumbprint string) error {
+func (v *VcertProxyMock) Revoke(args *vcclient.RevokeArgs) error {
+	return nil
+}
+func (v *VcertProxyMock) FindByThumbprint(thumbprint string) ([]string, error) {
+	return []string{}, nil
+}
+func (v *VcertProxyMock) Delete(dn string) error {
 	return nil
 }
 func (v *VcertProxyMock) Generate(args *vcclient.CertArgs) (*certificate.PEMCollection, error) {
 	return &certificate.PEMCollection{}, nil
 }
+func (v *VcertProxyMock) Request(args *vcclient.CertArgs) (*vcclient.Enrollment, error) {
+	return &vcclient.Enrollment{}, nil
+}
+func (v *VcertProxyMock) Pickup(e *vcclient.Enrollment, timeout time.Duration) (*certificate.PEMCollection, error) {
+	return &certificate.PEMCollection{}, nil
+}
+func (v *VcertProxyMock) ReadZone(zone string) (*endpoint.ZoneConfiguration, error) {
+	return endpoint.NewZoneConfiguration(), nil
+}
 func (v *VcertProxyMock) RetrieveCertificateByThumbprint(thumbprint string) (*certificate.PEMCollection, error) {
 	return &certificate.PEMCollection{}, nil
 }
+func (v *VcertProxyMock) RetrieveCertificateByDN(dn string) (*certificate.PEMCollection, error) {
+	return &certificate.PEMCollection{}, nil
+}
+func (v *VcertProxyMock) RetrieveKeyPair(dn string) (*certificate.PEMCollection, error) {
+	return &certificate.PEMCollection{}, nil
+}
 func (v *VcertProxyMock) PutCertificate(certName string, cert string, privateKey string) error {
 	return nil
 }
+func (v *VcertProxyMock) ListSSHKeys(limit int) ([]vcclient.SSHKey, error) {
+	return []vcclient.SSHKey{}, nil
+}
+func (v *VcertProxyMock) CertificateDetails(dn string) (*vcclient.CertificateDetails, error) {
+	return &vcclient.CertificateDetails{}, nil
+}
+func (v *VcertProxyMock) HoldsPrivateKey(dn string) (bool, error) {
+	return true, nil
+}
 func (v *VcertProxyMock) Login() error {
 	return nil
 }
@@ -491,14 +532,14 @@ func TestCVListBoth(t *testing.T) {
 		for _, item := range test.left {
 			left = append(left, certificate.CertificateInfo{ID: item})
 		}
-		right := []credentials.CertificateMetadata{}
+		right := []secretstore.CertificateMetadata{}
 		for _, item := range test.right {
-			right = append(right, credentials.CertificateMetadata{Name: item})
+			right = append(right, secretstore.CertificateMetadata{Name: item})
 		}
 
 		ch := CredhubProxyMock{returnlist: right}
 		v := VcertProxyMock{retCerts: left}
-		c := CV{credhub: &ch, vcert: &v}
+		c := CV{store: &ch, vcert: &v}
 		l := &ListCommand{VenafiPrefix: test.leftPrefix, CredhubPrefix: test.rightPrefix, ByPath: true}
 		r, err := c.listBoth(l)
 		assertTrue(t, err == nil)
@@ -507,10 +548,10 @@ func TestCVListBoth(t *testing.T) {
 			l := ""
 			r := ""
 			if i.Left != nil {
-				l = i.Left.ID
+				l = i.Left.ID()
 			}
 			if i.Right != nil {
-				r = i.Right.Name
+				r = i.Right.ID()
 			}
 			// fmt.Println("s", i)
 			s = append(s, fmt.Sprintf("%s=%s", l, r))
@@ -518,7 +559,7 @@ func TestCVListBoth(t *testing.T) {
 		fmt.Println("s", s)
 
 		// tc := &TestCertCollector{}
-		// compare(left, right, comparison, tc)
+		// compare(venafiCertificates(left), credhubCertificates(right), comparison, tc)
 		assertStringSliceEqual(t, test.out, s)
 	}
 }
@@ -20,6 +20,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -53,6 +54,8 @@ type YAMLConfig struct {
 	VcertAccessToken string `yaml:"vcert_access_token"`
 	VcertLegacyAuth  bool   `yaml:"vcert_legacy_auth"`
 	VcertBaseURL     string `yaml:"vcert_base_url"`
+	VcertClientID    string `yaml:"vcert_client_id"`
+	VcertScope       string `yaml:"vcert_scope"`
 	ConnectorType    string `yaml:"connector_type"`
 	ClientID         string `yaml:"credhub_client_id"`
 	ClientSecret     string `yaml:"credhub_client_secret"`
@@ -62,6 +65,106 @@ type YAMLConfig struct {
 	LogLevel         string `yaml:"log_level"`
 
 	SkipTLSValidation bool `yaml:"skip_tls_validation"`
+
+	// VcertRevokeTokens requests a token for every command and revokes it
+	// at the end instead of keeping a TPP session
+	VcertRevokeTokens bool `yaml:"vcert_revoke_tokens"`
+
+	// Vault replaces CredHub when vault_base_url is set
+	VaultBaseURL string `yaml:"vault_base_url"`
+	VaultToken   string `yaml:"vault_token"`
+	VaultKVPath  string `yaml:"vault_kv_path"`
+	VaultPKIPath string `yaml:"vault_pki_path"`
+	VaultRole    string `yaml:"vault_role"`
+
+	// files with PEM certificates and keys for TLS connections
+	CredhubCACert     string `yaml:"credhub_ca_cert"`
+	UAACACert         string `yaml:"uaa_ca_cert"`
+	CredhubClientCert string `yaml:"credhub_client_cert"`
+	CredhubClientKey  string `yaml:"credhub_client_key"`
+	VcertTrustBundle  string `yaml:"vcert_trust_bundle"`
+	VaultCACert       string `yaml:"vault_ca_cert"`
+
+	VcertProxy     string        `yaml:"vcert_proxy"`
+	CredhubProxy   string        `yaml:"credhub_proxy"`
+	VaultProxy     string        `yaml:"vault_proxy"`
+	NoProxy        string        `yaml:"no_proxy"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+
+	Templates  map[string]Template `yaml:"templates"`
+	Retry      Retry               `yaml:"retry"`
+	Kubernetes Kubernetes          `yaml:"kubernetes"`
+
+	// CredhubProfiles are further CredHubs, such as those of other
+	// foundations, that `cv list -profiles` compares by name
+	CredhubProfiles map[string]CredhubProfile `yaml:"credhub_profiles"`
+}
+
+// CredhubProfile contains the settings of a further CredHub. It is
+// authenticated with client credentials or a client certificate, not with
+// `cv login`.
+type CredhubProfile struct {
+	Endpoint     string `yaml:"credhub_endpoint"`
+	ClientID     string `yaml:"credhub_client_id"`
+	ClientSecret string `yaml:"credhub_client_secret"`
+	CACert       string `yaml:"credhub_ca_cert"`
+	UAACACert    string `yaml:"uaa_ca_cert"`
+	ClientCert   string `yaml:"credhub_client_cert"`
+	ClientKey    string `yaml:"credhub_client_key"`
+	Proxy        string `yaml:"credhub_proxy"`
+}
+
+// Kubernetes contains the settings for syncing certificates to Kubernetes
+// Secrets with `cv sync`. Without api_url the service account of the pod cv
+// runs in is used.
+type Kubernetes struct {
+	APIURL    string `yaml:"api_url"`
+	TokenFile string `yaml:"token_file"`
+	CACert    string `yaml:"ca_cert"`
+	Proxy     string `yaml:"proxy"`
+	// Namespace receives the certificates no mapping applies to
+	Namespace string              `yaml:"namespace"`
+	Mappings  []KubernetesMapping `yaml:"mappings"`
+}
+
+// KubernetesMapping maps the certificates below a Venafi policy folder to a
+// namespace. Secret names start with Prefix followed by the path of the
+// certificate below the folder.
+type KubernetesMapping struct {
+	Policy    string `yaml:"policy"`
+	Namespace string `yaml:"namespace"`
+	Prefix    string `yaml:"prefix"`
+}
+
+// Retry contains the settings for retrying calls to CredHub and Venafi that
+// failed with a transient error. Unset values use the defaults.
+type Retry struct {
+	Attempts   int           `yaml:"attempts"`
+	BaseDelay  time.Duration `yaml:"base_delay"`
+	MaxDelay   time.Duration `yaml:"max_delay"`
+	Budget     int           `yaml:"budget"`
+	BreakAfter int           `yaml:"break_after"`
+}
+
+// Template contains default values for `cv create`, selected with -template
+type Template struct {
+	Organization       string   `yaml:"organization"`
+	OrganizationalUnit []string `yaml:"organizational_unit"`
+	Country            string   `yaml:"country"`
+	State              string   `yaml:"state"`
+	Locality           string   `yaml:"locality"`
+	KeyType            string   `yaml:"key_type"`
+	KeyLength          int      `yaml:"key_length"`
+	KeyCurve           string   `yaml:"key_curve"`
+	KeyUsage           []string `yaml:"key_usage"`
+	ExtKeyUsage        []string `yaml:"ext_key_usage"`
+	Duration           int      `yaml:"duration"`
+	ValidityHours      int      `yaml:"validity_hours"`
+	IssuerHint         string   `yaml:"issuer_hint"`
+	CsrOrigin          string   `yaml:"csr_origin"`
+	CA                 string   `yaml:"ca"`
+	SelfSign           bool     `yaml:"self_sign"`
 }
 
 // ReadConfig reads the configuration file and returns the information in a struct
@@ -16,6 +16,7 @@ package config_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -57,3 +58,21 @@ func TestReadConfigWithInvalidFile(t *testing.T) {
 	_, err := config.ReadConfig(dataDir, "test_config_invalid.yml")
 	assert.NotNil(t, err, "It should raise an error when the config file is invalid")
 }
+
+func TestReadConfigWithTemplates(t *testing.T) {
+	actual, err := config.ReadConfig(dataDir, "test_config_templates.yml")
+	assert.Nil(t, err, "It should read templates")
+	assert.Len(t, actual.Templates, 2)
+	web := actual.Templates["web-server"]
+	assert.Equal(t, "Acme", web.Organization)
+	assert.Equal(t, []string{"Web", "Ops"}, web.OrganizationalUnit)
+	assert.Equal(t, []string{"server_auth"}, web.ExtKeyUsage)
+	assert.Equal(t, 90, web.Duration)
+	assert.Equal(t, "p384", actual.Templates["mtls-client"].KeyCurve)
+}
+
+func TestReadConfigWithRetry(t *testing.T) {
+	actual, err := config.ReadConfig(dataDir, "test_config_retry.yml")
+	assert.Nil(t, err, "It should read the retry settings")
+	assert.Equal(t, config.Retry{Attempts: 5, BaseDelay: 250 * time.Millisecond, MaxDelay: 5 * time.Second, Budget: 20, BreakAfter: 3}, actual.Retry)
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/newcontext-oss/credhub-venafi/chclient"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
+	"github.com/newcontext-oss/credhub-venafi/vcclient"
+)
+
+// credential types cv compares, named like the CredHub types
+const (
+	typeCertificate = "certificate"
+	typeRSA         = "rsa"
+	typeSSH         = "ssh"
+	// typeKeys selects both rsa and ssh credentials
+	typeKeys = "keys"
+)
+
+// Credential is an item of the Venafi or CredHub inventory that is compared
+// with the other side
+type Credential interface {
+	// Type is the credential type, certificate, rsa or ssh
+	Type() string
+	// ID identifies the credential on its platform, the Venafi DN or key id
+	// or the CredHub name
+	ID() string
+	// Label is the name the credential is shown and compared by
+	Label() string
+}
+
+// fingerprinted is a credential whose fingerprint is known without fetching
+// its value
+type fingerprinted interface {
+	Fingerprint() string
+}
+
+// fingerprintOf returns the known fingerprint of a credential or ""
+func fingerprintOf(c Credential) string {
+	f, ok := c.(fingerprinted)
+	if !ok {
+		return ""
+	}
+	return f.Fingerprint()
+}
+
+// VenafiCertificate is a certificate object listed from Venafi
+type VenafiCertificate struct {
+	Info certificate.CertificateInfo
+}
+
+// Type returns certificate
+func (c *VenafiCertificate) Type() string { return typeCertificate }
+
+// ID returns the DN of the certificate object
+func (c *VenafiCertificate) ID() string { return c.Info.ID }
+
+// Label returns the common name of the certificate
+func (c *VenafiCertificate) Label() string { return c.Info.CN }
+
+// Fingerprint returns the SHA-1 thumbprint of the certificate
+func (c *VenafiCertificate) Fingerprint() string { return c.Info.Thumbprint }
+
+// CredhubCertificate is a certificate credential listed from CredHub
+type CredhubCertificate struct {
+	Metadata secretstore.CertificateMetadata
+}
+
+// Type returns certificate
+func (c *CredhubCertificate) Type() string { return typeCertificate }
+
+// ID returns the credential name
+func (c *CredhubCertificate) ID() string { return c.Metadata.Name }
+
+// Label returns the credential name
+func (c *CredhubCertificate) Label() string { return c.Metadata.Name }
+
+// VenafiSSHKey is a key of the Venafi SSH key inventory
+type VenafiSSHKey struct {
+	Key vcclient.SSHKey
+}
+
+// Type returns ssh
+func (k *VenafiSSHKey) Type() string { return typeSSH }
+
+// ID returns the key id
+func (k *VenafiSSHKey) ID() string { return k.Key.KeyID }
+
+// Label returns the user and host the key was found at
+func (k *VenafiSSHKey) Label() string {
+	if k.Key.Username == "" {
+		return k.Key.Location
+	}
+	return k.Key.Username + "@" + k.Key.Location
+}
+
+// Fingerprint returns the SHA256 fingerprint of the public key
+func (k *VenafiSSHKey) Fingerprint() string {
+	// TPP may leave out the prefix ssh-keygen and CredHub use
+	return "SHA256:" + strings.TrimPrefix(k.Key.Fingerprint, "SHA256:")
+}
+
+// CredhubKey is an rsa or ssh credential listed from CredHub
+type CredhubKey struct {
+	Key secretstore.Key
+}
+
+// Type returns rsa or ssh
+func (k *CredhubKey) Type() string { return k.Key.Type }
+
+// ID returns the credential name
+func (k *CredhubKey) ID() string { return k.Key.Name }
+
+// Label returns the credential name
+func (k *CredhubKey) Label() string { return k.Key.Name }
+
+// Fingerprint returns the SHA256 fingerprint of the public key
+func (k *CredhubKey) Fingerprint() string { return k.Key.Fingerprint }
+
+func venafiCertificates(certs []certificate.CertificateInfo) []Credential {
+	out := []Credential{}
+	for _, cert := range certs {
+		out = append(out, &VenafiCertificate{Info: cert})
+	}
+	return out
+}
+
+func credhubCertificates(certs []secretstore.CertificateMetadata) []Credential {
+	out := []Credential{}
+	for _, cert := range certs {
+		out = append(out, &CredhubCertificate{Metadata: cert})
+	}
+	return out
+}
+
+func venafiSSHKeys(keys []vcclient.SSHKey) []Credential {
+	out := []Credential{}
+	for _, key := range keys {
+		out = append(out, &VenafiSSHKey{Key: key})
+	}
+	return out
+}
+
+func credhubKeys(keys []secretstore.Key) []Credential {
+	out := []Credential{}
+	for _, key := range keys {
+		out = append(out, &CredhubKey{Key: key})
+	}
+	return out
+}
+
+// keyTypes returns the CredHub types selected by a key type option, or nil
+// for certificates
+func keyTypes(t string) []string {
+	switch t {
+	case typeRSA, typeSSH:
+		return []string{t}
+	case typeKeys:
+		return chclient.KeyTypes
+	}
+	return nil
+}
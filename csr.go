@@ -0,0 +1,90 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/newcontext-oss/credhub-venafi/vcclient"
+)
+
+// readCSR reads a PEM CSR and, if keyFile is set, the private key that
+// belongs to it. An encrypted key is decrypted with password.
+func readCSR(csrFile string, keyFile string, password string) ([]byte, string, error) {
+	csrPEM, err := ioutil.ReadFile(csrFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read CSR: %s", err)
+	}
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+		return nil, "", fmt.Errorf("%s does not contain a PEM certificate request", csrFile)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%s is not a valid certificate request: %s", csrFile, err)
+	}
+	if keyFile == "" {
+		return csrPEM, "", nil
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read private key: %s", err)
+	}
+	key, err := vcclient.DecryptPrivateKey(string(keyPEM), password)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not decrypt %s: %s", keyFile, err)
+	}
+	signer, err := parsePrivateKey(key)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not parse %s: %s", keyFile, err)
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(csr.PublicKey) {
+		return nil, "", fmt.Errorf("private key in %s does not belong to the CSR in %s", keyFile, csrFile)
+	}
+	return csrPEM, key, nil
+}
+
+// parsePrivateKey parses a PKCS#1, PKCS#8 or EC private key in PEM format
+func parsePrivateKey(keyPEM string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
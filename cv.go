@@ -16,17 +16,22 @@ package main
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"syscall"
+	"time"
 
-	"code.cloudfoundry.org/credhub-cli/credhub"
-	"code.cloudfoundry.org/credhub-cli/credhub/credentials"
-	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
 	"github.com/Venafi/vcert/pkg/certificate"
 	"github.com/newcontext-oss/credhub-venafi/chclient"
+	"github.com/newcontext-oss/credhub-venafi/k8sclient"
 	"github.com/newcontext-oss/credhub-venafi/output"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
 	"github.com/newcontext-oss/credhub-venafi/vcclient"
 )
 
@@ -35,173 +40,432 @@ var ConfigFile = ".cv.conf"
 
 // CV represents an object that manipulates both credhub and vcert
 type CV struct {
-	credhub      chclient.ICredhubProxy
+	store        secretstore.Store
 	configLoader chclient.ConfigLoader
 	vcert        vcclient.IVcertProxy
+	kubernetes   k8sclient.IClient
+	signals      chan os.Signal
+}
+
+// storeTitle is the name of the secret store in the upper case status
+// messages
+func (c *CV) storeTitle() string {
+	return strings.ToUpper(c.store.Name())
+}
+
+// watchSignals ends the Venafi session when cv is interrupted or terminated
+// before close is called
+func (c *CV) watchSignals() {
+	c.signals = make(chan os.Signal, 1)
+	signal.Notify(c.signals, os.Interrupt, syscall.SIGTERM)
+	go func(signals chan os.Signal) {
+		if _, ok := <-signals; ok {
+			c.logout()
+			os.Exit(1)
+		}
+	}(c.signals)
+}
+
+// close ends the Venafi session. Every command that connects defers it.
+func (c *CV) close() {
+	if c.signals != nil {
+		signal.Stop(c.signals)
+		close(c.signals)
+		c.signals = nil
+	}
+	c.logout()
+}
+
+func (c *CV) logout() {
+	err := c.vcert.Logout()
+	if err != nil {
+		output.Errorf("error with cleanup. %s\n", err)
+	}
 }
 
 func (c *CV) generateAndStoreCredhub(name string, v *GenerateAndStoreCommand, store bool) error {
-	// parameters := models.GenerationParameters{
-	parameters := generate.Certificate{
-		// IncludeSpecial:   false,
-		// ExcludeNumber:    f,
-		// ExcludeUpper:     c.ExcludeUpper,
-		// ExcludeLower:     c.ExcludeLower,
-		KeyLength:        v.KeyLength,
-		CommonName:       v.CommonName,
-		Organization:     v.OrganizationName,
-		OrganizationUnit: strings.Join(v.OrganizationalUnit, ","),
-		Locality:         v.Locality,
-		State:            v.State,
-		Country:          v.Country,
-		AlternativeNames: v.AlternativeName,
-		ExtendedKeyUsage: v.ExtKeyUsage,
-		KeyUsage:         v.KeyUsage,
-		Duration:         v.Duration,
-		Ca:               v.CA,
-		SelfSign:         v.SelfSign,
-		IsCA:             v.IsCA,
-	}
-
-	output.Status("NOW GENERATING ON CREDHUB '%s'\n", name)
-	certificate, err := c.credhub.GenerateCertificate(name, parameters, credhub.NoOverwrite)
+	spec, err := v.spec()
 	if err != nil {
 		return err
 	}
-
-	if !store {
-		return nil
+	parameters, err := spec.StoreParameters()
+	if err != nil {
+		return err
 	}
 
-	output.Status("NOW UPLOADING TO VENAFI '%s'\n", name)
-	err = c.vcert.PutCertificate(name, certificate.Value.Certificate, certificate.Value.PrivateKey)
+	// without overwrite an existing credential is returned unchanged, so it
+	// must not be removed when rolling back
+	_, err = c.store.GetCertificate(name)
+	existed := !errors.Is(err, secretstore.ErrNotFound)
+
+	output.Status("NOW GENERATING ON %s '%s'\n", c.storeTitle(), name)
+	certificate, err := c.store.GenerateCertificate(name, parameters, false)
 	if err != nil {
 		return err
 	}
 
-	err = c.vcert.Logout()
-	if err != nil {
-		output.Errorf("error with cleanup. %s\n", err)
+	if !store {
+		return nil
 	}
 
-	return nil
+	output.Status("NOW UPLOADING TO VENAFI '%s'\n", name)
+	err = c.vcert.PutCertificate(name, certificate.Certificate, certificate.PrivateKey)
+	if err != nil {
+		op := PendingOperation{
+			Target:      targetVenafi,
+			Name:        name,
+			Certificate: certificate.Certificate,
+			PrivateKey:  certificate.PrivateKey,
+		}
+		err = c.recoverCreate(v.OnFailure, op, err, func() error {
+			if existed {
+				output.Status("LEAVING EXISTING %s CREDENTIAL '%s' IN PLACE\n", c.storeTitle(), name)
+				return nil
+			}
+			output.Status("NOW ROLLING BACK %s '%s'\n", c.storeTitle(), name)
+			return c.store.DeleteCert(name)
+		})
+	}
+	return err
 }
 
 func (c *CV) generateAndStore(name string, v *GenerateAndStoreCommand, store bool) error {
 	output.Status("NOW GENERATING ON VENAFI '%s'\n", name)
 	// we assume that login has already been done on credhub
-	args := &vcclient.CertArgs{
-		Name:               v.Name,
-		CommonName:         v.CommonName,
-		OrganizationName:   v.OrganizationName,
-		SANDNS:             v.SANDNS,
-		KeyCurve:           v.KeyCurve,
-		OrganizationalUnit: v.OrganizationalUnit,
-		Country:            v.Country,
-		State:              v.State,
-		Locality:           v.Locality,
-		SANEmail:           v.SANEmail,
-		SANIP:              v.SANIP,
-		KeyPassword:        v.KeyPassword,
-	}
-	cert, err := c.vcert.Generate(args)
+	spec, err := v.spec()
 	if err != nil {
 		return err
 	}
-
-	if !store {
+	args, err := spec.VenafiArgs()
+	if err != nil {
+		return err
+	}
+	userKey := ""
+	if v.CSRFile != "" {
+		args.CSR, userKey, err = readCSR(v.CSRFile, v.KeyFile, v.KeyPassword)
+		if err != nil {
+			return err
+		}
+		args.CsrOrigin = certificate.UserProvidedCSR
+	}
+	e, err := c.vcert.Request(args)
+	if err != nil {
+		return err
+	}
+	if v.CSRFile != "" {
+		e.PrivateKey = userKey
+	}
+	if v.Async {
+		op, err := c.recordPickup(name, e)
+		if err != nil {
+			return err
+		}
+		output.Status("SUBMITTED '%s' TO VENAFI, RUN `cv pending pickup -id %s` TO COMPLETE IT\n", name, op.ID)
 		return nil
 	}
 
-	err = c.vcert.Logout()
+	cert, err := c.vcert.Pickup(e, v.Timeout)
+	if pending, ok := err.(*vcclient.PendingError); ok {
+		// keep the request so that it is not lost while it waits for approval
+		op, recordErr := c.recordPickup(name, e)
+		if recordErr != nil {
+			return fmt.Errorf("%s; could not record pending operation: %s", pending, recordErr)
+		}
+		return fmt.Errorf("%s; run `cv pending pickup -id %s` to complete it", pending, op.ID)
+	}
 	if err != nil {
-		output.Errorf("error with cleanup. %s\n", err)
+		return err
+	}
+	if v.CSRFile != "" {
+		cert.PrivateKey = userKey
 	}
 
-	output.Status("NOW UPLOADING TO CREDHUB '%s'\n", name)
+	if !store {
+		return nil
+	}
+
+	output.Status("NOW UPLOADING TO %s '%s'\n", c.storeTitle(), name)
 	certName := name
-	ca := ""
+	ca := pemChain(cert.Chain)
 	certificate := cert.Certificate
 	privateKey := cert.PrivateKey
-	return c.credhub.PutCertificate(certName, ca, certificate, privateKey)
-}
+	err = c.store.PutCertificate(certName, ca, certificate, privateKey)
+	if err != nil {
+		op := PendingOperation{
+			Target:      targetCredhub,
+			Name:        certName,
+			CA:          ca,
+			Certificate: certificate,
+			PrivateKey:  privateKey,
+		}
+		err = c.recoverCreate(v.OnFailure, op, err, func() error {
+			tp, err := thumbprintOf(certificate)
+			if err != nil {
+				return err
+			}
+			output.Status("NOW ROLLING BACK VENAFI '%s'\n", name)
+			return c.vcert.Revoke(&vcclient.RevokeArgs{
+				Thumbprint: tp,
+				Reason:     "cessation",
+				Comment:    "rolled back by cv: upload to " + c.store.Name() + " failed",
+				Disable:    true,
+			})
+		})
+	}
+	return err
+}
+
+// recoverCreate handles a failed upload to the second platform of a create,
+// either by rolling back the first platform or by recording the upload so
+// that it can be completed with `cv resume`
+func (c *CV) recoverCreate(onFailure string, op PendingOperation, cause error, rollback func() error) error {
+	if onFailure == OnFailureResume {
+		op.Error = cause.Error()
+		op, err := c.pendingStore().Add(op)
+		if err != nil {
+			return fmt.Errorf("upload of '%s' to %s failed: %s; could not record pending operation: %s", op.Name, op.Target, cause, err)
+		}
+		return fmt.Errorf("upload of '%s' to %s failed: %s; run `cv resume -id %s` to complete it", op.Name, op.Target, cause, op.ID)
+	}
 
-func (c *CV) deleteCert(name string) error {
-	cert, err := c.credhub.GetCertificate(name)
+	err := rollback()
 	if err != nil {
-		return err
+		return fmt.Errorf("upload of '%s' to %s failed: %s; rollback failed: %s", op.Name, op.Target, cause, err)
 	}
-	certStr := cert.Value.Certificate
-	tp, err := chclient.GetThumbprint(certStr)
+	return fmt.Errorf("upload of '%s' to %s failed and was rolled back: %s", op.Name, op.Target, cause)
+}
+
+// recordPickup records a submitted Venafi request so that the certificate can
+// be picked up and uploaded to CredHub later
+func (c *CV) recordPickup(name string, e *vcclient.Enrollment) (PendingOperation, error) {
+	return c.pendingStore().Add(PendingOperation{
+		Target:     targetCredhub,
+		Name:       name,
+		PrivateKey: e.PrivateKey,
+		PickupID:   e.PickupID,
+		CsrOrigin:  vcclient.CsrOriginName(e.CsrOrigin),
+	})
+}
+
+// resume completes pending operations. If id is empty all of them are
+// attempted. Certificates that still have to be picked up are tried once.
+func (c *CV) resume(id string) error {
+	return c.completePending(id, 0, false, "")
+}
+
+// pickupPending picks up the certificates of pending Venafi requests, waiting
+// up to timeout for each, and uploads them to CredHub. If id is empty all of
+// them are attempted. Keys generated by Venafi are exported with keyPassword,
+// which is not recorded with the request.
+func (c *CV) pickupPending(id string, timeout time.Duration, keyPassword string) error {
+	return c.completePending(id, timeout, true, keyPassword)
+}
+
+func (c *CV) completePending(id string, timeout time.Duration, pickupOnly bool, keyPassword string) error {
+	store := c.pendingStore()
+	ops, err := store.Load()
 	if err != nil {
 		return err
 	}
-	tp2 := hex.EncodeToString(tp[:])
 
-	output.Status("NOW DELETING FROM VENAFI '%s'\n", name)
-	err = c.vcert.Revoke(tp2)
+	found := false
+	failed := 0
+	waiting := 0
+	for _, op := range ops {
+		if id != "" && op.ID != id || pickupOnly && op.PickupID == "" {
+			continue
+		}
+		found = true
+		if op.PickupID != "" {
+			err = c.pickup(&op, timeout, keyPassword)
+			if pending, ok := err.(*vcclient.PendingError); ok {
+				waiting++
+				output.Status("'%s' IS STILL PENDING IN VENAFI: %s\n", op.Name, pending.Status)
+				continue
+			}
+			if err == nil {
+				// the certificate can only be picked up once, keep it
+				// in case the upload fails
+				err = store.Update(op)
+			}
+			if err != nil {
+				failed++
+				output.Errorf("pending operation %s for '%s' failed: %s\n", op.ID, op.Name, err)
+				continue
+			}
+		}
+
+		switch op.Target {
+		case targetCredhub:
+			output.Status("NOW UPLOADING TO %s '%s'\n", c.storeTitle(), op.Name)
+			err = c.store.PutCertificate(op.Name, op.CA, op.Certificate, op.PrivateKey)
+		case targetVenafi:
+			output.Status("NOW UPLOADING TO VENAFI '%s'\n", op.Name)
+			err = c.vcert.PutCertificate(op.Name, op.Certificate, op.PrivateKey)
+		default:
+			err = fmt.Errorf("unknown target %s", op.Target)
+		}
+		if err != nil {
+			failed++
+			output.Errorf("pending operation %s for '%s' failed: %s\n", op.ID, op.Name, err)
+			continue
+		}
+		err = store.Remove(op.ID)
+		if err != nil {
+			return err
+		}
+		output.Status("COMPLETED PENDING OPERATION %s '%s'\n", op.ID, op.Name)
+	}
+
+	if id != "" && !found {
+		return fmt.Errorf("no pending operation with id %s", id)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d pending operations could not be completed", failed)
+	}
+	if waiting > 0 {
+		return fmt.Errorf("%d certificates are still pending in Venafi", waiting)
+	}
+	return nil
+}
+
+// pickup retrieves the certificate of a pending Venafi request into op
+func (c *CV) pickup(op *PendingOperation, timeout time.Duration, keyPassword string) error {
+	e := &vcclient.Enrollment{
+		PickupID:    op.PickupID,
+		CsrOrigin:   vcclient.CsrOrigins[op.CsrOrigin],
+		KeyPassword: keyPassword,
+		PrivateKey:  op.PrivateKey,
+	}
+	if e.CsrOrigin == certificate.ServiceGeneratedCSR && keyPassword == "" {
+		return fmt.Errorf("the key generated by Venafi can only be exported with a password, run `cv pending pickup -id %s -key-password <password>`", op.ID)
+	}
+	output.Status("NOW PICKING UP '%s' FROM VENAFI\n", op.Name)
+	cert, err := c.vcert.Pickup(e, timeout)
 	if err != nil {
 		return err
 	}
+	op.Certificate = cert.Certificate
+	op.CA = pemChain(cert.Chain)
+	if e.CsrOrigin != certificate.UserProvidedCSR {
+		op.PrivateKey = cert.PrivateKey
+	}
+	op.PickupID = ""
+	return nil
+}
+
+// pemChain joins the PEM certificates of a chain, one per line
+func pemChain(chain []string) string {
+	out := ""
+	for _, c := range chain {
+		out += strings.TrimSpace(c) + "\n"
+	}
+	return out
+}
 
-	err = c.vcert.Logout()
+// listPending prints the pending operations
+func (c *CV) listPending() ([]PendingOperation, error) {
+	ops, err := c.pendingStore().Load()
 	if err != nil {
-		output.Errorf("error with cleanup. %s\n", err)
+		return nil, err
 	}
+	printPending(ops)
+	return ops, nil
+}
 
-	output.Status("NOW DELETING FROM CREDHUB '%s'\n", name)
-	return c.credhub.DeleteCert(name)
+func (c *CV) pendingStore() *PendingStore {
+	return &PendingStore{Path: filepath.Join(c.configLoader.UserHomeDir, c.configLoader.CVConfigDir, PendingFile)}
 }
 
-func (c *CV) listBoth(args *ListCommand) ([]CertCompareData, error) {
+func (c *CV) listBoth(args *ListCommand) ([]CompareData, error) {
 	output.Status("LISTING...\n")
 
-	certInfo, err := c.vcert.List(args.VenafiLimit, args.VenafiRoot)
+	f, err := args.filter()
 	if err != nil {
-		return []CertCompareData{}, err
+		return []CompareData{}, err
+	}
+	if types := keyTypes(args.Type); types != nil {
+		return c.listKeys(args, types, f)
 	}
 
-	items, err := c.credhub.List()
+	certInfo, err := c.vcert.List(args.VenafiLimit, args.VenafiRoot)
 	if err != nil {
-		return []CertCompareData{}, err
+		return []CompareData{}, err
 	}
 
-	certs := []credentials.CertificateMetadata{}
-	for _, cert := range items {
-		if strings.HasPrefix(cert.Name, args.CredhubRoot) {
-			certs = append(certs, cert)
-		}
+	items, err := c.store.List()
+	if err != nil {
+		return []CompareData{}, err
 	}
 
-	var ct ComparisonStrategy
-	switch {
-	case args.ByThumbprint:
-		ct = &ThumbprintStrategy{getCertificate: c.credhub.GetCertificate}
-	case args.ByPath:
-		ct = &PathStrategy{leftPrefix: joinRoot(args.VenafiRoot, args.VenafiPrefix, "\\"), rightPrefix: joinRoot(args.CredhubRoot, args.CredhubPrefix, "/")}
-	default:
-		ct = &CommonNameStrategy{}
-	}
+	certs := credhubCertsBelow(items, args.CredhubRoot)
+	ct := args.strategy(c.store.GetCertificate)
 	data := compareCerts(ct, certInfo, certs, "", "")
+	data, errs := c.filterCompareData(f, ct, data)
 	printCertsPretty(ct, data)
 	e, ok := ct.(processErrors)
 	if ok {
-		for _, each := range e.getErrors() {
-			output.Errorf("%s\n", each)
-		}
+		errs = append(e.getErrors(), errs...)
+	}
+	for _, each := range errs {
+		output.Errorf("%s\n", each)
 	}
 	if len(certInfo) == args.VenafiLimit {
 		output.Errorf("The Venafi limit was hit, consider increasing -vlimit to increase the number of allowed records.\n")
 	}
 
-	err = c.vcert.Logout()
+	return data, nil
+}
+
+// strategy returns the strategy selected by the -by* flags. getCertificate
+// reads the CredHub certificates when they are compared by thumbprint.
+func (args *ListCommand) strategy(getCertificate func(name string) (secretstore.Certificate, error)) ComparisonStrategy {
+	switch {
+	case args.ByThumbprint:
+		return &ThumbprintStrategy{getCertificate: getCertificate}
+	case args.ByPath:
+		return &PathStrategy{leftPrefix: joinRoot(args.VenafiRoot, args.VenafiPrefix, "\\"), rightPrefix: joinRoot(args.CredhubRoot, args.CredhubPrefix, "/")}
+	}
+	return &CommonNameStrategy{}
+}
+
+// listKeys compares the CredHub credentials of the given key types with the
+// Venafi SSH key inventory by the fingerprint of their public key
+func (c *CV) listKeys(args *ListCommand, types []string, f *listFilter) ([]CompareData, error) {
+	sshKeys, err := c.vcert.ListSSHKeys(args.VenafiLimit)
 	if err != nil {
-		output.Errorf("error with cleanup. %s\n", err)
+		return []CompareData{}, err
+	}
+
+	keys, err := c.store.ListKeys(args.CredhubRoot, types)
+	if err != nil {
+		return []CompareData{}, err
+	}
+
+	ct := &FingerprintStrategy{}
+	data := compareCredentials(ct, venafiSSHKeys(sshKeys), credhubKeys(keys))
+	data, errs := c.filterCompareData(f, ct, data)
+	printCertsPretty(ct, data)
+	for _, each := range errs {
+		output.Errorf("%s\n", each)
+	}
+	if len(sshKeys) == args.VenafiLimit {
+		output.Errorf("The Venafi limit was hit, consider increasing -vlimit to increase the number of allowed records.\n")
 	}
 
 	return data, nil
 }
 
+// thumbprintOf returns the SHA-1 thumbprint of a PEM certificate in the upper
+// case hex format used by TPP
+func thumbprintOf(cert string) (string, error) {
+	tp, err := chclient.GetThumbprint(cert)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(tp[:])), nil
+}
+
 func joinRoot(a, b, sep string) string {
 	a = strings.TrimSuffix(a, sep)
 	b = strings.TrimPrefix(b, sep)
@@ -211,7 +475,7 @@ func joinRoot(a, b, sep string) string {
 	return a + sep + b
 }
 
-func printCerts(data []CertCompareData) {
+func printCerts(data []CompareData) {
 	for i, d := range data {
 		output.Verbose("%d %+v\n", i, d)
 	}
@@ -219,19 +483,19 @@ func printCerts(data []CertCompareData) {
 
 // ComparisonStrategy defines the interface for comparing credentials
 type ComparisonStrategy interface {
-	leftGet(l certificate.CertificateInfo) string
-	rightGet(r credentials.CertificateMetadata) string
+	leftGet(l Credential) string
+	rightGet(r Credential) string
 	leftTransform(in string) string
 	rightTransform(in string) string
 }
 
-func buildCompareTransform(tct ComparisonStrategy) func(certificate.CertificateInfo, credentials.CertificateMetadata) int {
-	return func(l certificate.CertificateInfo, r credentials.CertificateMetadata) int {
+func buildCompareTransform(tct ComparisonStrategy) func(Credential, Credential) int {
+	return func(l Credential, r Credential) int {
 		return compareTransform(l, r, tct)
 	}
 }
 
-func compareTransform(l certificate.CertificateInfo, r credentials.CertificateMetadata, tct ComparisonStrategy) int {
+func compareTransform(l Credential, r Credential, tct ComparisonStrategy) int {
 	commonName := tct.leftGet(l)
 	credhubName := tct.rightGet(r)
 
@@ -244,11 +508,17 @@ func compareTransform(l certificate.CertificateInfo, r credentials.CertificateMe
 	return cmpVal
 }
 
-func compareCerts(ct ComparisonStrategy, certInfo []certificate.CertificateInfo, items []credentials.CertificateMetadata, leftPrefix, rightPrefix string) []CertCompareData {
-	cc := &DefaultCertCollector{}
+func compareCerts(ct ComparisonStrategy, certInfo []certificate.CertificateInfo, items []secretstore.CertificateMetadata, leftPrefix, rightPrefix string) []CompareData {
+	return compareCredentials(ct, venafiCertificates(certInfo), credhubCertificates(items))
+}
+
+// compareCredentials pairs the Venafi credentials on the left with the
+// CredHub credentials on the right that the strategy considers equal
+func compareCredentials(ct ComparisonStrategy, left []Credential, right []Credential) []CompareData {
+	cc := &DefaultCollector{}
 
 	cmpTransform := buildCompareTransform(ct)
-	compareLists(certInfo, items, cmpTransform, cc, ct)
+	compareLists(left, right, cmpTransform, cc, ct)
 
 	ps, ok := ct.(postSort)
 	if ok {
@@ -259,62 +529,63 @@ func compareCerts(ct ComparisonStrategy, certInfo []certificate.CertificateInfo,
 	return cc.data
 }
 
-// CertCompareData holds the necessary data for comparing certs
-type CertCompareData struct {
-	Left  *certificate.CertificateInfo
-	Right *credentials.CertificateMetadata
+// CompareData holds a Venafi credential, a CredHub credential or a pair of
+// matching credentials
+type CompareData struct {
+	Left  Credential
+	Right Credential
 }
 
-func (c CertCompareData) String() string {
+func (c CompareData) String() string {
 	out := ""
 	if c.Left != nil {
-		out += fmt.Sprintf(" Left:%+v ", *c.Left)
+		out += fmt.Sprintf(" Left:%+v ", c.Left)
 	} else {
 		out += " Left: nil "
 	}
 	if c.Right != nil {
-		out += fmt.Sprintf(" Right:%+v ", *c.Right)
+		out += fmt.Sprintf(" Right:%+v ", c.Right)
 	} else {
 		out += " Right: nil "
 	}
 	return out
 }
 
-// DefaultCertCollector is a simple collector of cert comparison data
-type DefaultCertCollector struct {
-	data []CertCompareData
+// DefaultCollector is a simple collector of comparison data
+type DefaultCollector struct {
+	data []CompareData
 }
 
-// CertificateInfo appends a cert from vcert to the collector
-func (m *DefaultCertCollector) CertificateInfo(item certificate.CertificateInfo) {
-	m.data = append(m.data, CertCompareData{Left: &item})
+// Left appends a credential from Venafi to the collector
+func (m *DefaultCollector) Left(item Credential) {
+	m.data = append(m.data, CompareData{Left: item})
 }
 
-// CertificateMetadata appends a cert from credhub to the collector
-func (m *DefaultCertCollector) CertificateMetadata(item credentials.CertificateMetadata) {
-	m.data = append(m.data, CertCompareData{Right: &item})
+// Right appends a credential from CredHub to the collector
+func (m *DefaultCollector) Right(item Credential) {
+	m.data = append(m.data, CompareData{Right: item})
 }
 
-// Equals compares a cert from vcert to one from credhub for identity
-func (m *DefaultCertCollector) Equals(ci certificate.CertificateInfo, cm credentials.CertificateMetadata) {
-	m.data = append(m.data, CertCompareData{Left: &ci, Right: &cm})
+// Equals appends a matching pair of credentials to the collector
+func (m *DefaultCollector) Equals(l Credential, r Credential) {
+	m.data = append(m.data, CompareData{Left: l, Right: r})
 }
 
-// CertCollector collects the comparison output
-type CertCollector interface {
-	// CertificateInfo handles a Venafi non-match
-	CertificateInfo(certificate.CertificateInfo)
-	// CertificateMetadata handles a Credhub non-match
-	CertificateMetadata(credentials.CertificateMetadata)
-	// Equals handles certificates that match
-	Equals(certificate.CertificateInfo, credentials.CertificateMetadata)
+// Collector collects the comparison output
+type Collector interface {
+	// Left handles a Venafi non-match
+	Left(Credential)
+	// Right handles a Credhub non-match
+	Right(Credential)
+	// Equals handles credentials that match
+	Equals(Credential, Credential)
 }
 
 func compareLists(
-	l []certificate.CertificateInfo,
-	r []credentials.CertificateMetadata,
-	comparison func(certificate.CertificateInfo, credentials.CertificateMetadata) int,
-	collector CertCollector,
+	l []Credential,
+	r []Credential,
+	comparison func(Credential, Credential) int,
+	collector Collector,
 	tct ComparisonStrategy) {
 	sort.SliceStable(l, func(i, j int) bool {
 		a := tct.leftGet(l[i])
@@ -346,10 +617,10 @@ func compareLists(
 }
 
 func compareSortedLists(
-	l []certificate.CertificateInfo,
-	r []credentials.CertificateMetadata,
-	comparison func(certificate.CertificateInfo, credentials.CertificateMetadata) int,
-	collector CertCollector) {
+	l []Credential,
+	r []Credential,
+	comparison func(Credential, Credential) int,
+	collector Collector) {
 	i := 0
 	j := 0
 	n1 := len(l)
@@ -358,25 +629,25 @@ func compareSortedLists(
 	for i < n1 && j < n2 {
 		cmp := comparison(l[i], r[j])
 		if cmp < 0 {
-			collector.CertificateInfo(l[i])
+			collector.Left(l[i])
 			i++
 		} else if cmp == 0 {
 			collector.Equals(l[i], r[j])
 			i++
 			j++
 		} else {
-			collector.CertificateMetadata(r[j])
+			collector.Right(r[j])
 			j++
 		}
 	}
 
 	for i < n1 {
-		collector.CertificateInfo(l[i])
+		collector.Left(l[i])
 		i++
 	}
 
 	for j < n2 {
-		collector.CertificateMetadata(r[j])
+		collector.Right(r[j])
 		j++
 	}
 }
@@ -387,12 +658,12 @@ type CommonNameStrategy struct {
 	rightPrefix string
 }
 
-func (t *CommonNameStrategy) leftGet(l certificate.CertificateInfo) string {
-	return l.CN
+func (t *CommonNameStrategy) leftGet(l Credential) string {
+	return l.Label()
 }
 
-func (t *CommonNameStrategy) rightGet(r credentials.CertificateMetadata) string {
-	return r.Name
+func (t *CommonNameStrategy) rightGet(r Credential) string {
+	return r.Label()
 }
 
 func (t *CommonNameStrategy) leftTransform(in string) string {
@@ -407,14 +678,14 @@ func (t *CommonNameStrategy) headers() []string {
 	return []string{"VENAFI", "CREDHUB"}
 }
 
-func (t *CommonNameStrategy) values(l *certificate.CertificateInfo, r *credentials.CertificateMetadata) []string {
+func (t *CommonNameStrategy) values(l Credential, r Credential) []string {
 	left := ""
 	right := ""
 	if l != nil {
-		left = t.leftGet(*l)
+		left = t.leftGet(l)
 	}
 	if r != nil {
-		right = t.rightGet(*r)
+		right = t.rightGet(r)
 	}
 	return []string{left, right}
 }
@@ -422,17 +693,17 @@ func (t *CommonNameStrategy) values(l *certificate.CertificateInfo, r *credentia
 // ThumbprintStrategy handles cert thumbprints
 type ThumbprintStrategy struct {
 	leftPrefix      string
-	getCertificate  func(name string) (credentials.Certificate, error)
+	getCertificate  func(name string) (secretstore.Certificate, error)
 	thumbprintCache map[string]string
 	errors          []error
 }
 
-func (t *ThumbprintStrategy) leftGet(l certificate.CertificateInfo) string {
-	return l.Thumbprint
+func (t *ThumbprintStrategy) leftGet(l Credential) string {
+	return fingerprintOf(l)
 }
 
-func (t *ThumbprintStrategy) rightGet(r credentials.CertificateMetadata) string {
-	in := r.Name
+func (t *ThumbprintStrategy) rightGet(r Credential) string {
+	in := r.ID()
 
 	// we check if this path is already in the thumbprint cache, and return it right away if it is
 	i, ok := t.cache()[in]
@@ -446,7 +717,7 @@ func (t *ThumbprintStrategy) rightGet(r credentials.CertificateMetadata) string
 	}
 
 	// then, from the cert we calculate the thumbprint
-	certStr := cert.Value.Certificate
+	certStr := cert.Certificate
 	tp, err := chclient.GetThumbprint(certStr)
 	if err != nil {
 		t.errors = append(t.errors, err)
@@ -482,19 +753,19 @@ func (t *ThumbprintStrategy) getErrors() []error {
 	return t.errors
 }
 
-func (t *ThumbprintStrategy) values(l *certificate.CertificateInfo, r *credentials.CertificateMetadata) []string {
+func (t *ThumbprintStrategy) values(l Credential, r Credential) []string {
 	thumbprint := ""
 	left := ""
 	right := ""
 
 	if l != nil {
-		left = l.CN
-		thumbprint = l.Thumbprint
+		left = l.Label()
+		thumbprint = fingerprintOf(l)
 	}
 	if r != nil {
-		right = r.Name
+		right = r.ID()
 
-		i, ok := t.cache()[r.Name]
+		i, ok := t.cache()[r.ID()]
 		if ok {
 			thumbprint = i
 		}
@@ -502,7 +773,7 @@ func (t *ThumbprintStrategy) values(l *certificate.CertificateInfo, r *credentia
 	return []string{left, right, strings.ToLower(thumbprint)}
 }
 
-func (t *ThumbprintStrategy) postSort(l []CertCompareData) {
+func (t *ThumbprintStrategy) postSort(l []CompareData) {
 	cmp := func(i, j int) bool {
 		a := l[i]
 		b := l[j]
@@ -511,8 +782,8 @@ func (t *ThumbprintStrategy) postSort(l []CertCompareData) {
 		} else if a.Left == nil && b.Left != nil {
 			return true
 		} else if a.Left != nil && b.Left != nil {
-			aID := a.Left.ID
-			bID := b.Left.ID
+			aID := a.Left.ID()
+			bID := b.Left.ID()
 			if aID < bID {
 				return true
 			}
@@ -524,8 +795,8 @@ func (t *ThumbprintStrategy) postSort(l []CertCompareData) {
 			return true
 		}
 		if a.Right != nil && b.Right != nil {
-			aID := a.Right.Name
-			bID := b.Right.Name
+			aID := a.Right.ID()
+			bID := b.Right.ID()
 			return aID < bID
 		}
 		return !(a.Right == nil && b.Right == nil)
@@ -542,12 +813,12 @@ type PathStrategy struct {
 	rightPrefix string
 }
 
-func (t *PathStrategy) leftGet(l certificate.CertificateInfo) string {
-	return l.ID
+func (t *PathStrategy) leftGet(l Credential) string {
+	return l.ID()
 }
 
-func (t *PathStrategy) rightGet(r credentials.CertificateMetadata) string {
-	return r.Name
+func (t *PathStrategy) rightGet(r Credential) string {
+	return r.ID()
 }
 
 func (t *PathStrategy) leftTransform(in string) string {
@@ -564,32 +835,71 @@ func (t *PathStrategy) normalize(in string, prefix string) string {
 	return strings.TrimPrefix(strings.TrimPrefix(in, prefix), "/")
 }
 
-func (t *PathStrategy) leftDisplay(l certificate.CertificateInfo) string {
-	return l.ID
+func (t *PathStrategy) leftDisplay(l Credential) string {
+	return l.ID()
 }
 
-func (t *PathStrategy) rightDisplay(r credentials.CertificateMetadata) string {
-	return r.Name
+func (t *PathStrategy) rightDisplay(r Credential) string {
+	return r.ID()
 }
 
 func (t *PathStrategy) headers() []string {
 	return []string{"VENAFI", "CREDHUB"}
 }
 
-func (t *PathStrategy) values(l *certificate.CertificateInfo, r *credentials.CertificateMetadata) []string {
+func (t *PathStrategy) values(l Credential, r Credential) []string {
 	left := ""
 	right := ""
 	if l != nil {
-		left = t.leftDisplay(*l)
+		left = t.leftDisplay(l)
 	}
 	if r != nil {
-		right = t.rightDisplay(*r)
+		right = t.rightDisplay(r)
 	}
 	return []string{left, right}
 }
 
+// FingerprintStrategy matches keys by the SHA256 fingerprint of their public
+// key
+type FingerprintStrategy struct{}
+
+func (t *FingerprintStrategy) leftGet(l Credential) string {
+	return fingerprintOf(l)
+}
+
+func (t *FingerprintStrategy) rightGet(r Credential) string {
+	return fingerprintOf(r)
+}
+
+func (t *FingerprintStrategy) leftTransform(in string) string {
+	return in
+}
+
+func (t *FingerprintStrategy) rightTransform(in string) string {
+	return in
+}
+
+func (t *FingerprintStrategy) headers() []string {
+	return []string{"VENAFI", "CREDHUB", "FINGERPRINT"}
+}
+
+func (t *FingerprintStrategy) values(l Credential, r Credential) []string {
+	fingerprint := ""
+	left := ""
+	right := ""
+	if l != nil {
+		left = l.Label()
+		fingerprint = fingerprintOf(l)
+	}
+	if r != nil {
+		right = r.ID()
+		fingerprint = fingerprintOf(r)
+	}
+	return []string{left, right, fingerprint}
+}
+
 type postSort interface {
-	postSort(l []CertCompareData)
+	postSort(l []CompareData)
 }
 
 type processErrors interface {
@@ -622,10 +932,10 @@ func max(x, y int) int {
 
 type prettyPrinter interface {
 	headers() []string
-	values(l *certificate.CertificateInfo, r *credentials.CertificateMetadata) []string
+	values(l Credential, r Credential) []string
 }
 
-func printCertsPretty(ct ComparisonStrategy, data []CertCompareData) {
+func printCertsPretty(ct ComparisonStrategy, data []CompareData) {
 	pp, ok := ct.(prettyPrinter)
 	if !ok {
 		return
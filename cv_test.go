@@ -0,0 +1,848 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/credentials/generate"
+	"github.com/newcontext-oss/credhub-venafi/chclient"
+	"github.com/newcontext-oss/credhub-venafi/chclient/chfake"
+	"github.com/newcontext-oss/credhub-venafi/config"
+	"github.com/newcontext-oss/credhub-venafi/k8sclient"
+	"github.com/newcontext-oss/credhub-venafi/k8sclient/k8sfake"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
+	"github.com/newcontext-oss/credhub-venafi/vaultclient"
+	"github.com/newcontext-oss/credhub-venafi/vaultclient/vaultfake"
+	"github.com/newcontext-oss/credhub-venafi/vcclient"
+	"github.com/newcontext-oss/credhub-venafi/vcclient/vcfake"
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeZone = "Certificates\\CV"
+
+type fakeBackends struct {
+	credhub *chfake.Server
+	tpp     *vcfake.Server
+	cv      *CV
+}
+
+// newFakeCV returns a CV connected to fresh fake CredHub and TPP servers
+func newFakeCV(t *testing.T) *fakeBackends {
+	home := testHome(t, "")
+
+	ch := chfake.NewServer()
+	t.Cleanup(ch.Close)
+	ch.AddClient("cv_client", "secret")
+	cp := &chclient.CredhubProxy{BaseURL: ch.URL, ClientID: "cv_client", ClientSecret: "secret", ConfigPath: ".cv"}
+	if err := cp.Auth(); err != nil {
+		t.Fatal(err)
+	}
+
+	tpp := vcfake.NewServer()
+	t.Cleanup(tpp.Close)
+	tpp.AddUser("tppadmin", "password")
+	tpp.AddZone(fakeZone, vcfake.OpenPolicy())
+	vp := &vcclient.VcertProxy{
+		Username:      "tppadmin",
+		Password:      "password",
+		Zone:          fakeZone,
+		BaseURL:       tpp.URL,
+		ConnectorType: "tpp",
+		TrustBundle:   tpp.TrustBundle(),
+
+		PickupInterval: 10 * time.Millisecond,
+	}
+	if err := vp.Login(); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := chclient.ConfigLoader{UserHomeDir: home, CVConfigDir: ".cv", ConfigFilename: "config.json"}
+	return &fakeBackends{credhub: ch, tpp: tpp, cv: &CV{store: cp, vcert: vp, configLoader: loader}}
+}
+
+func TestCloseRevokesSession(t *testing.T) {
+	f := newFakeCV(t)
+	token := f.cv.vcert.(*vcclient.VcertProxy).AccessToken
+
+	err := f.cv.generateAndStore("/kept", &GenerateAndStoreCommand{Name: "kept", CommonName: "kept.example.com"}, true)
+	assert.Nil(t, err)
+	assert.True(t, f.tpp.TokenValid(token), "It should keep the session for the whole command")
+
+	f.cv.watchSignals()
+	f.cv.close()
+	assert.False(t, f.tpp.TokenValid(token), "It should revoke the session on close")
+	f.cv.close()
+	assert.Equal(t, 1, f.tpp.Count(http.MethodGet, "/vedauth/revoke/token"), "It should revoke the token once")
+}
+
+func TestGenerateOnVenafiAndStore(t *testing.T) {
+	f := newFakeCV(t)
+
+	err := f.cv.generateAndStore("/venafi-cert", &GenerateAndStoreCommand{Name: "venafi-cert", CommonName: "venafi.example.com"}, true)
+	assert.Nil(t, err, "It should generate on Venafi and store in CredHub")
+
+	objects := f.tpp.Objects()
+	assert.Len(t, objects, 1)
+	versions := f.credhub.Versions("/venafi-cert")
+	assert.Len(t, versions, 1)
+	value := versions[0].Value.(map[string]interface{})
+	assert.Equal(t, objects[0].Certificate, value["certificate"], "It should store the Venafi certificate in CredHub")
+	assert.Equal(t, strings.TrimSpace(f.tpp.CA()), strings.TrimSpace(value["ca"].(string)), "It should store the issuing chain as CA")
+}
+
+func TestGenerateOnVenafiServiceKey(t *testing.T) {
+	f := newFakeCV(t)
+
+	v := &GenerateAndStoreCommand{Name: "service-cert", CommonName: "service.example.com", KeyLength: 2048, CsrOrigin: "service", KeyPassword: "secret"}
+	err := f.cv.generateAndStore("/service-cert", v, true)
+	assert.Nil(t, err, "It should generate the key on Venafi and store it in CredHub")
+
+	versions := f.credhub.Versions("/service-cert")
+	assert.Len(t, versions, 1)
+	value := versions[0].Value.(map[string]interface{})
+	_, err = tls.X509KeyPair([]byte(value["certificate"].(string)), []byte(value["private_key"].(string)))
+	assert.Nil(t, err, "It should store the decrypted Venafi key in CredHub")
+}
+
+// writeCSR writes a CSR and its private key to dir and returns their paths
+func writeCSR(t *testing.T, dir string, cn string) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrFile := filepath.Join(dir, cn+".csr")
+	keyFile := filepath.Join(dir, cn+".key")
+	err = ioutil.WriteFile(csrFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), 0600)
+	if err == nil {
+		err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csrFile, keyFile
+}
+
+func TestGenerateFromCSR(t *testing.T) {
+	f := newFakeCV(t)
+	dir := f.cv.configLoader.UserHomeDir
+	csrFile, keyFile := writeCSR(t, dir, "byo.example.com")
+	_, otherKey := writeCSR(t, dir, "other.example.com")
+
+	v := &GenerateAndStoreCommand{Name: "byo", CSRFile: csrFile, KeyFile: otherKey}
+	err := f.cv.generateAndStore("/byo", v, true)
+	assert.NotNil(t, err, "It should refuse a key that does not belong to the CSR")
+	assert.Empty(t, f.tpp.Objects())
+
+	v = &GenerateAndStoreCommand{Name: "byo", CSRFile: csrFile}
+	err = f.cv.generateAndStore("/byo", v, true)
+	assert.Nil(t, err, "It should submit the CSR without a key")
+	value := f.credhub.Versions("/byo")[0].Value.(map[string]interface{})
+	assert.Empty(t, value["private_key"])
+
+	v = &GenerateAndStoreCommand{Name: "byo", CSRFile: csrFile, KeyFile: keyFile}
+	err = f.cv.generateAndStore("/byo", v, true)
+	assert.Nil(t, err, "It should submit the CSR and store the key")
+	value = f.credhub.Versions("/byo")[0].Value.(map[string]interface{})
+	_, err = tls.X509KeyPair([]byte(value["certificate"].(string)), []byte(value["private_key"].(string)))
+	assert.Nil(t, err, "It should store the supplied key with the issued certificate")
+}
+
+func TestGenerateOnVenafiAndStoreFails(t *testing.T) {
+	f := newFakeCV(t)
+	f.credhub.Fail(http.MethodPut, "/api/v1/data", http.StatusInternalServerError, "storage unavailable")
+
+	err := f.cv.generateAndStore("/venafi-cert", &GenerateAndStoreCommand{Name: "venafi-cert", CommonName: "venafi.example.com"}, true)
+	assert.NotNil(t, err, "It should report a failure to store in CredHub")
+	assert.Contains(t, err.Error(), "rolled back")
+	assert.Empty(t, f.credhub.Versions("/venafi-cert"))
+	objects := f.tpp.Objects()
+	assert.Len(t, objects, 1)
+	assert.True(t, objects[0].Revoked && objects[0].Disabled, "It should disable the certificate in Venafi")
+	assert.Equal(t, "rolled back by cv: upload to CredHub failed", objects[0].Comments)
+}
+
+func TestGenerateOnVenafiAndVaultFails(t *testing.T) {
+	f := newFakeCV(t)
+	vault := vaultfake.NewServer("vault-token")
+	t.Cleanup(vault.Close)
+	vault.Fail(http.MethodPost, "/v1/secret/data/venafi/venafi-cert", http.StatusForbidden, "permission denied")
+	f.cv.store = &vaultclient.VaultProxy{BaseURL: vault.URL, Token: "vault-token", KVPath: vaultfake.KVMount + "/venafi"}
+
+	err := f.cv.generateAndStore("/venafi-cert", &GenerateAndStoreCommand{Name: "venafi-cert", CommonName: "venafi.example.com"}, true)
+	assert.NotNil(t, err, "It should report a failure to store in Vault")
+	objects := f.tpp.Objects()
+	assert.Len(t, objects, 1)
+	assert.Equal(t, "rolled back by cv: upload to Vault failed", objects[0].Comments, "It should name Vault as the store")
+}
+
+func TestGenerateOnVenafiAndResume(t *testing.T) {
+	f := newFakeCV(t)
+	f.credhub.Fail(http.MethodPut, "/api/v1/data", http.StatusInternalServerError, "storage unavailable")
+
+	v := &GenerateAndStoreCommand{Name: "venafi-cert", CommonName: "venafi.example.com", OnFailure: OnFailureResume}
+	err := f.cv.generateAndStore("/venafi-cert", v, true)
+	assert.NotNil(t, err, "It should report a failure to store in CredHub")
+	assert.Contains(t, err.Error(), "cv resume -id 1")
+	assert.False(t, f.tpp.Objects()[0].Revoked, "It should leave the certificate active in Venafi")
+
+	ops, err := f.cv.pendingStore().Load()
+	assert.Nil(t, err)
+	assert.Len(t, ops, 1, "It should record the pending upload")
+	assert.Equal(t, targetCredhub, ops[0].Target)
+
+	assert.NotNil(t, f.cv.resume("2"), "It should raise an error for an unknown operation")
+	assert.Nil(t, f.cv.resume(""), "It should complete the pending upload")
+	versions := f.credhub.Versions("/venafi-cert")
+	assert.Len(t, versions, 1)
+	assert.Equal(t, f.tpp.Objects()[0].Certificate, versions[0].Value.(map[string]interface{})["certificate"])
+	ops, _ = f.cv.pendingStore().Load()
+	assert.Empty(t, ops, "It should remove completed operations")
+}
+
+func TestGenerateAsyncAndPickup(t *testing.T) {
+	f := newFakeCV(t)
+	f.tpp.SetPending(2)
+
+	v := &GenerateAndStoreCommand{Name: "async-cert", CommonName: "async.example.com", Async: true}
+	err := f.cv.generateAndStore("/async-cert", v, true)
+	assert.Nil(t, err, "It should submit the request without waiting")
+	assert.Empty(t, f.credhub.Versions("/async-cert"))
+
+	ops, err := f.cv.pendingStore().Load()
+	assert.Nil(t, err)
+	assert.Len(t, ops, 1, "It should record the pickup ID")
+	assert.Equal(t, f.tpp.Objects()[0].DN, ops[0].PickupID)
+
+	err = f.cv.pickupPending("", 0, "")
+	assert.NotNil(t, err, "It should report a certificate that is still pending")
+	ops, _ = f.cv.pendingStore().Load()
+	assert.Len(t, ops, 1, "It should keep the pending request")
+
+	assert.Nil(t, f.cv.pickupPending("1", time.Second, ""), "It should pick up the certificate once it is issued")
+	versions := f.credhub.Versions("/async-cert")
+	assert.Len(t, versions, 1)
+	value := versions[0].Value.(map[string]interface{})
+	assert.Equal(t, f.tpp.Objects()[0].Certificate, value["certificate"])
+	_, err = tls.X509KeyPair([]byte(value["certificate"].(string)), []byte(value["private_key"].(string)))
+	assert.Nil(t, err, "It should store the locally generated key")
+	ops, _ = f.cv.pendingStore().Load()
+	assert.Empty(t, ops, "It should remove completed operations")
+}
+
+func TestGenerateFromCSRAsyncAndPickup(t *testing.T) {
+	f := newFakeCV(t)
+	csrFile, keyFile := writeCSR(t, f.cv.configLoader.UserHomeDir, "byo.example.com")
+
+	v := &GenerateAndStoreCommand{Name: "byo", CSRFile: csrFile, KeyFile: keyFile, CsrOrigin: "local", Async: true}
+	assert.Nil(t, f.cv.generateAndStore("/byo", v, true), "It should submit the CSR without waiting")
+	ops, err := f.cv.pendingStore().Load()
+	assert.Nil(t, err)
+	assert.Len(t, ops, 1)
+	assert.Equal(t, "user", ops[0].CsrOrigin, "It should record the origin of a CSR file, not the flag")
+
+	assert.Nil(t, f.cv.pickupPending("1", time.Second, ""), "It should pick up the certificate")
+	value := f.credhub.Versions("/byo")[0].Value.(map[string]interface{})
+	_, err = tls.X509KeyPair([]byte(value["certificate"].(string)), []byte(value["private_key"].(string)))
+	assert.Nil(t, err, "It should store the supplied key with the picked up certificate")
+}
+
+func TestGenerateServiceKeyAsyncAndPickup(t *testing.T) {
+	f := newFakeCV(t)
+	store := f.cv.pendingStore()
+
+	v := &GenerateAndStoreCommand{Name: "service-cert", CommonName: "service.example.com", KeyLength: 2048, CsrOrigin: "service", KeyPassword: "Passw0rd!", Async: true}
+	assert.Nil(t, f.cv.generateAndStore("/service-cert", v, true))
+	b, err := ioutil.ReadFile(store.Path)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), "Passw0rd!", "It should not record the key password")
+
+	err = f.cv.pickupPending("1", time.Second, "")
+	assert.NotNil(t, err, "It should require the key password to pick up a key generated by Venafi")
+	ops, _ := store.Load()
+	assert.Len(t, ops, 1, "It should keep the request")
+
+	assert.Nil(t, f.cv.pickupPending("1", time.Second, "An0ther!"), "It should pick up with the password given again")
+	value := f.credhub.Versions("/service-cert")[0].Value.(map[string]interface{})
+	_, err = tls.X509KeyPair([]byte(value["certificate"].(string)), []byte(value["private_key"].(string)))
+	assert.Nil(t, err, "It should store the decrypted Venafi key")
+	_, err = os.Stat(store.Path)
+	assert.True(t, os.IsNotExist(err), "It should remove the file with the key once nothing is pending")
+}
+
+func TestGeneratePendingTimeout(t *testing.T) {
+	f := newFakeCV(t)
+	f.tpp.SetPending(100)
+
+	v := &GenerateAndStoreCommand{Name: "approval-cert", CommonName: "approval.example.com", Timeout: 50 * time.Millisecond}
+	err := f.cv.generateAndStore("/approval-cert", v, true)
+	assert.NotNil(t, err, "It should report a certificate that was not issued in time")
+	assert.Contains(t, err.Error(), "cv pending pickup -id 1")
+
+	ops, err := f.cv.pendingStore().Load()
+	assert.Nil(t, err)
+	assert.Len(t, ops, 1, "It should keep the request for a later pickup")
+}
+
+func TestGenerateOnCredhubAndStoreFails(t *testing.T) {
+	f := newFakeCV(t)
+
+	v := &GenerateAndStoreCommand{Name: "credhub-cert", CommonName: "credhub.example.com", SelfSign: true, KeyLength: 2048, Duration: 30}
+	f.tpp.Fail(http.MethodPost, "/vedsdk/certificates/import", http.StatusInternalServerError, `{"Error":"boom"}`)
+	err := f.cv.generateAndStoreCredhub("/credhub-cert", v, true)
+	assert.NotNil(t, err, "It should report a failure to import into Venafi")
+	assert.Empty(t, f.credhub.Names(), "It should delete the new CredHub credential")
+
+	existing := f.credhub.PutCertificate("/existing", "", f.tpp.CA(), "")
+	f.tpp.Fail(http.MethodPost, "/vedsdk/certificates/import", http.StatusInternalServerError, `{"Error":"boom"}`)
+	err = f.cv.generateAndStoreCredhub("/existing", v, true)
+	assert.NotNil(t, err)
+	versions := f.credhub.Versions("/existing")
+	assert.Len(t, versions, 1, "It should leave an existing CredHub credential in place")
+	assert.Equal(t, existing.Id, versions[0].Id)
+}
+
+func TestGenerateOnCredhubAndStore(t *testing.T) {
+	f := newFakeCV(t)
+
+	v := &GenerateAndStoreCommand{Name: "credhub-cert", CommonName: "credhub.example.com", SelfSign: true, KeyLength: 2048, Duration: 30}
+	err := f.cv.generateAndStoreCredhub("credhub-cert", v, true)
+	assert.Nil(t, err, "It should generate on CredHub and import into Venafi")
+
+	o, ok := f.tpp.Object("\\VED\\Policy\\" + fakeZone + "\\credhub-cert")
+	assert.True(t, ok, "It should import the certificate into Venafi")
+	value := f.credhub.Versions("/credhub-cert")[0].Value.(map[string]interface{})
+	assert.Equal(t, strings.TrimSpace(value["certificate"].(string)), strings.TrimSpace(o.Certificate))
+}
+
+func TestListBothByThumbprint(t *testing.T) {
+	f := newFakeCV(t)
+	err := f.cv.generateAndStore("/matched", &GenerateAndStoreCommand{Name: "matched", CommonName: "matched.example.com"}, false)
+	assert.Nil(t, err)
+	o := f.tpp.Objects()[0]
+	f.credhub.PutCertificate("/matched", "", o.Certificate, "")
+	f.credhub.PutCertificate("/credhub-only", "", f.tpp.CA(), "")
+
+	data, err := f.cv.listBoth(&ListCommand{ByThumbprint: true, VenafiLimit: 100, VenafiRoot: vcclient.PrependPolicyRoot(fakeZone)})
+	assert.Nil(t, err, "It should list both sides")
+	assert.Len(t, data, 2)
+	matched := 0
+	for _, d := range data {
+		if d.Left != nil && d.Right != nil {
+			matched++
+			assert.Equal(t, "/matched", d.Right.ID())
+		}
+	}
+	assert.Equal(t, 1, matched, "It should match the certificate present on both sides")
+}
+
+func TestListBothKeys(t *testing.T) {
+	f := newFakeCV(t)
+	cp := f.cv.store.(*chclient.CredhubProxy)
+	sshKey, err := cp.Client.GenerateSSH("/keys/deploy", generate.SSH{KeyLength: 2048}, credhub.Overwrite)
+	assert.Nil(t, err)
+	rsaKey, err := cp.Client.GenerateRSA("/keys/signing", generate.RSA{KeyLength: 2048}, credhub.Overwrite)
+	assert.Nil(t, err)
+	rsaFingerprint, err := chclient.KeyFingerprint(rsaKey.Value.PublicKey)
+	assert.Nil(t, err)
+	// TPP reports the fingerprint without the SHA256: prefix
+	f.tpp.AddSSHKey(vcfake.SSHKey{FingerprintSHA256: strings.TrimPrefix(sshKey.Value.PublicKeyFingerprint, "SHA256:"), Location: "host.example.com", Username: "deploy"})
+	f.tpp.AddSSHKey(vcfake.SSHKey{FingerprintSHA256: rsaFingerprint, Location: "host.example.com", Username: "signer"})
+	f.tpp.AddSSHKey(vcfake.SSHKey{FingerprintSHA256: "SHA256:unknown", Location: "other.example.com"})
+
+	data, err := f.cv.listBoth(&ListCommand{Type: typeKeys, VenafiLimit: 100})
+	assert.Nil(t, err, "It should list keys on both sides")
+	assert.Len(t, data, 3)
+	matched := map[string]string{}
+	for _, d := range data {
+		if d.Left != nil && d.Right != nil {
+			matched[d.Right.ID()] = d.Left.Label()
+		}
+	}
+	assert.Equal(t, map[string]string{"/keys/deploy": "deploy@host.example.com", "/keys/signing": "signer@host.example.com"}, matched, "It should match rsa and ssh keys by fingerprint")
+
+	data, err = f.cv.listBoth(&ListCommand{Type: typeRSA, VenafiLimit: 100})
+	assert.Nil(t, err)
+	assert.Len(t, data, 3, "It should only compare the rsa credentials")
+}
+
+func TestDeleteCert(t *testing.T) {
+	f := newFakeCV(t)
+	err := f.cv.generateAndStore("/doomed", &GenerateAndStoreCommand{Name: "doomed", CommonName: "doomed.example.com"}, false)
+	assert.Nil(t, err)
+	o := f.tpp.Objects()[0]
+	f.credhub.PutCertificate("/doomed", "", o.Certificate, "")
+
+	assert.Nil(t, f.cv.deleteCert(&DeleteCommand{Name: "/doomed", Yes: true}), "It should delete from both sides")
+	_, ok := f.tpp.Object(o.DN)
+	assert.False(t, ok, "It should delete the Venafi certificate object")
+	assert.Empty(t, f.credhub.Names(), "It should delete the CredHub credential")
+}
+
+func TestDeleteCertDisable(t *testing.T) {
+	f := newFakeCV(t)
+	err := f.cv.generateAndStore("/retired", &GenerateAndStoreCommand{Name: "retired", CommonName: "retired.example.com"}, false)
+	assert.Nil(t, err)
+	o := f.tpp.Objects()[0]
+	f.credhub.PutCertificate("/retired", "", o.Certificate, "")
+
+	args := &DeleteCommand{Name: "/retired", Reason: "superseded", Comment: "rotated", Disable: true, Only: targetVenafi, Yes: true}
+	assert.Nil(t, f.cv.deleteCert(args), "It should revoke and disable in Venafi")
+	o, ok := f.tpp.Object(o.DN)
+	assert.True(t, ok, "It should keep a disabled object")
+	assert.True(t, o.Revoked && o.Disabled)
+	assert.Equal(t, 4, o.RevocationReason)
+	assert.Equal(t, "rotated", o.Comments)
+	assert.Len(t, f.credhub.Names(), 1, "It should not touch CredHub")
+
+	assert.Nil(t, f.cv.deleteCert(&DeleteCommand{Name: "/retired", Only: targetCredhub, Yes: true}), "It should delete only from CredHub")
+	assert.Empty(t, f.credhub.Names())
+	assert.Len(t, f.tpp.Objects(), 1)
+}
+
+func TestDeleteCertConfirmation(t *testing.T) {
+	f := newFakeCV(t)
+	f.credhub.PutCertificate("/kept", "", f.tpp.CA(), "")
+	defer func(r io.Reader) { stdin = r }(stdin)
+
+	stdin = strings.NewReader("n\n")
+	assert.NotNil(t, f.cv.deleteCert(&DeleteCommand{Name: "/kept"}), "It should cancel without confirmation")
+	assert.Len(t, f.credhub.Names(), 1)
+
+	stdin = strings.NewReader("y\n")
+	assert.Nil(t, f.cv.deleteCert(&DeleteCommand{Name: "/kept"}), "It should delete when confirmed")
+	assert.Empty(t, f.credhub.Names())
+}
+
+func TestDeleteByThumbprint(t *testing.T) {
+	f := newFakeCV(t)
+	err := f.cv.generateAndStore("/venafi-only", &GenerateAndStoreCommand{Name: "venafi-only", CommonName: "venafi-only.example.com"}, false)
+	assert.Nil(t, err)
+	o := f.tpp.Objects()[0]
+
+	args := &DeleteCommand{Thumbprint: strings.ToLower(o.Thumbprint), DryRun: true}
+	assert.Nil(t, f.cv.deleteCert(args), "It should preview a certificate only in Venafi")
+	assert.Len(t, f.tpp.Objects(), 1, "It should not delete in a dry run")
+
+	args = &DeleteCommand{Thumbprint: o.Thumbprint, Yes: true}
+	assert.Nil(t, f.cv.deleteCert(args), "It should delete a certificate only in Venafi")
+	assert.Empty(t, f.tpp.Objects())
+
+	assert.NotNil(t, f.cv.deleteCert(args), "It should raise an error when nothing matches")
+}
+
+func TestDeleteByVenafiDN(t *testing.T) {
+	f := newFakeCV(t)
+	pcc, err := f.cv.vcert.Generate(&vcclient.CertArgs{Name: "original", CommonName: "shared.example.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, f.cv.vcert.PutCertificate("copy", pcc.Certificate, pcc.PrivateKey))
+	f.credhub.PutCertificate("/shared", "", pcc.Certificate, "")
+
+	dn := "\\VED\\Policy\\" + fakeZone + "\\copy"
+	f.credhub.Fail(http.MethodGet, "/api/v1/certificates/", http.StatusForbidden, "no access")
+	assert.Nil(t, f.cv.deleteCert(&DeleteCommand{VenafiDN: dn, Only: targetVenafi, Yes: true}), "It should delete a Venafi object by DN without searching CredHub")
+	_, ok := f.tpp.Object(dn)
+	assert.False(t, ok)
+	assert.Len(t, f.tpp.Objects(), 1, "It should keep other objects with the same certificate")
+	assert.Len(t, f.credhub.Names(), 1)
+
+	assert.NotNil(t, f.cv.deleteCert(&DeleteCommand{VenafiDN: dn, Yes: true}), "It should raise an error for a missing DN")
+}
+
+func TestDeleteByMatch(t *testing.T) {
+	f := newFakeCV(t)
+	for _, name := range []string{"one", "two"} {
+		err := f.cv.generateAndStore("/dev/"+name, &GenerateAndStoreCommand{Name: name, CommonName: name + ".example.com"}, true)
+		assert.Nil(t, err)
+	}
+	f.credhub.PutCertificate("/dev/credhub-only", "", f.tpp.CA(), "")
+	f.credhub.PutCertificate("/dev/broken", "", "not a certificate", "")
+	f.credhub.PutCertificate("/prod/one", "", f.credhub.Versions("/dev/one")[0].Value.(map[string]interface{})["certificate"].(string), "")
+
+	assert.Nil(t, f.cv.deleteCert(&DeleteCommand{Match: "/dev/*", Yes: true}), "It should delete every matching certificate")
+	assert.Equal(t, []string{"/dev/broken", "/prod/one"}, f.credhub.Names(), "It should skip a certificate whose thumbprint can't be read")
+	assert.Empty(t, f.tpp.Objects(), "It should delete the Venafi copies")
+}
+
+func TestDeleteReportsFailures(t *testing.T) {
+	f := newFakeCV(t)
+	f.credhub.PutCertificate("/a", "", f.tpp.CA(), "")
+	f.credhub.PutCertificate("/b", "", f.tpp.CA(), "")
+	f.credhub.Fail(http.MethodDelete, "/api/v1/data", http.StatusInternalServerError, "boom")
+
+	err := f.cv.deleteCert(&DeleteCommand{Match: "/*", Yes: true})
+	assert.NotNil(t, err, "It should report failed deletions")
+	assert.Contains(t, err.Error(), "1 of 2 deletions failed")
+	assert.Len(t, f.credhub.Names(), 1, "It should continue after a failure")
+}
+
+func TestHistoryAndRollback(t *testing.T) {
+	f := newFakeCV(t)
+	v := &GenerateAndStoreCommand{Name: "rotated", CommonName: "rotated.example.com"}
+	assert.Nil(t, f.cv.generateAndStore("/rotated", v, true))
+	assert.Nil(t, f.cv.generateAndStore("/rotated", v, true))
+	dn := "\\VED\\Policy\\" + fakeZone + "\\rotated"
+	versions := f.credhub.Versions("/rotated")
+	assert.Len(t, versions, 2)
+	f.credhub.SetTransitional(versions[1].Id, true)
+
+	entries, err := f.cv.history("/rotated")
+	assert.Nil(t, err, "It should list the versions")
+	assert.Len(t, entries, 2)
+	assert.True(t, entries[0].current)
+	assert.Equal(t, []string{dn}, entries[0].venafiDNs, "It should find the current version in Venafi")
+	assert.Empty(t, entries[1].venafiDNs, "It should report the replaced version missing in Venafi")
+	assert.True(t, entries[1].transitional)
+	assert.NotEmpty(t, entries[1].expiry)
+
+	assert.NotNil(t, f.cv.rollback("/rotated", "unknown"), "It should raise an error for an unknown version")
+	assert.Nil(t, f.cv.rollback("/rotated", entries[1].id), "It should roll back to an earlier version")
+	versions = f.credhub.Versions("/rotated")
+	assert.Len(t, versions, 3, "It should set the earlier version as a new current version")
+	current := versions[0].Value.(map[string]interface{})["certificate"]
+	assert.Equal(t, versions[2].Value.(map[string]interface{})["certificate"], current)
+	o, _ := f.tpp.Object(dn)
+	assert.Equal(t, entries[1].thumbprint, o.Thumbprint, "It should restore the certificate in Venafi")
+	assert.Len(t, f.tpp.Objects(), 1)
+}
+
+func TestShowPolicy(t *testing.T) {
+	f := newFakeCV(t)
+	policy := vcfake.OpenPolicy()
+	policy.WhitelistedDomains = []string{"example.com"}
+	f.tpp.AddZone("Certificates\\Other", policy)
+
+	z, err := f.cv.showPolicy("Certificates\\Other")
+	assert.Nil(t, err, "It should show the policy of a zone")
+	assert.Len(t, z.SubjectCNRegexes, 1)
+
+	_, err = f.cv.showPolicy("Certificates\\Missing")
+	assert.NotNil(t, err, "It should raise an error for a missing zone")
+}
+
+func TestShow(t *testing.T) {
+	f := newFakeCV(t)
+	v := &GenerateAndStoreCommand{Name: "shown", CommonName: "shown.example.com", SANDNS: []string{"www.shown.example.com"}}
+	assert.Nil(t, f.cv.generateAndStore("/shown", v, true))
+	dn := "\\VED\\Policy\\" + fakeZone + "\\shown"
+
+	result, err := f.cv.show(&ShowCommand{Name: "/shown", Zone: fakeZone})
+	assert.Nil(t, err, "It should show a CredHub certificate")
+	assert.Equal(t, "shown.example.com", result.record.cert.Subject.CommonName)
+	assert.Contains(t, sans(result.record.cert), "DNS:www.shown.example.com")
+	assert.Len(t, result.counterparts, 1, "It should find the Venafi copy")
+	assert.Equal(t, dn, result.counterparts[0].name)
+	assert.True(t, result.counterparts[0].identical(result.record), "It should report identical copies")
+
+	result, err = f.cv.show(&ShowCommand{VenafiDN: dn})
+	assert.Nil(t, err, "It should show a Venafi certificate")
+	assert.Len(t, result.counterparts, 1, "It should find the CredHub copy")
+	assert.Equal(t, "/shown", result.counterparts[0].name)
+
+	f.credhub.PutCertificate("/shown", "", f.tpp.CA(), "")
+	result, err = f.cv.show(&ShowCommand{Name: "/shown", Zone: fakeZone})
+	assert.Nil(t, err)
+	assert.Len(t, result.counterparts, 1, "It should show the object named like the credential")
+	assert.False(t, result.counterparts[0].identical(result.record), "It should report differing copies")
+
+	result, err = f.cv.show(&ShowCommand{VenafiDN: dn})
+	assert.Nil(t, err)
+	assert.Len(t, result.counterparts, 1, "It should show the credential named like the object")
+	assert.False(t, result.counterparts[0].identical(result.record))
+
+	_, err = f.cv.show(&ShowCommand{Name: "/missing"})
+	assert.NotNil(t, err, "It should raise an error for a missing certificate")
+}
+
+func TestVerify(t *testing.T) {
+	f := newFakeCV(t)
+	for _, name := range []string{"good", "other"} {
+		v := &GenerateAndStoreCommand{Name: name, CommonName: name + ".example.com"}
+		assert.Nil(t, f.cv.generateAndStore("/"+name, v, true))
+	}
+	good := f.credhub.Versions("/good")[0].Value.(map[string]interface{})
+	other := f.credhub.Versions("/other")[0].Value.(map[string]interface{})
+
+	items, err := f.cv.verify(&VerifyCommand{Name: "/good"})
+	assert.Nil(t, err, "It should verify a consistent certificate")
+	assert.Equal(t, checkOK, items[0].key)
+	assert.Equal(t, checkOK, items[0].chain, "It should validate the chain stored with the certificate")
+	assert.Len(t, items[0].venafiDNs, 1)
+
+	f.credhub.PutCertificate("/good", "", good["certificate"].(string), good["private_key"].(string))
+	items, err = f.cv.verify(&VerifyCommand{Name: "/good"})
+	assert.Nil(t, err)
+	assert.Equal(t, checkSkipped, items[0].chain, "It should skip the chain without a CA")
+
+	f.credhub.PutCertificate("/swapped", "", good["certificate"].(string), other["private_key"].(string))
+	f.credhub.PutCertificate("/wrong-ca", other["certificate"].(string), good["certificate"].(string), "")
+	f.credhub.PutCertificate("/credhub-only", "", f.tpp.CA(), "")
+
+	items, err = f.cv.verify(&VerifyCommand{All: true})
+	assert.NotNil(t, err, "It should report failing certificates")
+	assert.Contains(t, err.Error(), "3 of 5")
+	results := map[string]verifyItem{}
+	for _, item := range items {
+		results[item.name] = item
+	}
+	assert.False(t, results["/good"].failed())
+	assert.Equal(t, checkFailed, results["/swapped"].key, "It should detect a key that does not belong to the cert")
+	assert.Equal(t, checkFailed, results["/wrong-ca"].chain, "It should detect a chain that does not validate")
+	assert.Equal(t, checkSkipped, results["/wrong-ca"].key)
+	assert.Equal(t, checkFailed, results["/credhub-only"].venafi, "It should detect a cert missing in Venafi")
+
+	f.tpp.Fail(http.MethodGet, "/vedsdk/certificates/", http.StatusForbidden, `{"Error":"denied"}`)
+	items, err = f.cv.verify(&VerifyCommand{All: true})
+	assert.NotNil(t, err)
+	assert.Len(t, items, 5, "It should go on when Venafi can't be searched for one certificate")
+	searchFailed := 0
+	for _, item := range items {
+		for _, p := range item.problems {
+			if strings.HasPrefix(p, "could not search Venafi") {
+				searchFailed++
+			}
+		}
+	}
+	assert.Equal(t, 1, searchFailed, "It should report the failed search")
+}
+
+func TestSync(t *testing.T) {
+	f := newFakeCV(t)
+	kube := k8sfake.NewServer("sa-token")
+	t.Cleanup(kube.Close)
+	kc := &k8sclient.Client{BaseURL: kube.URL, Token: "sa-token"}
+	f.cv.kubernetes = kc
+
+	_, err := f.cv.vcert.Generate(&vcclient.CertArgs{Name: "web", CommonName: "web.example.com"})
+	assert.Nil(t, err)
+	keyed := map[string]string{}
+	for _, name := range []string{"apps\\api", "shared"} {
+		// TPP keeps no key for a CSR generated by cv, add the key pair again
+		pcc, err := f.cv.vcert.Generate(&vcclient.CertArgs{Name: name, CommonName: "sync.example.com"})
+		assert.Nil(t, err)
+		o, err := f.tpp.AddCertificate(fakeZone, name, pcc.Certificate, pcc.PrivateKey)
+		assert.Nil(t, err)
+		keyed[o.DN] = pcc.PrivateKey
+	}
+	kube.Put("default", "shared", "Opaque", nil, map[string][]byte{"password": []byte("secret")})
+	args := &SyncCommand{
+		VenafiRoot:  vcclient.PrependPolicyRoot(fakeZone),
+		VenafiLimit: 100,
+		Namespace:   "default",
+		Mappings:    []config.KubernetesMapping{{Policy: fakeZone + "\\Apps", Namespace: "apps", Prefix: "tls-"}},
+	}
+	results := func(items []syncItem) map[string]syncItem {
+		out := map[string]syncItem{}
+		for _, item := range items {
+			out[item.namespace+"/"+item.name] = item
+		}
+		return out
+	}
+
+	args.DryRun = true
+	items, err := f.cv.sync(args)
+	assert.NotNil(t, err)
+	assert.Equal(t, syncCreate, results(items)["apps/tls-api"].action, "It should show the Secrets it would create")
+	assert.Equal(t, syncSkipped, results(items)["default/web"].action, "It should find keys Venafi does not hold in a dry run")
+	assert.Equal(t, []string{"default/shared"}, kube.Secrets(), "It should not write Secrets in a dry run")
+
+	args.DryRun = false
+	items, err = f.cv.sync(args)
+	assert.NotNil(t, err, "It should report certificates that could not be synced")
+	assert.Contains(t, err.Error(), "2 of 3")
+	byName := results(items)
+	assert.Equal(t, syncCreate, byName["apps/tls-api"].action, "It should create a Secret in the mapped namespace")
+	assert.Equal(t, syncSkipped, byName["default/web"].action, "It should skip a certificate whose private key Venafi does not hold")
+	assert.Contains(t, byName["default/web"].problems[0], "Venafi does not hold the private key")
+	assert.Equal(t, syncSkipped, byName["default/shared"].action, "It should leave a Secret cv does not manage alone")
+
+	dn := byName["apps/tls-api"].dn
+	o, _ := f.tpp.Object(dn)
+	secret, err := kc.GetSecret("apps", "tls-api")
+	assert.Nil(t, err)
+	assert.Equal(t, k8sclient.SecretTypeTLS, secret.Type)
+	assert.Equal(t, dn, secret.Annotations[annotationVenafiDN], "It should record the Venafi DN")
+	assert.NotEmpty(t, secret.Annotations[annotationThumbprint], "It should record the thumbprint")
+	assert.Equal(t, managedByCV, secret.Labels[labelManagedBy])
+	assert.True(t, strings.HasPrefix(string(secret.Data["tls.crt"]), strings.TrimSpace(o.Certificate)), "It should store the certificate first in tls.crt")
+	assert.Equal(t, strings.TrimSpace(keyed[dn]), strings.TrimSpace(string(secret.Data["tls.key"])), "It should store the private key")
+	assert.NotEmpty(t, secret.Data["ca.crt"])
+
+	items, _ = f.cv.sync(args)
+	assert.Equal(t, syncUnchanged, results(items)["apps/tls-api"].action, "It should not rewrite a Secret with the same thumbprint")
+
+	secret.Annotations[annotationThumbprint] = "outdated"
+	assert.Nil(t, kc.UpdateSecret(secret))
+	items, _ = f.cv.sync(args)
+	assert.Equal(t, syncUpdate, results(items)["apps/tls-api"].action, "It should update a Secret with another thumbprint")
+	secret, _ = kc.GetSecret("apps", "tls-api")
+	assert.NotEqual(t, "outdated", secret.Annotations[annotationThumbprint])
+}
+
+func TestSecretMapper(t *testing.T) {
+	m := secretMapper{
+		root:      "\\VED\\Policy\\Certificates",
+		namespace: "default",
+		mappings: []config.KubernetesMapping{
+			{Policy: "Certificates\\Apps", Namespace: "apps"},
+			{Policy: "Certificates\\Apps\\Payments", Namespace: "payments", Prefix: "venafi-"},
+		},
+	}
+	for dn, want := range map[string]string{
+		"\\VED\\Policy\\Certificates\\Web Server":                  "default/web-server",
+		"\\VED\\Policy\\Certificates\\apps\\Team\\API_1":           "apps/team-api-1",
+		"\\VED\\Policy\\Certificates\\Apps\\Payments\\checkout.io": "payments/venafi-checkout.io",
+		"\\VED\\Policy\\Certificates\\Applications\\x":             "default/applications-x",
+	} {
+		namespace, name, err := m.secretFor(dn)
+		assert.Nil(t, err)
+		assert.Equal(t, want, namespace+"/"+name, "It should map %s by the longest matching policy", dn)
+	}
+
+	_, _, err := secretMapper{root: m.root}.secretFor("\\VED\\Policy\\Certificates\\web")
+	assert.NotNil(t, err, "It should require a namespace")
+	assert.Len(t, secretName(strings.Repeat("a", 300)), maxSecretName, "It should shorten long names")
+}
+
+func TestListMatrix(t *testing.T) {
+	f := newFakeCV(t)
+	for _, name := range []string{"shared", "venafi-only"} {
+		err := f.cv.generateAndStore("/"+name, &GenerateAndStoreCommand{Name: name, CommonName: name}, false)
+		assert.Nil(t, err)
+	}
+	shared, _ := f.tpp.Object("\\VED\\Policy\\" + fakeZone + "\\shared")
+	f.credhub.PutCertificate("/shared", "", shared.Certificate, "")
+	f.credhub.PutCertificate("/apps/default-only", "", f.tpp.CA(), "")
+
+	other := chfake.NewServer()
+	t.Cleanup(other.Close)
+	other.AddClient("foundation_b", "secret")
+	cp := &chclient.CredhubProxy{BaseURL: other.URL, ClientID: "foundation_b", ClientSecret: "secret"}
+	assert.Nil(t, cp.AuthClient())
+	_, err := cp.GenerateCertificate("/shared", secretstore.GenerateParameters{CommonName: "shared", SelfSign: true}, true)
+	assert.Nil(t, err)
+
+	args := &ListCommand{VenafiLimit: 100, VenafiRoot: vcclient.PrependPolicyRoot(fakeZone)}
+	profiles := []profileStore{{name: defaultProfile, store: f.cv.store}, {name: "b", store: cp}}
+	m, err := f.cv.listMatrix(args, profiles)
+	assert.Nil(t, err, "It should compare Venafi with several CredHubs")
+	assert.Equal(t, []string{sideVenafi, defaultProfile, "b"}, m.Sides)
+	rows := map[string]*MatrixRow{}
+	for _, r := range m.Rows {
+		rows[r.Key] = r
+	}
+	assert.Len(t, rows, 3)
+	assert.Equal(t, matrixPresent, rows["shared"].Cells[defaultProfile].Status, "It should find the same certificate")
+	assert.Equal(t, matrixDivergent, rows["shared"].Cells["b"].Status, "It should find a certificate with another thumbprint")
+	assert.Equal(t, []string{"/shared"}, rows["shared"].Cells["b"].Names)
+	assert.Equal(t, matrixMissing, rows["venafi-only"].Cells["b"].Status, "It should find missing certificates")
+	assert.Equal(t, matrixMissing, rows["default-only"].Cells[sideVenafi].Status)
+	assert.Equal(t, matrixPresent, rows["default-only"].Cells[defaultProfile].Status, "It should take the first side holding a certificate as reference")
+	assert.False(t, rows["shared"].consistent())
+
+	args.ByThumbprint = true
+	args.Output = formatJSON
+	m, err = f.cv.listMatrix(args, profiles)
+	assert.Nil(t, err, "It should write the matrix as JSON")
+	rows = map[string]*MatrixRow{}
+	for _, r := range m.Rows {
+		rows[r.Key] = r
+	}
+	byThumbprint := rows[strings.ToUpper(shared.Thumbprint)]
+	assert.Equal(t, matrixPresent, byThumbprint.Cells[defaultProfile].Status, "It should match by thumbprint")
+	assert.Equal(t, matrixMissing, byThumbprint.Cells["b"].Status)
+	b, err := json.Marshal(m)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"cells":{"b":{"status":"missing"}`)
+
+	args = &ListCommand{VenafiLimit: 100, VenafiRoot: vcclient.PrependPolicyRoot(fakeZone), Statuses: stringSlice{statusDrifted}}
+	m, err = f.cv.listMatrix(args, profiles)
+	assert.Nil(t, err)
+	assert.Len(t, m.Rows, 1, "It should filter the matrix by status")
+	assert.Equal(t, "shared", m.Rows[0].Key)
+	args.Statuses = stringSlice{statusMissingInCredhub}
+	args.NameRegex = "^venafi"
+	m, err = f.cv.listMatrix(args, profiles)
+	assert.Nil(t, err)
+	assert.Len(t, m.Rows, 1, "It should filter the matrix by the certificates of a row")
+	assert.Equal(t, "venafi-only", m.Rows[0].Key)
+}
+
+func TestListBothFilters(t *testing.T) {
+	f := newFakeCV(t)
+	for _, name := range []string{"matched", "drifted"} {
+		err := f.cv.generateAndStore("/"+name, &GenerateAndStoreCommand{Name: name, CommonName: name}, false)
+		assert.Nil(t, err)
+	}
+	matched, _ := f.tpp.Object("\\VED\\Policy\\" + fakeZone + "\\matched")
+	f.credhub.PutCertificate("/matched", "", matched.Certificate, "")
+	f.credhub.PutCertificate("/drifted", "", f.tpp.CA(), "")
+	f.credhub.PutCertificate("/credhub-only", "", f.tpp.CA(), "")
+	assert.True(t, f.tpp.SetCustomField(matched.DN, "Owner", "team-a"))
+
+	labels := func(args *ListCommand) []string {
+		args.VenafiLimit = 100
+		args.VenafiRoot = vcclient.PrependPolicyRoot(fakeZone)
+		data, err := f.cv.listBoth(args)
+		assert.Nil(t, err)
+		out := []string{}
+		for _, d := range data {
+			if d.Left != nil {
+				out = append(out, d.Left.Label())
+			} else {
+				out = append(out, d.Right.Label())
+			}
+		}
+		return out
+	}
+
+	assert.Len(t, labels(&ListCommand{}), 3)
+	assert.Equal(t, []string{"drifted"}, labels(&ListCommand{Statuses: stringSlice{statusDrifted}}), "It should find certificates that differ from Venafi")
+	assert.Equal(t, []string{"matched"}, labels(&ListCommand{Statuses: stringSlice{statusMatched}}), "It should find certificates that are the same on both sides")
+	assert.Len(t, labels(&ListCommand{Statuses: stringSlice{statusMissingInVenafi, statusMatched}}), 2, "It should select any of several statuses")
+	assert.Empty(t, labels(&ListCommand{Statuses: stringSlice{statusMissingInCredhub}}))
+
+	assert.Equal(t, []string{"drifted"}, labels(&ListCommand{NameRegex: "^drift"}), "It should match the name of the certificates")
+	assert.Equal(t, []string{"matched"}, labels(&ListCommand{CustomFields: stringSlice{"Owner=team-a"}}), "It should match Venafi custom fields")
+	assert.Empty(t, labels(&ListCommand{CustomFields: stringSlice{"Owner=team-b"}}))
+	assert.Len(t, labels(&ListCommand{KeyType: "rsa"}), 3, "It should match the key type")
+	assert.Empty(t, labels(&ListCommand{KeyType: "ecdsa"}))
+	assert.Empty(t, labels(&ListCommand{ExpiresWithin: 1}), "It should only find certificates expiring soon")
+	assert.Len(t, labels(&ListCommand{ExpiresWithin: 36500}), 3)
+	assert.Empty(t, labels(&ListCommand{Issuer: "^CN=nobody$"}), "It should match the issuer")
+	assert.Equal(t, []string{"drifted"}, labels(&ListCommand{NameRegex: "^drift", Statuses: stringSlice{statusDrifted}}), "It should combine the filters")
+
+	_, err := f.cv.listBoth(&ListCommand{Statuses: stringSlice{"gone"}})
+	assert.NotNil(t, err, "It should reject unknown statuses")
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/newcontext-oss/credhub-venafi/output"
+	"github.com/newcontext-oss/credhub-venafi/vcclient"
+)
+
+// deleteItem is a certificate selected for deletion with its copies on each
+// platform. Either side may be empty.
+type deleteItem struct {
+	thumbprint  string
+	credhubName string
+	venafiDNs   []string
+	err         error
+}
+
+func (c *CV) deleteCert(args *DeleteCommand) error {
+	items, err := c.selectForDelete(args)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return errors.New("no certificates matched")
+	}
+
+	printDeletePreview(items, args, c.storeTitle())
+	if args.DryRun {
+		return nil
+	}
+	if !args.Yes && !confirm(fmt.Sprintf("Delete %d certificates?", len(items))) {
+		return errors.New("delete cancelled")
+	}
+
+	failed := 0
+	for i := range items {
+		items[i].err = c.deleteItem(&items[i], args)
+		if items[i].err != nil {
+			failed++
+		}
+	}
+
+	printDeleteResults(items)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d deletions failed", failed, len(items))
+	}
+	return nil
+}
+
+// selectForDelete finds the certificates selected by name, thumbprint,
+// Venafi DN or CredHub name pattern on both platforms. CredHub credentials
+// whose thumbprint can't be read are reported and left out of a pattern or
+// thumbprint selection.
+func (c *CV) selectForDelete(args *DeleteCommand) ([]deleteItem, error) {
+	items := []deleteItem{}
+	switch {
+	case args.Name != "":
+		tp, err := credhubThumbprint(c.store, args.Name)
+		if err != nil {
+			return nil, err
+		}
+		items = []deleteItem{{thumbprint: tp, credhubName: args.Name}}
+	case args.Match != "":
+		if _, err := path.Match(args.Match, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %s", args.Match, err)
+		}
+		certs, err := c.store.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			if ok, _ := path.Match(args.Match, cert.Name); !ok {
+				continue
+			}
+			tp, err := credhubThumbprint(c.store, cert.Name)
+			if err != nil {
+				output.Errorf("%s\n", err)
+				continue
+			}
+			items = append(items, deleteItem{thumbprint: tp, credhubName: cert.Name})
+		}
+	case args.Thumbprint != "":
+		tp := normalizeThumbprint(args.Thumbprint)
+		dns, err := c.vcert.FindByThumbprint(tp)
+		if err != nil {
+			return nil, err
+		}
+		items, err = c.itemsByThumbprint(tp, dns, args)
+		if err != nil {
+			return nil, err
+		}
+		return prune(items, args), nil
+	case args.VenafiDN != "":
+		pcc, err := c.vcert.RetrieveCertificateByDN(args.VenafiDN)
+		if err != nil {
+			return nil, fmt.Errorf("could not find '%s' in Venafi: %s", args.VenafiDN, err)
+		}
+		tp, err := thumbprintOf(pcc.Certificate)
+		if err != nil {
+			return nil, err
+		}
+		// only the requested object is deleted, not other copies of the cert
+		items, err = c.itemsByThumbprint(tp, []string{args.VenafiDN}, args)
+		if err != nil {
+			return nil, err
+		}
+		return prune(items, args), nil
+	}
+
+	if args.Only != targetCredhub {
+		for i := range items {
+			dns, err := c.vcert.FindByThumbprint(items[i].thumbprint)
+			if err != nil {
+				return nil, err
+			}
+			items[i].venafiDNs = dns
+		}
+	}
+	return prune(items, args), nil
+}
+
+// itemsByThumbprint finds all CredHub credentials holding the certificate
+// with the given thumbprint and pairs them with the Venafi objects in dns.
+// CredHub is not searched when only Venafi is deleted from.
+func (c *CV) itemsByThumbprint(thumbprint string, dns []string, args *DeleteCommand) ([]deleteItem, error) {
+	if args.Only == targetVenafi {
+		return []deleteItem{{thumbprint: thumbprint, venafiDNs: dns}}, nil
+	}
+	items := []deleteItem{}
+	certs, err := c.store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range certs {
+		tp, err := credhubThumbprint(c.store, cert.Name)
+		if err != nil {
+			output.Errorf("%s\n", err)
+			continue
+		}
+		if tp == thumbprint {
+			items = append(items, deleteItem{thumbprint: thumbprint, credhubName: cert.Name, venafiDNs: dns})
+		}
+	}
+	if len(items) == 0 {
+		items = append(items, deleteItem{thumbprint: thumbprint, venafiDNs: dns})
+	}
+	return items, nil
+}
+
+// prune clears the side excluded by -only, makes sure every Venafi object is
+// only deleted once when several CredHub credentials hold the same cert and
+// drops items that have nothing left to delete
+func prune(items []deleteItem, args *DeleteCommand) []deleteItem {
+	out := []deleteItem{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		dns := []string{}
+		for _, dn := range item.venafiDNs {
+			if args.Only != targetCredhub && !seen[strings.ToLower(dn)] {
+				seen[strings.ToLower(dn)] = true
+				dns = append(dns, dn)
+			}
+		}
+		item.venafiDNs = dns
+		if args.Only == targetVenafi {
+			item.credhubName = ""
+		}
+		if item.credhubName == "" && len(item.venafiDNs) == 0 {
+			continue
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+func (c *CV) deleteItem(item *deleteItem, args *DeleteCommand) error {
+	for _, dn := range item.venafiDNs {
+		output.Status("NOW DELETING FROM VENAFI '%s'\n", dn)
+		err := c.deleteVenafi(dn, args)
+		if err != nil {
+			return err
+		}
+	}
+	if item.credhubName != "" {
+		output.Status("NOW DELETING FROM %s '%s'\n", c.storeTitle(), item.credhubName)
+		return c.store.DeleteCert(item.credhubName)
+	}
+	return nil
+}
+
+// deleteVenafi revokes the certificate object at dn if a reason was given or
+// it should be disabled, and removes the object unless it should be kept
+// disabled
+func (c *CV) deleteVenafi(dn string, args *DeleteCommand) error {
+	if args.Reason != "" || args.Disable {
+		err := c.vcert.Revoke(&vcclient.RevokeArgs{
+			DN:      dn,
+			Reason:  args.Reason,
+			Comment: args.Comment,
+			Disable: args.Disable,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if args.Disable {
+		return nil
+	}
+	return c.vcert.Delete(dn)
+}
+
+// normalizeThumbprint accepts thumbprints in upper or lower case with
+// optional colon or space separators
+func normalizeThumbprint(tp string) string {
+	tp = strings.ReplaceAll(tp, ":", "")
+	tp = strings.ReplaceAll(tp, " ", "")
+	return strings.ToUpper(tp)
+}
+
+func printDeletePreview(items []deleteItem, args *DeleteCommand, store string) {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tVENAFI\tVENAFI ACTION\tTHUMBPRINT\n", store)
+	for _, item := range items {
+		name := item.credhubName
+		if name == "" {
+			name = "-"
+		}
+		dns := []string{"-"}
+		if len(item.venafiDNs) > 0 {
+			dns = item.venafiDNs
+		}
+		for i, dn := range dns {
+			action := args.venafiAction()
+			if dn == "-" {
+				action = "-"
+			}
+			if i > 0 {
+				name = ""
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, dn, action, strings.ToLower(item.thumbprint))
+		}
+	}
+	w.Flush()
+	output.Print("%s%s", output.Cyan, b.String())
+}
+
+func printDeleteResults(items []deleteItem) {
+	for _, item := range items {
+		name := item.credhubName
+		if name == "" {
+			name = strings.Join(item.venafiDNs, ", ")
+		}
+		if item.err != nil {
+			output.Errorf("FAILED  %s: %s\n", name, item.err)
+			continue
+		}
+		output.Status("DELETED %s\n", name)
+	}
+}
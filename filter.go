@@ -0,0 +1,371 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
+	"github.com/newcontext-oss/credhub-venafi/vcclient"
+)
+
+// statuses selected with `cv list -status`
+const (
+	statusMissingInVenafi  = "only-missing-in-venafi"
+	statusMissingInCredhub = "only-missing-in-credhub"
+	statusMatched          = "matched"
+	statusDrifted          = "drifted"
+)
+
+var listStatuses = []string{statusMissingInVenafi, statusMissingInCredhub, statusMatched, statusDrifted}
+
+// key types selected with `cv list -key-type`
+var listKeyTypes = []string{"rsa", "ecdsa"}
+
+// listFilter narrows the rows `cv list` shows. The statuses are matched
+// against the comparison of a row, any of them selects it. The other filters
+// inspect the certificates of a row, and one certificate has to match all of
+// them.
+type listFilter struct {
+	statuses []string
+	// expiresWithin selects certificates expiring within the duration,
+	// including expired ones
+	expiresWithin time.Duration
+	name          *regexp.Regexp
+	issuer        *regexp.Regexp
+	keyType       string
+	// customFields are Venafi custom field values by field name
+	customFields map[string]string
+}
+
+// certDetails are the attributes of a certificate the filters inspect
+type certDetails struct {
+	// names are the common name and the subject alternative names
+	names    []string
+	issuer   string
+	keyType  string
+	notAfter time.Time
+	// customFields are only known for Venafi certificates
+	customFields map[string][]string
+}
+
+// detailSource reads the details of a certificate by the id of its side
+type detailSource func(id string) (certDetails, error)
+
+// filter returns the filter of the list flags, or nil if none is set
+func (v *ListCommand) filter() (*listFilter, error) {
+	f := &listFilter{statuses: v.Statuses, keyType: strings.ToLower(v.KeyType), customFields: map[string]string{}}
+	for _, s := range v.Statuses {
+		if !contains(listStatuses, s) {
+			return nil, fmt.Errorf("-status must be one of %s", strings.Join(listStatuses, ", "))
+		}
+	}
+	if v.ExpiresWithin < 0 {
+		return nil, fmt.Errorf("-expires-within must not be negative")
+	}
+	f.expiresWithin = time.Duration(v.ExpiresWithin) * 24 * time.Hour
+	var err error
+	if v.NameRegex != "" {
+		f.name, err = regexp.Compile(v.NameRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -name-regex: %s", err)
+		}
+	}
+	if v.Issuer != "" {
+		f.issuer, err = regexp.Compile(v.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -issuer: %s", err)
+		}
+	}
+	if f.keyType == "ec" {
+		f.keyType = "ecdsa"
+	}
+	if f.keyType != "" && !contains(listKeyTypes, f.keyType) {
+		return nil, fmt.Errorf("-key-type must be one of %s", strings.Join(listKeyTypes, ", "))
+	}
+	for _, field := range v.CustomFields {
+		i := strings.Index(field, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("-custom-field must be given as name=value")
+		}
+		f.customFields[field[:i]] = field[i+1:]
+	}
+
+	if len(f.statuses) == 0 && !f.inspects() {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// inspects tells whether the filter needs the details of the certificates
+func (f *listFilter) inspects() bool {
+	return f.expiresWithin > 0 || f.name != nil || f.issuer != nil || f.keyType != "" || len(f.customFields) > 0
+}
+
+// selects tells whether a row with the given statuses passes the status
+// filter
+func (f *listFilter) selects(statuses []string) bool {
+	if len(f.statuses) == 0 {
+		return true
+	}
+	return intersects(f.statuses, statuses)
+}
+
+// matches tells whether a certificate passes all other filters
+func (f *listFilter) matches(d certDetails) bool {
+	if f.expiresWithin > 0 && d.notAfter.After(time.Now().Add(f.expiresWithin)) {
+		return false
+	}
+	if f.name != nil && !matchesAny(f.name, d.names) {
+		return false
+	}
+	if f.issuer != nil && !f.issuer.MatchString(d.issuer) {
+		return false
+	}
+	if f.keyType != "" && f.keyType != d.keyType {
+		return false
+	}
+	for name, value := range f.customFields {
+		if !contains(d.customFields[name], value) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(re *regexp.Regexp, values []string) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRow tells whether one of the certificates of a row, given by side
+// and ids, passes the filter. Certificates whose details can't be read are
+// reported and do not match.
+func (f *listFilter) matchesRow(ids map[string][]string, sources map[string]detailSource, errs *[]error) bool {
+	for side, list := range ids {
+		for _, id := range list {
+			d, err := sources[side](id)
+			if err != nil {
+				*errs = append(*errs, fmt.Errorf("could not read the details of %s: %s", id, err))
+				continue
+			}
+			if f.matches(d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterCompareData returns the rows of a two sided comparison that pass the
+// filter, all of them if the filter is nil. Certificates matched by another
+// strategy than the thumbprint are read from CredHub to tell matched from
+// drifted ones.
+func (c *CV) filterCompareData(f *listFilter, ct ComparisonStrategy, data []CompareData) ([]CompareData, []error) {
+	errs := []error{}
+	if f == nil {
+		return data, errs
+	}
+	sources := map[string]detailSource{sideVenafi: c.venafiDetails(), defaultProfile: storeDetails(c.store)}
+	kept := []CompareData{}
+	for _, d := range data {
+		if len(f.statuses) > 0 && !f.selects(c.compareStatuses(ct, d, &errs)) {
+			continue
+		}
+		if f.inspects() {
+			ids := map[string][]string{}
+			if d.Left != nil {
+				ids[sideVenafi] = []string{d.Left.ID()}
+			}
+			if d.Right != nil {
+				ids[defaultProfile] = []string{d.Right.ID()}
+			}
+			if !f.matchesRow(ids, sources, &errs) {
+				continue
+			}
+		}
+		kept = append(kept, d)
+	}
+	return kept, errs
+}
+
+// compareStatuses returns the status of a row of a two sided comparison
+func (c *CV) compareStatuses(ct ComparisonStrategy, d CompareData, errs *[]error) []string {
+	switch {
+	case d.Left == nil:
+		return []string{statusMissingInVenafi}
+	case d.Right == nil:
+		return []string{statusMissingInCredhub}
+	}
+	_, byThumbprint := ct.(*ThumbprintStrategy)
+	_, certificate := d.Right.(*CredhubCertificate)
+	if byThumbprint || !certificate {
+		// the sides were matched by their fingerprint
+		return []string{statusMatched}
+	}
+	tp, err := credhubThumbprint(c.store, d.Right.ID())
+	if err != nil {
+		*errs = append(*errs, err)
+		return []string{}
+	}
+	if !strings.EqualFold(tp, fingerprintOf(d.Left)) {
+		return []string{statusDrifted}
+	}
+	return []string{statusMatched}
+}
+
+// filterMatrix removes the rows of a matrix that do not pass the filter
+func (c *CV) filterMatrix(f *listFilter, m *Matrix, profiles []profileStore) {
+	errs := []error{}
+	sources := map[string]detailSource{sideVenafi: c.venafiDetails()}
+	for _, p := range profiles {
+		sources[p.name] = storeDetails(p.store)
+	}
+	rows := []*MatrixRow{}
+	for _, r := range m.Rows {
+		if !f.selects(r.statuses()) {
+			continue
+		}
+		if f.inspects() {
+			ids := map[string][]string{}
+			for side, cell := range r.Cells {
+				ids[side] = cell.Names
+			}
+			if !f.matchesRow(ids, sources, &errs) {
+				continue
+			}
+		}
+		rows = append(rows, r)
+	}
+	m.Rows = rows
+	for _, err := range errs {
+		m.Warnings = append(m.Warnings, err.Error())
+	}
+}
+
+// statuses returns the statuses of a matrix row. A row is missing in
+// CredHub if any profile lacks the certificate, and drifted if any profile
+// holds a divergent one.
+func (r *MatrixRow) statuses() []string {
+	out := []string{}
+	if r.consistent() {
+		out = append(out, statusMatched)
+	}
+	for side, cell := range r.Cells {
+		switch {
+		case cell.Status == matrixMissing && side == sideVenafi:
+			out = append(out, statusMissingInVenafi)
+		case cell.Status == matrixMissing:
+			out = append(out, statusMissingInCredhub)
+		case cell.Status == matrixDivergent:
+			out = append(out, statusDrifted)
+		}
+	}
+	return out
+}
+
+// venafiDetails reads the details of Venafi certificates by DN
+func (c *CV) venafiDetails() detailSource {
+	return cachedDetails(func(dn string) (certDetails, error) {
+		details, err := c.vcert.CertificateDetails(dn)
+		if err != nil {
+			return certDetails{}, err
+		}
+		d := certDetails{
+			names:        []string{details.CN},
+			issuer:       details.Issuer,
+			keyType:      venafiKeyType(details),
+			notAfter:     details.ValidTo,
+			customFields: details.CustomFields,
+		}
+		for _, sans := range [][]string{details.SANS.DNS, details.SANS.IP, details.SANS.Email, details.SANS.URI, details.SANS.UPN} {
+			d.names = append(d.names, sans...)
+		}
+		return d, nil
+	})
+}
+
+// venafiKeyType returns the key type of TPP in the names of -key-type
+func venafiKeyType(details *vcclient.CertificateDetails) string {
+	switch strings.ToUpper(details.KeyAlgorithm) {
+	case "RSA":
+		return "rsa"
+	case "EC", "ECC", "ECDSA":
+		return "ecdsa"
+	}
+	return strings.ToLower(details.KeyAlgorithm)
+}
+
+// storeDetails reads the details of CredHub certificates by name
+func storeDetails(store secretstore.Store) detailSource {
+	return cachedDetails(func(name string) (certDetails, error) {
+		cert, err := store.GetCertificate(name)
+		if err != nil {
+			return certDetails{}, err
+		}
+		block, _ := pem.Decode([]byte(cert.Certificate))
+		if block == nil {
+			return certDetails{}, fmt.Errorf("%s holds no PEM certificate", name)
+		}
+		x, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return certDetails{}, err
+		}
+		d := certDetails{
+			names:    append([]string{x.Subject.CommonName}, x.DNSNames...),
+			issuer:   x.Issuer.String(),
+			notAfter: x.NotAfter,
+		}
+		for _, ip := range x.IPAddresses {
+			d.names = append(d.names, ip.String())
+		}
+		d.names = append(d.names, x.EmailAddresses...)
+		for _, uri := range x.URIs {
+			d.names = append(d.names, uri.String())
+		}
+		switch x.PublicKey.(type) {
+		case *rsa.PublicKey:
+			d.keyType = "rsa"
+		case *ecdsa.PublicKey:
+			d.keyType = "ecdsa"
+		}
+		return d, nil
+	})
+}
+
+func cachedDetails(src detailSource) detailSource {
+	cache := map[string]certDetails{}
+	return func(id string) (certDetails, error) {
+		if d, ok := cache[id]; ok {
+			return d, nil
+		}
+		d, err := src(id)
+		if err == nil {
+			cache[id] = d
+		}
+		return d, err
+	}
+}
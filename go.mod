@@ -1,14 +1,24 @@
 module github.com/newcontext-oss/credhub-venafi
 
-go 1.12
+go 1.20
 
 require (
 	code.cloudfoundry.org/credhub-cli v0.0.0-20191230184144-6e537b521d9d
 	github.com/Venafi/vcert v0.0.0-20200421144231-dd32326727e2
-	github.com/kr/pretty v0.1.0 // indirect
 	github.com/stretchr/testify v1.4.0
-	golang.org/x/net v0.33.0 // indirect
+	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.33.0
+	gopkg.in/yaml.v2 v2.2.8
+)
+
+require (
+	github.com/cloudfoundry/go-socks5 v0.0.0-20180221174514-54f73bdb8a8e // indirect
+	github.com/cloudfoundry/socks5-proxy v0.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/hashicorp/go-version v0.0.0-20171129150820-4fe82ae3040f // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	gopkg.in/ini.v1 v1.51.1 // indirect
-	gopkg.in/yaml.v2 v2.2.8
 )
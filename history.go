@@ -0,0 +1,173 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/newcontext-oss/credhub-venafi/output"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
+)
+
+// historyEntry describes one CredHub version of a certificate
+type historyEntry struct {
+	id           string
+	created      string
+	thumbprint   string
+	expiry       string
+	transitional bool
+	current      bool
+	venafiDNs    []string
+}
+
+func (c *CV) history(name string) ([]historyEntry, error) {
+	versions, err := c.store.GetCertificateVersions(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no certificate versions found for '%s'", name)
+	}
+
+	metadata := map[string]secretstore.CertificateVersion{}
+	md, err := c.store.GetCertificateMetadata(name)
+	if err != nil {
+		output.Errorf("could not get metadata of '%s': %s\n", name, err)
+	}
+	for _, v := range md.Versions {
+		metadata[v.ID] = v
+	}
+
+	entries := []historyEntry{}
+	for i, v := range versions {
+		e := historyEntry{
+			id:           v.ID,
+			created:      v.CreatedAt,
+			expiry:       metadata[v.ID].ExpiryDate,
+			transitional: metadata[v.ID].Transitional,
+			current:      i == 0,
+		}
+		e.thumbprint, err = thumbprintOf(v.Certificate)
+		if err != nil {
+			output.Errorf("could not calculate thumbprint of version %s: %s\n", v.ID, err)
+		} else {
+			e.venafiDNs, err = c.vcert.FindByThumbprint(e.thumbprint)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	printHistory(entries)
+	return entries, nil
+}
+
+// rollback makes an earlier CredHub version the current one again and makes
+// sure the Venafi objects holding the replaced certificate hold the restored
+// one
+func (c *CV) rollback(name string, versionID string) error {
+	versions, err := c.store.GetCertificateVersions(name)
+	if err != nil {
+		return err
+	}
+	var target *secretstore.Certificate
+	for i := range versions {
+		if versions[i].ID == versionID {
+			target = &versions[i]
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("'%s' has no version %s", name, versionID)
+	}
+
+	targetTp, err := thumbprintOf(target.Certificate)
+	if err != nil {
+		return err
+	}
+	currentTp, err := thumbprintOf(versions[0].Certificate)
+	if err != nil {
+		return err
+	}
+
+	if currentTp == targetTp {
+		output.Status("VERSION %s OF '%s' IS ALREADY CURRENT IN %s\n", versionID, name, c.storeTitle())
+	} else {
+		output.Status("NOW RESTORING VERSION %s OF '%s' IN %s\n", versionID, name, c.storeTitle())
+		err = c.store.PutCertificate(name, target.CA, target.Certificate, target.PrivateKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.activateInVenafi(name, currentTp, targetTp, *target)
+}
+
+// activateInVenafi replaces the certificate in the Venafi objects that hold
+// the replaced one. If there are none and the restored certificate is not in
+// Venafi either, it is imported under the CredHub name.
+func (c *CV) activateInVenafi(name string, currentTp string, targetTp string, value secretstore.Certificate) error {
+	dns := []string{}
+	if currentTp != targetTp {
+		var err error
+		dns, err = c.vcert.FindByThumbprint(currentTp)
+		if err != nil {
+			return err
+		}
+	}
+	for _, dn := range dns {
+		output.Status("NOW RESTORING '%s' IN VENAFI\n", dn)
+		err := c.vcert.PutCertificate(dn, value.Certificate, value.PrivateKey)
+		if err != nil {
+			return err
+		}
+	}
+	if len(dns) > 0 {
+		return nil
+	}
+
+	active, err := c.vcert.FindByThumbprint(targetTp)
+	if err != nil {
+		return err
+	}
+	if len(active) > 0 {
+		output.Status("'%s' IS ACTIVE IN VENAFI\n", strings.Join(active, "', '"))
+		return nil
+	}
+	output.Status("NOW UPLOADING TO VENAFI '%s'\n", name)
+	return c.vcert.PutCertificate(name, value.Certificate, value.PrivateKey)
+}
+
+func printHistory(entries []historyEntry) {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\tVERSION\tCREATED\tTHUMBPRINT\tEXPIRES\tTRANSITIONAL\tVENAFI\n")
+	for _, e := range entries {
+		current := ""
+		if e.current {
+			current = "*"
+		}
+		venafi := "-"
+		if len(e.venafiDNs) > 0 {
+			venafi = strings.Join(e.venafiDNs, ", ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%s\n", current, e.id, e.created, strings.ToLower(e.thumbprint), e.expiry, e.transitional, venafi)
+	}
+	w.Flush()
+	output.Print("%s%s", output.Cyan, b.String())
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpclient builds the HTTP clients used to reach CredHub, Venafi,
+// Vault and Kubernetes with the proxy and timeouts configured for each of
+// them, and sends the JSON requests of the APIs cv calls directly.
+package httpclient
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Settings configures the connections to one server
+type Settings struct {
+	// Proxy is the URL of the proxy for all requests. If it is empty the
+	// HTTPS_PROXY and HTTP_PROXY environment variables are used.
+	Proxy string
+	// NoProxy lists hosts that are reached directly, like the NO_PROXY
+	// environment variable which is used if it is empty
+	NoProxy string
+	// ConnectTimeout limits establishing a connection, 0 keeps the default
+	ConnectTimeout time.Duration
+	// RequestTimeout limits a whole request including reading the response,
+	// 0 keeps the default
+	RequestTimeout time.Duration
+}
+
+// ProxyFunc returns the proxy to use for a request
+func (s Settings) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	if s.Proxy == "" && s.NoProxy == "" {
+		return http.ProxyFromEnvironment
+	}
+	c := httpproxy.FromEnvironment()
+	if s.Proxy != "" {
+		c.HTTPProxy = s.Proxy
+		c.HTTPSProxy = s.Proxy
+	}
+	if s.NoProxy != "" {
+		c.NoProxy = s.NoProxy
+	}
+	proxy := c.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
+
+// Apply configures an existing client, as created by a client library, to
+// use the settings. The TLS configuration of the client is kept.
+func (s Settings) Apply(client *http.Client) {
+	t, ok := client.Transport.(*http.Transport)
+	if !ok {
+		if client.Transport != nil {
+			// a custom transport has its own way to connect
+			return
+		}
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	t.Proxy = s.ProxyFunc()
+	if s.ConnectTimeout > 0 {
+		t.Dial = nil
+		t.DialContext = (&net.Dialer{Timeout: s.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+		t.TLSHandshakeTimeout = s.ConnectTimeout
+	}
+	client.Transport = t
+	if s.RequestTimeout > 0 {
+		client.Timeout = s.RequestTimeout
+	}
+}
+
+// Client returns a new client with the settings and TLS configuration
+func (s Settings) Client(tlsConfig *tls.Config) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConfig
+	client := &http.Client{Transport: t}
+	s.Apply(client)
+	return client
+}
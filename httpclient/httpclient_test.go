@@ -0,0 +1,106 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpclient_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/newcontext-oss/credhub-venafi/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func proxyFor(t *testing.T, s httpclient.Settings, url string) string {
+	req, err := http.NewRequest("GET", url, nil)
+	assert.Nil(t, err)
+	p, err := s.ProxyFunc()(req)
+	assert.Nil(t, err)
+	if p == nil {
+		return ""
+	}
+	return p.String()
+}
+
+func TestProxyFunc(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+	t.Setenv("NO_PROXY", "")
+
+	s := httpclient.Settings{Proxy: "http://tpp-proxy:8080", NoProxy: "internal.example.com,10.0.0.0/8"}
+	assert.Equal(t, "http://tpp-proxy:8080", proxyFor(t, s, "https://tpp.example.com/vedsdk"), "It should use the configured proxy")
+	assert.Equal(t, "", proxyFor(t, s, "https://credhub.internal.example.com:8844/api"), "It should reach subdomains of NO_PROXY hosts directly")
+	assert.Equal(t, "", proxyFor(t, s, "https://10.1.2.3/api"), "It should reach NO_PROXY networks directly")
+
+	s = httpclient.Settings{NoProxy: "tpp.example.com"}
+	assert.Equal(t, "", proxyFor(t, s, "https://tpp.example.com/vedsdk"), "It should apply the configured NO_PROXY to the environment proxy")
+	assert.Equal(t, "http://env-proxy:3128", proxyFor(t, s, "https://other.example.com/"), "It should use the environment proxy without a configured one")
+}
+
+func TestApplyTimeouts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{}
+	httpclient.Settings{ConnectTimeout: time.Second, RequestTimeout: 50 * time.Millisecond}.Apply(client)
+	assert.Equal(t, 50*time.Millisecond, client.Timeout, "It should set the request timeout")
+	tr, ok := client.Transport.(*http.Transport)
+	assert.True(t, ok, "It should install a transport")
+	assert.Equal(t, time.Second, tr.TLSHandshakeTimeout, "It should limit the TLS handshake by the connect timeout")
+
+	_, err := client.Get(ts.URL)
+	assert.NotNil(t, err, "It should fail a request that takes longer than the request timeout")
+}
+
+func TestJSONRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/echo":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"token":"` + r.Header.Get("X-Token") + `"}`))
+		case "/denied":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"denied by policy"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("<html>bad request</html>"))
+		}
+	}))
+	defer ts.Close()
+	client, err := httpclient.Settings{}.APIClient(nil, false)
+	assert.Nil(t, err)
+	message := func(body []byte) (string, error) {
+		var e struct{ Message string }
+		err := json.Unmarshal(body, &e)
+		return e.Message, err
+	}
+
+	var out struct{ Token string }
+	err = httpclient.JSONRequest(client, "POST", ts.URL+"/echo", http.Header{"X-Token": []string{"abc"}}, map[string]string{"a": "b"}, &out, message)
+	assert.Nil(t, err, "It should decode the response")
+	assert.Equal(t, "abc", out.Token, "It should send the headers")
+
+	err = httpclient.JSONRequest(client, "GET", ts.URL+"/denied", nil, nil, nil, message)
+	assert.Equal(t, "403 Forbidden denied by policy", err.Error(), "It should report the message of the API")
+	err = httpclient.JSONRequest(client, "GET", ts.URL+"/html", nil, nil, nil, message)
+	assert.Equal(t, http.StatusBadRequest, err.(*httpclient.StatusError).StatusCode)
+	assert.Equal(t, "400 Bad Request <html>bad request</html>", err.Error(), "It should fall back to the body")
+
+	_, err = httpclient.Settings{}.APIClient([]string{"not a certificate"}, false)
+	assert.NotNil(t, err, "It should reject invalid CA certificates")
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/newcontext-oss/credhub-venafi/retry"
+)
+
+// APIClient returns a client for a JSON API that trusts the PEM CA
+// certificates in addition to the system roots, and returns 429 and 5xx
+// responses as a retry.StatusError
+func (s Settings) APIClient(caCerts []string, skipTLSValidation bool) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: skipTLSValidation}
+	if len(caCerts) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, ca := range caCerts {
+			if !pool.AppendCertsFromPEM([]byte(ca)) {
+				return nil, fmt.Errorf("could not parse the CA certificate")
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+	client := s.Client(tlsConfig)
+	client.Transport = &retry.Transport{Base: client.Transport}
+	return client, nil
+}
+
+// StatusError is a response with a status other than 2xx. Message is the
+// error reported by the API, or the body if it holds none.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return e.Status
+	}
+	return e.Status + " " + e.Message
+}
+
+// ErrorMessage extracts the error message from the body of a failed
+// response. It fails if the body is not an error of the API.
+type ErrorMessage func(body []byte) (string, error)
+
+// JSONRequest sends in, unless it is nil, as JSON and decodes the response
+// into out, unless it is nil. Statuses other than 2xx are returned as a
+// *StatusError with the message extracted by message.
+func JSONRequest(client *http.Client, method string, url string, header http.Header, in interface{}, out interface{}, message ErrorMessage) error {
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Message: errorMessage(b, message)}
+	}
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// maxErrorBody limits the part of a body that is kept as error message
+const maxErrorBody = 4096
+
+// errorMessage returns the message of an error body, falling back to the
+// body itself when it is not an error of the API, such as the HTML page of a
+// proxy
+func errorMessage(body []byte, message ErrorMessage) string {
+	if message != nil {
+		m, err := message(body)
+		if err == nil && m != "" {
+			return m
+		}
+	}
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody]
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,196 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package k8sclient reads and writes Secrets with the Kubernetes API
+package k8sclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/newcontext-oss/credhub-venafi/httpclient"
+)
+
+// SecretTypeTLS is the type of Secrets holding a certificate and its key in
+// the tls.crt and tls.key entries
+const SecretTypeTLS = "kubernetes.io/tls"
+
+// ServiceAccountDir holds the token, CA certificate and namespace of the
+// service account of a pod
+var ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// IClient defines the interface for clients that manage Kubernetes Secrets
+type IClient interface {
+	GetSecret(namespace string, name string) (*Secret, error)
+	CreateSecret(secret *Secret) error
+	UpdateSecret(secret *Secret) error
+}
+
+// Secret is a Kubernetes Secret. Data holds the decoded values.
+type Secret struct {
+	Namespace   string
+	Name        string
+	Type        string
+	Labels      map[string]string
+	Annotations map[string]string
+	Data        map[string][]byte
+	// ResourceVersion is the version a Secret was read at. An update fails
+	// if the Secret changed since.
+	ResourceVersion string
+}
+
+// secretObject is a Secret as sent to and returned by the API server
+type secretObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace,omitempty"`
+		Labels          map[string]string `json:"labels,omitempty"`
+		Annotations     map[string]string `json:"annotations,omitempty"`
+		ResourceVersion string            `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Type string            `json:"type,omitempty"`
+	Data map[string][]byte `json:"data,omitempty"`
+}
+
+// Client contains the config information for requests to the Kubernetes API
+type Client struct {
+	BaseURL string
+	// Token is the bearer token of a service account or user
+	Token             string
+	SkipTLSValidation bool
+	// CACerts are PEM certificates trusted for the API server in addition to
+	// the system roots
+	CACerts []string
+	// HTTP sets the proxy and timeouts for the API server
+	HTTP httpclient.Settings
+
+	client *http.Client
+}
+
+// InCluster returns a client for the API server of the cluster cv runs in,
+// authenticated with the service account of its pod, and the namespace of
+// the pod
+func InCluster() (*Client, string, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, "", fmt.Errorf("not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	token, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "token"))
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read the service account token: %s", err)
+	}
+	c := &Client{BaseURL: "https://" + net.JoinHostPort(host, port), Token: strings.TrimSpace(string(token))}
+	if ca, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "ca.crt")); err == nil {
+		c.CACerts = []string{string(ca)}
+	}
+	namespace, _ := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "namespace"))
+	return c, strings.TrimSpace(string(namespace)), nil
+}
+
+// GetSecret returns a Secret, or nil if it does not exist
+func (c *Client) GetSecret(namespace string, name string) (*Secret, error) {
+	var obj secretObject
+	status, err := c.request(http.MethodGet, secretsPath(namespace)+"/"+name, nil, &obj)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Secret{
+		Namespace:       obj.Metadata.Namespace,
+		Name:            obj.Metadata.Name,
+		Type:            obj.Type,
+		Labels:          obj.Metadata.Labels,
+		Annotations:     obj.Metadata.Annotations,
+		Data:            obj.Data,
+		ResourceVersion: obj.Metadata.ResourceVersion,
+	}, nil
+}
+
+// CreateSecret creates a Secret that does not exist yet
+func (c *Client) CreateSecret(secret *Secret) error {
+	_, err := c.request(http.MethodPost, secretsPath(secret.Namespace), toObject(secret), nil)
+	return err
+}
+
+// UpdateSecret replaces a Secret read before, failing if it changed since
+func (c *Client) UpdateSecret(secret *Secret) error {
+	_, err := c.request(http.MethodPut, secretsPath(secret.Namespace)+"/"+secret.Name, toObject(secret), nil)
+	return err
+}
+
+func secretsPath(namespace string) string {
+	return "api/v1/namespaces/" + namespace + "/secrets"
+}
+
+func toObject(secret *Secret) secretObject {
+	obj := secretObject{APIVersion: "v1", Kind: "Secret", Type: secret.Type, Data: secret.Data}
+	obj.Metadata.Name = secret.Name
+	obj.Metadata.Namespace = secret.Namespace
+	obj.Metadata.Labels = secret.Labels
+	obj.Metadata.Annotations = secret.Annotations
+	obj.Metadata.ResourceVersion = secret.ResourceVersion
+	return obj
+}
+
+// httpClient returns the client for the API server
+func (c *Client) httpClient() (*http.Client, error) {
+	if c.client != nil {
+		return c.client, nil
+	}
+	client, err := c.HTTP.APIClient(c.CACerts, c.SkipTLSValidation)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure the Kubernetes client: %s", err)
+	}
+	c.client = client
+	return client, nil
+}
+
+// request sends a request to the API server and decodes the JSON response
+// into out. It returns the status code of a failed response along with the
+// error.
+func (c *Client) request(method string, resource string, in interface{}, out interface{}) (int, error) {
+	client, err := c.httpClient()
+	if err != nil {
+		return 0, err
+	}
+	header := http.Header{}
+	if c.Token != "" {
+		header.Set("Authorization", "Bearer "+c.Token)
+	}
+	err = httpclient.JSONRequest(client, method, strings.TrimSuffix(c.BaseURL, "/")+"/"+resource, header, in, out, statusMessage)
+	if se, ok := err.(*httpclient.StatusError); ok {
+		return se.StatusCode, fmt.Errorf("unexpected status from Kubernetes %s: %s", resource, se)
+	}
+	return 0, err
+}
+
+// statusMessage returns the message of the Status object the API server
+// reports errors with
+func statusMessage(body []byte) (string, error) {
+	var s struct {
+		Message string `json:"message"`
+	}
+	err := json.Unmarshal(body, &s)
+	return s.Message, err
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8sclient_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/newcontext-oss/credhub-venafi/k8sclient"
+	"github.com/newcontext-oss/credhub-venafi/k8sclient/k8sfake"
+	"github.com/newcontext-oss/credhub-venafi/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeKubernetes(t *testing.T) (*k8sfake.Server, *k8sclient.Client) {
+	server := k8sfake.NewServer("sa-token")
+	t.Cleanup(server.Close)
+	return server, &k8sclient.Client{BaseURL: server.URL, Token: "sa-token"}
+}
+
+func TestSecrets(t *testing.T) {
+	server, c := newFakeKubernetes(t)
+
+	secret, err := c.GetSecret("apps", "web-tls")
+	assert.Nil(t, err, "It should not raise an error for a missing secret")
+	assert.Nil(t, secret)
+
+	err = c.CreateSecret(&k8sclient.Secret{
+		Namespace:   "apps",
+		Name:        "web-tls",
+		Type:        k8sclient.SecretTypeTLS,
+		Labels:      map[string]string{"app": "web"},
+		Annotations: map[string]string{"note": "first"},
+		Data:        map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")},
+	})
+	assert.Nil(t, err, "It should create a secret")
+	stored, ok := server.Secret("apps", "web-tls")
+	assert.True(t, ok)
+	assert.Equal(t, k8sclient.SecretTypeTLS, stored.Type)
+
+	secret, err = c.GetSecret("apps", "web-tls")
+	assert.Nil(t, err, "It should read a secret")
+	assert.Equal(t, []byte("cert"), secret.Data["tls.crt"], "It should decode the data")
+	assert.Equal(t, "first", secret.Annotations["note"])
+	assert.Equal(t, "web", secret.Labels["app"])
+	assert.NotEmpty(t, secret.ResourceVersion)
+
+	assert.NotNil(t, c.CreateSecret(secret), "It should not create a secret twice")
+
+	secret.Annotations["note"] = "second"
+	assert.Nil(t, c.UpdateSecret(secret), "It should update a secret")
+	stored, _ = server.Secret("apps", "web-tls")
+	assert.Equal(t, "second", stored.Metadata.Annotations["note"])
+
+	err = c.UpdateSecret(secret)
+	assert.NotNil(t, err, "It should not update a secret that changed since it was read")
+	assert.Contains(t, err.Error(), "409")
+}
+
+func TestRequestErrors(t *testing.T) {
+	_, c := newFakeKubernetes(t)
+	c.Token = "wrong"
+	_, err := c.GetSecret("apps", "web-tls")
+	assert.NotNil(t, err, "It should raise an error for a rejected token")
+	assert.Contains(t, err.Error(), "Unauthorized")
+
+	html := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("<html>blocked by proxy</html>"))
+	}))
+	defer html.Close()
+	_, err = (&k8sclient.Client{BaseURL: html.URL}).GetSecret("apps", "web-tls")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "403 Forbidden <html>blocked by proxy</html>", "It should report a body that is not a Status")
+
+	server, c := newFakeKubernetes(t)
+	r := retry.New("Kubernetes", retry.Policy{Attempts: 3, Budget: 10})
+	r.Sleep = func(time.Duration) {}
+	rp := &k8sclient.RetryProxy{Proxy: c, Retrier: r}
+	server.Fail(http.MethodGet, "/api/v1/namespaces/apps/secrets/web-tls", http.StatusServiceUnavailable)
+	_, err = rp.GetSecret("apps", "web-tls")
+	assert.Nil(t, err, "It should retry a read that failed with a 503")
+	assert.Equal(t, 1, r.Retries())
+}
+
+func TestInCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	_, _, err := k8sclient.InCluster()
+	assert.NotNil(t, err, "It should raise an error outside of a cluster")
+
+	dir := t.TempDir()
+	defer func(d string) { k8sclient.ServiceAccountDir = d }(k8sclient.ServiceAccountDir)
+	k8sclient.ServiceAccountDir = dir
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "token"), []byte("sa-token\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "namespace"), []byte("apps"), 0600))
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+
+	c, namespace, err := k8sclient.InCluster()
+	assert.Nil(t, err, "It should use the service account of the pod")
+	assert.Equal(t, "https://10.0.0.1:443", c.BaseURL)
+	assert.Equal(t, "sa-token", c.Token)
+	assert.Equal(t, "apps", namespace)
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package k8sfake is a fake Kubernetes API server that only serves Secrets
+package k8sfake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is a fake Kubernetes API server backed by in-memory storage
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	token   string
+	secrets map[string]*Secret
+	faults  []fault
+	version int
+}
+
+// Secret is a Secret stored on the fake server
+type Secret struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		Labels          map[string]string `json:"labels,omitempty"`
+		Annotations     map[string]string `json:"annotations,omitempty"`
+		ResourceVersion string            `json:"resourceVersion"`
+	} `json:"metadata"`
+	Type string            `json:"type,omitempty"`
+	Data map[string][]byte `json:"data,omitempty"`
+}
+
+type fault struct {
+	method string
+	path   string
+	status int
+}
+
+// NewServer starts a fake API server accepting the bearer token. Callers
+// must call Close when done.
+func NewServer(token string) *Server {
+	s := &Server{token: token, secrets: map[string]*Secret{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Fail makes the next request with method and path, such as
+// /api/v1/namespaces/default/secrets/web, fail with status
+func (s *Server) Fail(method, path string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault{method: method, path: path, status: status})
+}
+
+// Put stores a Secret as if it was created by someone else
+func (s *Server) Put(namespace, name, secretType string, annotations map[string]string, data map[string][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret := &Secret{APIVersion: "v1", Kind: "Secret", Type: secretType, Data: data}
+	secret.Metadata.Name = name
+	secret.Metadata.Namespace = namespace
+	secret.Metadata.Annotations = annotations
+	s.store(secret)
+}
+
+// Secret returns a stored Secret
+func (s *Server) Secret(namespace, name string) (Secret, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[namespace+"/"+name]
+	if !ok {
+		return Secret{}, false
+	}
+	return *secret, true
+}
+
+// Secrets returns the keys namespace/name of the stored Secrets, sorted
+func (s *Server) Secrets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for k := range s.secrets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) store(secret *Secret) {
+	s.version++
+	secret.Metadata.ResourceVersion = strconv.Itoa(s.version)
+	s.secrets[secret.Metadata.Namespace+"/"+secret.Metadata.Name] = secret
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.faults {
+		if f.method == r.Method && f.path == r.URL.Path {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			writeStatus(w, f.status, "injected fault")
+			return
+		}
+	}
+	if r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeStatus(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// /api/v1/namespaces/{namespace}/secrets[/{name}]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 5 || len(parts) > 6 || parts[0] != "api" || parts[1] != "v1" || parts[2] != "namespaces" || parts[4] != "secrets" {
+		writeStatus(w, http.StatusNotFound, "the server could not find the requested resource")
+		return
+	}
+	namespace := parts[3]
+	name := ""
+	if len(parts) == 6 {
+		name = parts[5]
+	}
+
+	switch {
+	case r.Method == http.MethodGet && name != "":
+		secret, ok := s.secrets[namespace+"/"+name]
+		if !ok {
+			writeStatus(w, http.StatusNotFound, `secrets "`+name+`" not found`)
+			return
+		}
+		writeJSON(w, http.StatusOK, secret)
+	case r.Method == http.MethodPost && name == "":
+		secret, ok := decode(w, r, namespace)
+		if !ok {
+			return
+		}
+		if _, exists := s.secrets[namespace+"/"+secret.Metadata.Name]; exists {
+			writeStatus(w, http.StatusConflict, `secrets "`+secret.Metadata.Name+`" already exists`)
+			return
+		}
+		s.store(secret)
+		writeJSON(w, http.StatusCreated, secret)
+	case r.Method == http.MethodPut && name != "":
+		secret, ok := decode(w, r, namespace)
+		if !ok {
+			return
+		}
+		current, exists := s.secrets[namespace+"/"+name]
+		if !exists {
+			writeStatus(w, http.StatusNotFound, `secrets "`+name+`" not found`)
+			return
+		}
+		if secret.Metadata.ResourceVersion != "" && secret.Metadata.ResourceVersion != current.Metadata.ResourceVersion {
+			writeStatus(w, http.StatusConflict, "the object has been modified; please apply your changes to the latest version and try again")
+			return
+		}
+		s.store(secret)
+		writeJSON(w, http.StatusOK, secret)
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, "the server does not allow this method on the requested resource")
+	}
+}
+
+func decode(w http.ResponseWriter, r *http.Request, namespace string) (*Secret, bool) {
+	var secret Secret
+	err := json.NewDecoder(r.Body).Decode(&secret)
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if secret.Metadata.Namespace != "" && secret.Metadata.Namespace != namespace {
+		writeStatus(w, http.StatusBadRequest, "the namespace of the object does not match the namespace on the request")
+		return nil, false
+	}
+	secret.Metadata.Namespace = namespace
+	return &secret, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeStatus writes an error as the Status object of the Kubernetes API
+func writeStatus(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"kind":       "Status",
+		"apiVersion": "v1",
+		"status":     "Failure",
+		"message":    message,
+		"code":       status,
+	})
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8sclient
+
+import (
+	"github.com/newcontext-oss/credhub-venafi/retry"
+)
+
+// RetryProxy retries the calls of another Kubernetes client that fail with a
+// transient error
+type RetryProxy struct {
+	Proxy   IClient
+	Retrier *retry.Retrier
+}
+
+// GetSecret reads a Secret
+func (r *RetryProxy) GetSecret(namespace string, name string) (*Secret, error) {
+	var secret *Secret
+	err := r.Retrier.Do("get secret "+namespace+"/"+name, retry.Read, func() error {
+		var err error
+		secret, err = r.Proxy.GetSecret(namespace, name)
+		return err
+	})
+	return secret, err
+}
+
+// CreateSecret creates a Secret. A repeated create would conflict with the
+// first, so it is only repeated after an unprocessed attempt.
+func (r *RetryProxy) CreateSecret(secret *Secret) error {
+	return r.Retrier.Do("create secret "+secret.Namespace+"/"+secret.Name, retry.Write, func() error {
+		return r.Proxy.CreateSecret(secret)
+	})
+}
+
+// UpdateSecret replaces a Secret. The update is bound to the version read
+// before, so it is only repeated after an unprocessed attempt.
+func (r *RetryProxy) UpdateSecret(secret *Secret) error {
+	return r.Retrier.Do("update secret "+secret.Namespace+"/"+secret.Name, retry.Write, func() error {
+		return r.Proxy.UpdateSecret(secret)
+	})
+}
@@ -0,0 +1,275 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/newcontext-oss/credhub-venafi/output"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
+)
+
+// sides of a comparison matrix besides the CredHub profiles
+const (
+	sideVenafi = "venafi"
+	// defaultProfile is the CredHub, or Vault, the other commands use
+	defaultProfile = "default"
+)
+
+// where a certificate is held on one side of a matrix
+const (
+	matrixPresent   = "present"
+	matrixMissing   = "missing"
+	matrixDivergent = "divergent"
+)
+
+// output formats of `cv list`
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// profileStore is a CredHub profile compared in a matrix
+type profileStore struct {
+	name  string
+	store secretstore.Store
+}
+
+// Matrix shows where each certificate is present, missing or divergent on
+// Venafi and several CredHubs
+type Matrix struct {
+	// Sides are venafi followed by the CredHub profiles, in column order
+	Sides    []string     `json:"sides"`
+	Rows     []*MatrixRow `json:"rows"`
+	Warnings []string     `json:"warnings,omitempty"`
+}
+
+// MatrixRow is a certificate, identified by the key of the comparison
+// strategy, such as the common name
+type MatrixRow struct {
+	Key   string                 `json:"key"`
+	Cells map[string]*MatrixCell `json:"cells"`
+}
+
+// MatrixCell holds the certificates matching a row on one side. A present
+// certificate is divergent when its thumbprint differs from the Venafi
+// certificate, or from the first side holding it if Venafi does not.
+type MatrixCell struct {
+	Status      string   `json:"status"`
+	Names       []string `json:"names,omitempty"`
+	Thumbprints []string `json:"thumbprints,omitempty"`
+}
+
+func (r *MatrixRow) add(side string, name string, thumbprint string) {
+	cell, ok := r.Cells[side]
+	if !ok {
+		cell = &MatrixCell{Status: matrixPresent}
+		r.Cells[side] = cell
+	}
+	cell.Names = append(cell.Names, name)
+	if thumbprint != "" {
+		cell.Thumbprints = append(cell.Thumbprints, thumbprint)
+	}
+}
+
+// settle marks the sides without the certificate as missing and the
+// certificates that differ from the reference as divergent
+func (r *MatrixRow) settle(sides []string) {
+	var reference []string
+	for _, side := range sides {
+		cell, ok := r.Cells[side]
+		if !ok {
+			r.Cells[side] = &MatrixCell{Status: matrixMissing}
+			continue
+		}
+		if reference == nil && len(cell.Thumbprints) > 0 {
+			reference = cell.Thumbprints
+			continue
+		}
+		// a certificate whose thumbprint could not be read is not judged
+		if len(cell.Thumbprints) > 0 && !intersects(cell.Thumbprints, reference) {
+			cell.Status = matrixDivergent
+		}
+	}
+}
+
+// consistent tells whether every side holds the same certificate
+func (r *MatrixRow) consistent() bool {
+	for _, cell := range r.Cells {
+		if cell.Status != matrixPresent {
+			return false
+		}
+	}
+	return true
+}
+
+func intersects(a []string, b []string) bool {
+	for _, x := range a {
+		if contains(b, x) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, each := range list {
+		if each == s {
+			return true
+		}
+	}
+	return false
+}
+
+// listMatrix compares the Venafi certificates with those of several CredHub
+// profiles. Rows are matched by the strategy of the -by* flags, and every
+// CredHub certificate is read to tell whether it diverges. The rows are
+// filtered once settled, so a status filter sees every side.
+func (c *CV) listMatrix(args *ListCommand, profiles []profileStore) (*Matrix, error) {
+	if args.Output != formatJSON {
+		output.Status("LISTING...\n")
+	}
+	f, err := args.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	certInfo, err := c.vcert.List(args.VenafiLimit, args.VenafiRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Matrix{Sides: []string{sideVenafi}, Rows: []*MatrixRow{}}
+	rows := map[string]*MatrixRow{}
+	row := func(key string) *MatrixRow {
+		r, ok := rows[key]
+		if !ok {
+			r = &MatrixRow{Key: key, Cells: map[string]*MatrixCell{}}
+			rows[key] = r
+			m.Rows = append(m.Rows, r)
+		}
+		return r
+	}
+
+	ct := args.strategy(nil)
+	for _, cert := range venafiCertificates(certInfo) {
+		row(ct.leftTransform(ct.leftGet(cert))).add(sideVenafi, cert.ID(), strings.ToUpper(fingerprintOf(cert)))
+	}
+
+	for _, p := range profiles {
+		m.Sides = append(m.Sides, p.name)
+		items, err := p.store.List()
+		if err != nil {
+			return nil, fmt.Errorf("could not list the certificates of %s: %s", p.name, err)
+		}
+		st := args.strategy(p.store.GetCertificate)
+		for _, cert := range credhubCertificates(credhubCertsBelow(items, args.CredhubRoot)) {
+			key := st.rightTransform(st.rightGet(cert))
+			tp := key
+			if _, ok := st.(*ThumbprintStrategy); !ok {
+				tp, err = credhubThumbprint(p.store, cert.ID())
+				if err != nil {
+					m.Warnings = append(m.Warnings, fmt.Sprintf("%s: %s", p.name, err))
+				}
+			}
+			row(key).add(p.name, cert.ID(), tp)
+		}
+		if e, ok := st.(processErrors); ok {
+			for _, each := range e.getErrors() {
+				m.Warnings = append(m.Warnings, fmt.Sprintf("%s: %s", p.name, each))
+			}
+		}
+	}
+
+	for _, r := range m.Rows {
+		r.settle(m.Sides)
+	}
+	if f != nil {
+		c.filterMatrix(f, m, profiles)
+	}
+	sort.SliceStable(m.Rows, func(i, j int) bool {
+		return m.Rows[i].Key < m.Rows[j].Key
+	})
+	if len(certInfo) == args.VenafiLimit {
+		m.Warnings = append(m.Warnings, "The Venafi limit was hit, consider increasing -vlimit to increase the number of allowed records.")
+	}
+
+	if args.Output == formatJSON {
+		return m, output.JSON(m)
+	}
+	printMatrix(m)
+	return m, nil
+}
+
+// credhubThumbprint reads a certificate of the store and returns its
+// thumbprint
+func credhubThumbprint(store secretstore.Store, name string) (string, error) {
+	cert, err := store.GetCertificate(name)
+	if err != nil {
+		return "", fmt.Errorf("could not get '%s' from %s: %s", name, store.Name(), err)
+	}
+	tp, err := thumbprintOf(cert.Certificate)
+	if err != nil {
+		return "", fmt.Errorf("could not calculate thumbprint of '%s': %s", name, err)
+	}
+	return tp, nil
+}
+
+// credhubCertsBelow returns the certificates whose name starts with root
+func credhubCertsBelow(items []secretstore.CertificateMetadata, root string) []secretstore.CertificateMetadata {
+	certs := []secretstore.CertificateMetadata{}
+	for _, cert := range items {
+		if strings.HasPrefix(cert.Name, root) {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+func printMatrix(m *Matrix) {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "KEY\t%s\n", strings.ToUpper(strings.Join(m.Sides, "\t")))
+	differ := 0
+	for _, r := range m.Rows {
+		if !r.consistent() {
+			differ++
+		}
+		cells := []string{}
+		for _, side := range m.Sides {
+			cell := r.Cells[side]
+			switch cell.Status {
+			case matrixMissing:
+				cells = append(cells, "-")
+			case matrixDivergent:
+				cells = append(cells, "DIVERGENT "+strings.Join(cell.Names, ", "))
+			default:
+				cells = append(cells, strings.Join(cell.Names, ", "))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\n", r.Key, strings.Join(cells, "\t"))
+	}
+	w.Flush()
+	output.Print("%s%s", output.Cyan, b.String())
+	output.Print("%d of %d certificates are missing or divergent on a side\n", differ, len(m.Rows))
+
+	for _, warning := range m.Warnings {
+		output.Errorf("%s\n", warning)
+	}
+}
@@ -15,6 +15,7 @@
 package output
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -61,6 +62,17 @@ func Print(format string, a ...interface{}) {
 	}
 }
 
+// JSON writes v as indented JSON without colors, so that the output can be
+// read by other programs
+func JSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	Print("%s\n", b)
+	return nil
+}
+
 // Status writes a log entry if the log_level is STATUS or higher
 func Status(format string, a ...interface{}) {
 	fmt.Print(Green)
@@ -111,3 +111,12 @@ func TestError(t *testing.T) {
 	output.Errorf(msg)
 	assert.Contains(t, strings.TrimSuffix(str.String(), "\n"), "Error", "It should emit a log when level is higher")
 }
+
+func TestJSON(t *testing.T) {
+	var str bytes.Buffer
+	log.SetOutput(&str)
+	config.LogLevel = config.STATUS
+	assert.Nil(t, output.JSON(map[string]string{"key": "value"}))
+	assert.Contains(t, str.String(), "\"key\": \"value\"", "It should write indented JSON")
+	assert.NotNil(t, output.JSON(func() {}), "It should raise an error for values JSON can't encode")
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/newcontext-oss/credhub-venafi/output"
+)
+
+// PendingFile is the name of the file in the cv config dir that records
+// operations which have not completed yet
+var PendingFile = "pending.json"
+
+const (
+	// OnFailureRollback undoes the work done on the first platform when the
+	// second platform fails
+	OnFailureRollback = "rollback"
+	// OnFailureResume records the failed step so that `cv resume` can
+	// complete it later
+	OnFailureResume = "resume"
+)
+
+const (
+	targetCredhub = "credhub"
+	targetVenafi  = "venafi"
+)
+
+// PendingOperation is an upload to one of the platforms that has not been
+// completed yet. If PickupID is set the certificate has not been issued by
+// Venafi yet and must be picked up before it can be uploaded.
+type PendingOperation struct {
+	ID          string    `json:"id"`
+	Target      string    `json:"target"`
+	Name        string    `json:"name"`
+	CA          string    `json:"ca,omitempty"`
+	Certificate string    `json:"certificate"`
+	PrivateKey  string    `json:"private_key,omitempty"`
+	PickupID    string    `json:"pickup_id,omitempty"`
+	CsrOrigin   string    `json:"csr_origin,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PendingStore reads and writes pending operations to a file
+type PendingStore struct {
+	Path string
+}
+
+// Load returns all pending operations. A missing file is not an error.
+func (p *PendingStore) Load() ([]PendingOperation, error) {
+	ops := []PendingOperation{}
+	b, err := ioutil.ReadFile(p.Path)
+	if os.IsNotExist(err) {
+		return ops, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read pending operations: %s", err)
+	}
+	err = json.Unmarshal(b, &ops)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse pending operations in %s: %s", p.Path, err)
+	}
+	return ops, nil
+}
+
+// Save replaces the stored pending operations. The file can hold private
+// keys so it is only readable by the owner, and it is removed once no
+// operation is left.
+func (p *PendingStore) Save(ops []PendingOperation) error {
+	if len(ops) == 0 {
+		err := os.Remove(p.Path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	err := os.MkdirAll(filepath.Dir(p.Path), 0700)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(ops, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p.Path, b, 0600)
+}
+
+// Add records a new pending operation and returns it with its ID set
+func (p *PendingStore) Add(op PendingOperation) (PendingOperation, error) {
+	ops, err := p.Load()
+	if err != nil {
+		return op, err
+	}
+	max := 0
+	for _, each := range ops {
+		if id, err := strconv.Atoi(each.ID); err == nil && id > max {
+			max = id
+		}
+	}
+	op.ID = strconv.Itoa(max + 1)
+	if op.CreatedAt.IsZero() {
+		op.CreatedAt = time.Now().UTC()
+	}
+	return op, p.Save(append(ops, op))
+}
+
+// Update replaces the stored pending operation with the same ID
+func (p *PendingStore) Update(op PendingOperation) error {
+	ops, err := p.Load()
+	if err != nil {
+		return err
+	}
+	for i := range ops {
+		if ops[i].ID == op.ID {
+			ops[i] = op
+			return p.Save(ops)
+		}
+	}
+	return fmt.Errorf("no pending operation with id %s", op.ID)
+}
+
+// Remove deletes the pending operation with the given ID
+func (p *PendingStore) Remove(id string) error {
+	ops, err := p.Load()
+	if err != nil {
+		return err
+	}
+	out := []PendingOperation{}
+	for _, each := range ops {
+		if each.ID != id {
+			out = append(out, each)
+		}
+	}
+	return p.Save(out)
+}
+
+// pendingStatus describes what is left to do for a pending operation
+func pendingStatus(op PendingOperation) string {
+	if op.PickupID != "" {
+		return "awaiting issuance of " + op.PickupID
+	}
+	if op.Error != "" {
+		return "upload failed: " + op.Error
+	}
+	return "upload pending"
+}
+
+func printPending(ops []PendingOperation) {
+	if len(ops) == 0 {
+		output.Status("NO PENDING OPERATIONS\n")
+		return
+	}
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tNAME\tTARGET\tCREATED\tSTATUS\n")
+	for _, op := range ops {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", op.ID, op.Name, op.Target, op.CreatedAt.Format(time.RFC3339), pendingStatus(op))
+	}
+	w.Flush()
+	output.Print("%s%s", output.Cyan, b.String())
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/newcontext-oss/credhub-venafi/output"
+	"github.com/newcontext-oss/credhub-venafi/vcclient"
+)
+
+// showPolicy prints the configuration and policy of a zone
+func (c *CV) showPolicy(zone string) (*endpoint.ZoneConfiguration, error) {
+	z, err := c.vcert.ReadZone(zone)
+	if err != nil {
+		return nil, err
+	}
+	printPolicy(vcclient.PrependPolicyRoot(zone), z)
+	return z, nil
+}
+
+func printPolicy(zone string, z *endpoint.ZoneConfiguration) {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ZONE\t%s\n", zone)
+	fmt.Fprintf(w, "COMMON NAME\t%s\n", allowed(z.SubjectCNRegexes))
+	fmt.Fprintf(w, "ORGANIZATION\t%s\n", withDefault(allowed(z.SubjectORegexes), z.Organization))
+	fmt.Fprintf(w, "ORGANIZATIONAL UNIT\t%s\n", withDefault(allowed(z.SubjectOURegexes), strings.Join(z.OrganizationalUnit, ", ")))
+	fmt.Fprintf(w, "COUNTRY\t%s\n", withDefault(allowed(z.SubjectCRegexes), z.Country))
+	fmt.Fprintf(w, "STATE\t%s\n", withDefault(allowed(z.SubjectSTRegexes), z.Province))
+	fmt.Fprintf(w, "LOCALITY\t%s\n", withDefault(allowed(z.SubjectLRegexes), z.Locality))
+	fmt.Fprintf(w, "SAN TYPES\t%s\n", strings.Join(vcclient.SANTypesAllowed(z), ", "))
+	fmt.Fprintf(w, "WILDCARDS\t%t\n", z.AllowWildcards)
+	fmt.Fprintf(w, "KEY REUSE\t%t\n", z.AllowKeyReuse)
+	keys := z.AllowedKeyConfigurations
+	if len(keys) == 0 {
+		fmt.Fprintf(w, "KEYS\tany\n")
+	}
+	for _, k := range keys {
+		fmt.Fprintf(w, "KEYS\t%s\n", describeKeys(k))
+	}
+	w.Flush()
+	output.Print("%s%s", output.Cyan, b.String())
+}
+
+// allowed describes a list of policy regular expressions
+func allowed(regexes []string) string {
+	if len(regexes) == 0 {
+		return "none"
+	}
+	values := []string{}
+	for _, r := range regexes {
+		if r == ".*" {
+			return "any"
+		}
+		values = append(values, r)
+	}
+	return strings.Join(values, ", ")
+}
+
+func withDefault(allowed string, value string) string {
+	if value == "" {
+		return allowed
+	}
+	return fmt.Sprintf("%s (default %s)", allowed, value)
+}
+
+func describeKeys(k endpoint.AllowedKeyConfiguration) string {
+	switch k.KeyType {
+	case certificate.KeyTypeRSA:
+		sizes := []string{}
+		for _, s := range k.KeySizes {
+			sizes = append(sizes, strconv.Itoa(s))
+		}
+		return "RSA " + strings.Join(sizes, ", ")
+	case certificate.KeyTypeECDSA:
+		curves := []string{}
+		for _, c := range k.KeyCurves {
+			curves = append(curves, c.String())
+		}
+		return "ECDSA " + strings.Join(curves, ", ")
+	}
+	return k.KeyType.String()
+}
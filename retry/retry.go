@@ -0,0 +1,297 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retry repeats calls to CredHub and Venafi that failed with a
+// transient error, such as a 5xx status, a reset connection or throttling.
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/newcontext-oss/credhub-venafi/output"
+)
+
+// Kind tells how safe it is to repeat a call
+type Kind int
+
+const (
+	// Read calls have no side effects and are retried on every transient
+	// error
+	Read Kind = iota
+	// Idempotent calls have the same effect when repeated and are retried
+	// like reads
+	Idempotent
+	// Write calls are only retried when the server has certainly not
+	// processed them, i.e. the connection was refused or the server
+	// answered 429 or 503
+	Write
+)
+
+// Policy decides how often failed calls are retried
+type Policy struct {
+	// Attempts is the number of tries of one call, 1 disables retries
+	Attempts int
+	// BaseDelay is the wait before the first retry. It doubles with every
+	// retry up to MaxDelay, and a random part of it is used.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Budget is the number of retries allowed in one run across all calls
+	Budget int
+	// BreakAfter is the number of calls in a row that may fail with a
+	// transient error before further calls fail right away. 0 never breaks.
+	BreakAfter int
+}
+
+// DefaultPolicy is used for the settings missing in the config file
+var DefaultPolicy = Policy{
+	Attempts:   3,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   10 * time.Second,
+	Budget:     100,
+	BreakAfter: 5,
+}
+
+// ErrCircuitOpen is returned without calling the server once BreakAfter calls
+// in a row have failed
+var ErrCircuitOpen = errors.New("too many failed calls in a row")
+
+// Retrier runs calls to one server with a policy. The budget and circuit
+// breaker are shared by all calls of the retrier.
+type Retrier struct {
+	Name   string
+	Policy Policy
+	// Sleep waits between attempts, it can be replaced in tests
+	Sleep func(time.Duration)
+
+	mu       sync.Mutex
+	retries  int
+	failures int
+}
+
+// New returns a retrier for the server called name
+func New(name string, policy Policy) *Retrier {
+	return &Retrier{Name: name, Policy: policy, Sleep: time.Sleep}
+}
+
+// Do calls fn until it succeeds, fails with an error that should not be
+// retried for its kind, or the attempts or budget are used up
+func (r *Retrier) Do(call string, kind Kind, fn func() error) error {
+	if r.open() {
+		return fmt.Errorf("%s: not calling %s: %s", call, r.Name, ErrCircuitOpen)
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil {
+			r.record(false)
+			return nil
+		}
+		if !retryable(kind, err) {
+			r.record(Transient(err))
+			return err
+		}
+		if attempt >= r.Policy.Attempts || !r.spend() {
+			break
+		}
+		d := r.delay(attempt)
+		output.Info("%s on %s failed, retrying in %s: %s\n", call, r.Name, d, err)
+		r.Sleep(d)
+	}
+	r.record(true)
+	return err
+}
+
+// Retries returns the number of retries spent from the budget
+func (r *Retrier) Retries() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.retries
+}
+
+func (r *Retrier) open() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Policy.BreakAfter > 0 && r.failures >= r.Policy.BreakAfter
+}
+
+func (r *Retrier) record(failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if failed {
+		r.failures++
+	} else {
+		r.failures = 0
+	}
+}
+
+func (r *Retrier) spend() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.retries >= r.Policy.Budget {
+		output.Info("retry budget of %d for %s is used up\n", r.Policy.Budget, r.Name)
+		return false
+	}
+	r.retries++
+	return true
+}
+
+// delay returns a random wait of up to BaseDelay*2^(attempt-1), capped at
+// MaxDelay, so that clients failing together do not retry together
+func (r *Retrier) delay(attempt int) time.Duration {
+	d := r.Policy.BaseDelay
+	for i := 1; i < attempt && d < r.Policy.MaxDelay; i++ {
+		d *= 2
+	}
+	if r.Policy.MaxDelay > 0 && d > r.Policy.MaxDelay {
+		d = r.Policy.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func retryable(kind Kind, err error) bool {
+	if kind == Write {
+		return Unprocessed(err)
+	}
+	return Transient(err)
+}
+
+// StatusError is a response with a status that is worth retrying. It is
+// returned by Transport, because client libraries do not always keep the
+// status of a failed response.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return e.Status
+	}
+	return e.Status + ": " + e.Body
+}
+
+// Transport turns 429 and 5xx responses into a StatusError
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil || !retryStatus(resp.StatusCode) {
+		return resp, err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(b))}
+}
+
+func retryStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// unprocessedStatus are the statuses with which a server refuses to handle a
+// request
+func unprocessedStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// messages of transient errors that client libraries only pass on as text
+var transientMessages = []string{
+	"connection reset",
+	"broken pipe",
+	"i/o timeout",
+	"timeout exceeded",
+	"tls handshake timeout",
+	"unexpected eof",
+	": eof",
+	strings.ToLower(http.StatusText(http.StatusInternalServerError)),
+	strings.ToLower(http.StatusText(http.StatusBadGateway)),
+	strings.ToLower(http.StatusText(http.StatusGatewayTimeout)),
+}
+
+var unprocessedMessages = []string{
+	"connection refused",
+	strings.ToLower(http.StatusText(http.StatusTooManyRequests)),
+	strings.ToLower(http.StatusText(http.StatusServiceUnavailable)),
+}
+
+// Transient tells whether a call that failed with err may succeed when it is
+// repeated
+func Transient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if Unprocessed(err) {
+		return true
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return retryStatus(se.StatusCode)
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	return containsAny(err.Error(), transientMessages)
+}
+
+// Unprocessed tells whether a call that failed with err has certainly not
+// been processed by the server, so that it can be repeated even if it
+// changes data
+func Unprocessed(err error) bool {
+	if err == nil {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return unprocessedStatus(se.StatusCode)
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	return containsAny(err.Error(), unprocessedMessages)
+}
+
+func containsAny(s string, subs []string) bool {
+	s = strings.ToLower(s)
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
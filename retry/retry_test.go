@@ -0,0 +1,145 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/newcontext-oss/credhub-venafi/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRetrier(p retry.Policy) (*retry.Retrier, *[]time.Duration) {
+	waits := []time.Duration{}
+	r := retry.New("test", p)
+	r.Sleep = func(d time.Duration) { waits = append(waits, d) }
+	return r, &waits
+}
+
+// failing returns a call that fails with err the given number of times
+func failing(times int, err error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= times {
+			return err
+		}
+		return nil
+	}, &calls
+}
+
+func TestClassification(t *testing.T) {
+	assert.True(t, retry.Transient(&retry.StatusError{StatusCode: 500, Status: "500 Internal Server Error"}))
+	assert.False(t, retry.Unprocessed(&retry.StatusError{StatusCode: 500, Status: "500 Internal Server Error"}))
+	assert.True(t, retry.Unprocessed(&retry.StatusError{StatusCode: 429, Status: "429 Too Many Requests"}))
+	assert.True(t, retry.Transient(errors.New("Unexpected status code on TPP Certificate Retrieval. Status: 503 Service Unavailable")), "It should recognize statuses passed on as text")
+	assert.True(t, retry.Unprocessed(errors.New("dial tcp 127.0.0.1:443: connect: connection refused")))
+	assert.True(t, retry.Transient(errors.New("read tcp 127.0.0.1:443: read: connection reset by peer")))
+	assert.False(t, retry.Transient(errors.New("unexpected status from vedsdk/config/delete: 400 Bad Request")))
+	assert.False(t, retry.Transient(nil))
+}
+
+func TestDoRetriesReads(t *testing.T) {
+	r, waits := newRetrier(retry.Policy{Attempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second, Budget: 10})
+	fn, calls := failing(2, errors.New("502 Bad Gateway"))
+
+	assert.Nil(t, r.Do("read", retry.Read, fn), "It should succeed after transient errors")
+	assert.Equal(t, 3, *calls)
+	assert.Len(t, *waits, 2)
+	assert.True(t, (*waits)[0] >= 500*time.Millisecond && (*waits)[0] <= time.Second, "It should wait up to the base delay")
+	assert.True(t, (*waits)[1] >= time.Second && (*waits)[1] <= 2*time.Second, "It should double the delay")
+
+	fn, calls = failing(5, errors.New("502 Bad Gateway"))
+	assert.NotNil(t, r.Do("read", retry.Read, fn), "It should give up after the attempts")
+	assert.Equal(t, 3, *calls)
+
+	fn, calls = failing(1, errors.New("404 Not Found"))
+	assert.NotNil(t, r.Do("read", retry.Read, fn), "It should not retry other errors")
+	assert.Equal(t, 1, *calls)
+}
+
+func TestDoRetriesWritesOnlyWhenUnprocessed(t *testing.T) {
+	r, _ := newRetrier(retry.Policy{Attempts: 3, Budget: 10})
+
+	fn, calls := failing(1, errors.New("500 Internal Server Error"))
+	assert.NotNil(t, r.Do("write", retry.Write, fn), "It should not repeat a write the server may have processed")
+	assert.Equal(t, 1, *calls)
+
+	fn, calls = failing(1, errors.New("503 Service Unavailable"))
+	assert.Nil(t, r.Do("write", retry.Write, fn), "It should repeat a write the server refused")
+	assert.Equal(t, 2, *calls)
+
+	fn, calls = failing(1, errors.New("500 Internal Server Error"))
+	assert.Nil(t, r.Do("write", retry.Idempotent, fn), "It should repeat an idempotent write")
+	assert.Equal(t, 2, *calls)
+}
+
+func TestDoBudget(t *testing.T) {
+	r, _ := newRetrier(retry.Policy{Attempts: 5, Budget: 3})
+
+	fn, calls := failing(10, errors.New("i/o timeout"))
+	assert.NotNil(t, r.Do("read", retry.Read, fn))
+	assert.Equal(t, 4, *calls, "It should stop retrying when the budget is used up")
+
+	fn, calls = failing(1, errors.New("i/o timeout"))
+	assert.NotNil(t, r.Do("read", retry.Read, fn))
+	assert.Equal(t, 1, *calls, "The budget should be shared by all calls")
+	assert.Equal(t, 3, r.Retries())
+}
+
+func TestDoCircuitBreaker(t *testing.T) {
+	r, _ := newRetrier(retry.Policy{Attempts: 1, BreakAfter: 2})
+
+	fn, calls := failing(10, errors.New("502 Bad Gateway"))
+	r.Do("read", retry.Read, fn)
+	r.Do("read", retry.Read, fn)
+	err := r.Do("read", retry.Read, fn)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), retry.ErrCircuitOpen.Error())
+	assert.Equal(t, 2, *calls, "It should not call the server once the circuit is open")
+
+	r, _ = newRetrier(retry.Policy{Attempts: 1, BreakAfter: 2})
+	r.Do("read", retry.Read, func() error { return errors.New("502 Bad Gateway") })
+	r.Do("read", retry.Read, func() error { return nil })
+	r.Do("read", retry.Read, func() error { return errors.New("502 Bad Gateway") })
+	assert.Nil(t, r.Do("read", retry.Read, func() error { return nil }), "A success should close the circuit")
+}
+
+func TestTransport(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, "maintenance")
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &retry.Transport{}}
+
+	_, err := client.Get(server.URL)
+	var se *retry.StatusError
+	assert.True(t, errors.As(err, &se), "It should return a 503 as an error")
+	assert.Equal(t, "maintenance", se.Body)
+	assert.True(t, retry.Unprocessed(err))
+
+	status = http.StatusNotFound
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err, "It should pass on other responses")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretstore
+
+import "github.com/newcontext-oss/credhub-venafi/retry"
+
+// RetryProxy retries the calls of a store that fail with a transient error
+type RetryProxy struct {
+	Proxy   Store
+	Retrier *retry.Retrier
+}
+
+// Name returns the name of the wrapped store
+func (r *RetryProxy) Name() string {
+	return r.Proxy.Name()
+}
+
+// GenerateCertificate generates a certificate in the store. It is only repeated
+// after an unprocessed attempt unless an existing credential is kept.
+func (r *RetryProxy) GenerateCertificate(name string, parameters GenerateParameters, overwrite bool) (Certificate, error) {
+	kind := retry.Write
+	if !overwrite {
+		kind = retry.Idempotent
+	}
+	var cert Certificate
+	err := r.Retrier.Do("generate "+name, kind, func() error {
+		var err error
+		cert, err = r.Proxy.GenerateCertificate(name, parameters, overwrite)
+		return err
+	})
+	return cert, err
+}
+
+// PutCertificate uploads a certificate to the store. Setting the same value
+// again only adds an identical version, so it is always retried.
+func (r *RetryProxy) PutCertificate(certName string, ca string, certificate string, privateKey string) error {
+	return r.Retrier.Do("store "+certName, retry.Idempotent, func() error {
+		return r.Proxy.PutCertificate(certName, ca, certificate, privateKey)
+	})
+}
+
+// DeleteCert deletes a certificate from the store
+func (r *RetryProxy) DeleteCert(name string) error {
+	return r.Retrier.Do("delete "+name, retry.Write, func() error {
+		return r.Proxy.DeleteCert(name)
+	})
+}
+
+// List lists certificates on the store
+func (r *RetryProxy) List() ([]CertificateMetadata, error) {
+	var certs []CertificateMetadata
+	err := r.Retrier.Do("list", retry.Read, func() error {
+		var err error
+		certs, err = r.Proxy.List()
+		return err
+	})
+	return certs, err
+}
+
+// GetCertificate downloads a certificate from the store
+func (r *RetryProxy) GetCertificate(name string) (Certificate, error) {
+	var cert Certificate
+	err := r.Retrier.Do("get "+name, retry.Read, func() error {
+		var err error
+		cert, err = r.Proxy.GetCertificate(name)
+		return err
+	})
+	return cert, err
+}
+
+// GetCertificateVersions returns all versions of a certificate, newest first
+func (r *RetryProxy) GetCertificateVersions(name string) ([]Certificate, error) {
+	var certs []Certificate
+	err := r.Retrier.Do("get versions of "+name, retry.Read, func() error {
+		var err error
+		certs, err = r.Proxy.GetCertificateVersions(name)
+		return err
+	})
+	return certs, err
+}
+
+// GetCertificateMetadata returns the metadata of a certificate
+func (r *RetryProxy) GetCertificateMetadata(name string) (CertificateMetadata, error) {
+	var md CertificateMetadata
+	err := r.Retrier.Do("get metadata of "+name, retry.Read, func() error {
+		var err error
+		md, err = r.Proxy.GetCertificateMetadata(name)
+		return err
+	})
+	return md, err
+}
+
+// ListKeys lists rsa and ssh credentials on the store
+func (r *RetryProxy) ListKeys(path string, types []string) ([]Key, error) {
+	var keys []Key
+	err := r.Retrier.Do("list keys "+path, retry.Read, func() error {
+		var err error
+		keys, err = r.Proxy.ListKeys(path, types)
+		return err
+	})
+	return keys, err
+}
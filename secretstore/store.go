@@ -0,0 +1,104 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package secretstore defines the secret store cv keeps in sync with Venafi.
+// CredHub and Vault implement it and translate their own types and errors
+// into the ones of this package.
+package secretstore
+
+import "errors"
+
+// ErrNotFound is wrapped by the error a store returns for a certificate it
+// does not hold. Check for it with errors.Is.
+var ErrNotFound = errors.New("certificate not found")
+
+// Store is a secret store holding versioned certificates
+type Store interface {
+	// Name is the name of the platform shown to the user, e.g. CredHub
+	Name() string
+	List() ([]CertificateMetadata, error)
+	GetCertificate(name string) (Certificate, error)
+	GetCertificateVersions(name string) ([]Certificate, error)
+	GetCertificateMetadata(name string) (CertificateMetadata, error)
+	PutCertificate(certName string, ca string, certificate string, privateKey string) error
+	DeleteCert(name string) error
+	// GenerateCertificate generates a certificate and stores it. Without
+	// overwrite an existing certificate is returned unchanged.
+	GenerateCertificate(name string, parameters GenerateParameters, overwrite bool) (Certificate, error)
+	ListKeys(path string, types []string) ([]Key, error)
+}
+
+// Certificate is a version of a certificate with its PEM values
+type Certificate struct {
+	// ID identifies the version
+	ID        string
+	Name      string
+	CreatedAt string
+	CA        string
+	// Certificate is the PEM certificate and PrivateKey its PEM key, which
+	// is empty if the store holds none
+	Certificate string
+	PrivateKey  string
+}
+
+// CertificateMetadata names a certificate and describes its versions
+type CertificateMetadata struct {
+	ID   string
+	Name string
+	// SignedBy is the name of the stored CA that signed the certificate, if
+	// the store tracks it
+	SignedBy string
+	Versions []CertificateVersion
+}
+
+// CertificateVersion describes a version of a certificate without its
+// values
+type CertificateVersion struct {
+	ID                   string
+	ExpiryDate           string
+	Transitional         bool
+	CertificateAuthority bool
+	SelfSigned           bool
+}
+
+// GenerateParameters are the attributes of a certificate to generate. The
+// duration is in days.
+type GenerateParameters struct {
+	CommonName       string
+	AlternativeNames []string
+	Organization     string
+	OrganizationUnit string
+	Locality         string
+	State            string
+	Country          string
+	KeyLength        int
+	Duration         int
+	KeyUsage         []string
+	ExtendedKeyUsage []string
+	// Ca is the name of the stored CA that signs the certificate
+	Ca       string
+	SelfSign bool
+	IsCA     bool
+}
+
+// Key is an rsa or ssh key pair held by a store
+type Key struct {
+	Name      string
+	Type      string
+	PublicKey string
+	// Fingerprint is the SHA256 fingerprint of the public key in the
+	// "SHA256:<base64>" format of ssh-keygen, so rsa and ssh keys compare
+	// with each other and with the Venafi SSH key inventory
+	Fingerprint string
+}
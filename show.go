@@ -0,0 +1,348 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/newcontext-oss/credhub-venafi/output"
+	"github.com/newcontext-oss/credhub-venafi/vcclient"
+)
+
+// certRecord is a certificate as stored on one platform
+type certRecord struct {
+	platform string
+	name     string
+	cert     *x509.Certificate
+	chain    []*x509.Certificate
+}
+
+// identical tells whether both records hold the same certificate bytes
+func (r certRecord) identical(other certRecord) bool {
+	return bytes.Equal(r.cert.Raw, other.cert.Raw)
+}
+
+// sameChain tells whether both records hold the same chain bytes
+func (r certRecord) sameChain(other certRecord) bool {
+	if len(r.chain) != len(other.chain) {
+		return false
+	}
+	for i := range r.chain {
+		if !bytes.Equal(r.chain[i].Raw, other.chain[i].Raw) {
+			return false
+		}
+	}
+	return true
+}
+
+// showResult is a certificate and its counterparts on the other platform
+type showResult struct {
+	record       certRecord
+	counterparts []certRecord
+}
+
+// show prints the certificate selected by CredHub name or Venafi DN together
+// with its counterparts on the other platform
+func (c *CV) show(args *ShowCommand) (*showResult, error) {
+	var result *showResult
+	var err error
+	if args.Name != "" {
+		result, err = c.showCredhub(args.Name, args.Zone)
+	} else {
+		result, err = c.showVenafi(args.VenafiDN)
+	}
+	if err != nil {
+		return nil, err
+	}
+	printShow(result)
+	return result, nil
+}
+
+// showCredhub finds the Venafi objects holding the certificate of a CredHub
+// credential. If there are none, the object of the same name in the zone is
+// shown, as it holds a different version of the certificate.
+func (c *CV) showCredhub(name string, zone string) (*showResult, error) {
+	cert, err := c.store.GetCertificate(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not get '%s' from %s: %s", name, c.store.Name(), err)
+	}
+	record, err := parseRecord(targetCredhub, name, cert.Certificate, []string{cert.CA})
+	if err != nil {
+		return nil, err
+	}
+	result := &showResult{record: record}
+
+	dns, err := c.vcert.FindByThumbprint(fingerprint(sha1.New(), record.cert))
+	if err != nil {
+		return nil, err
+	}
+	if len(dns) == 0 && zone != "" {
+		dns = []string{vcclient.PrependPolicyRoot(zone) + "\\" + path.Base(name)}
+	}
+	for _, dn := range dns {
+		pcc, err := c.vcert.RetrieveCertificateByDN(dn)
+		if err != nil {
+			output.Info("no certificate in Venafi object %s: %s\n", dn, err)
+			continue
+		}
+		counterpart, err := parseRecord(targetVenafi, dn, pcc.Certificate, pcc.Chain)
+		if err != nil {
+			return nil, err
+		}
+		result.counterparts = append(result.counterparts, counterpart)
+	}
+	return result, nil
+}
+
+// showVenafi finds the CredHub credentials holding the certificate of a
+// Venafi object. If there are none, the credentials named like the object are
+// shown, as they hold a different version of the certificate.
+func (c *CV) showVenafi(dn string) (*showResult, error) {
+	pcc, err := c.vcert.RetrieveCertificateByDN(dn)
+	if err != nil {
+		return nil, fmt.Errorf("could not find '%s' in Venafi: %s", dn, err)
+	}
+	record, err := parseRecord(targetVenafi, dn, pcc.Certificate, pcc.Chain)
+	if err != nil {
+		return nil, err
+	}
+	result := &showResult{record: record}
+
+	certs, err := c.store.List()
+	if err != nil {
+		return nil, err
+	}
+	objectName := dn[strings.LastIndex(dn, "\\")+1:]
+	sameName := []certRecord{}
+	for _, md := range certs {
+		cert, err := c.store.GetCertificate(md.Name)
+		if err != nil {
+			output.Errorf("could not get '%s' from %s: %s\n", md.Name, c.store.Name(), err)
+			continue
+		}
+		counterpart, err := parseRecord(targetCredhub, md.Name, cert.Certificate, []string{cert.CA})
+		if err != nil {
+			output.Errorf("%s\n", err)
+			continue
+		}
+		switch {
+		case counterpart.identical(record):
+			result.counterparts = append(result.counterparts, counterpart)
+		case strings.EqualFold(path.Base(md.Name), objectName):
+			sameName = append(sameName, counterpart)
+		}
+	}
+	if len(result.counterparts) == 0 {
+		result.counterparts = sameName
+	}
+	return result, nil
+}
+
+// parseRecord parses a PEM certificate and the PEM certificates of its chain.
+// Each chain entry may hold several certificates.
+func parseRecord(platform string, name string, certPEM string, chainPEMs []string) (certRecord, error) {
+	certs, err := parsePEMCertificates(certPEM)
+	if err != nil || len(certs) == 0 {
+		return certRecord{}, fmt.Errorf("could not parse the certificate of '%s': %v", name, err)
+	}
+	record := certRecord{platform: platform, name: name, cert: certs[0]}
+	for _, chainPEM := range chainPEMs {
+		chain, err := parsePEMCertificates(chainPEM)
+		if err != nil {
+			return certRecord{}, fmt.Errorf("could not parse the chain of '%s': %s", name, err)
+		}
+		record.chain = append(record.chain, chain...)
+	}
+	return record, nil
+}
+
+func parsePEMCertificates(s string) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	rest := []byte(s)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func printShow(result *showResult) {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	printRecord(w, result.record)
+	other := targetVenafi
+	if result.record.platform == targetVenafi {
+		other = targetCredhub
+	}
+	if len(result.counterparts) == 0 {
+		fmt.Fprintf(w, "\nNOT IN %s\n", strings.ToUpper(other))
+	}
+	for _, counterpart := range result.counterparts {
+		fmt.Fprintf(w, "\n")
+		if counterpart.identical(result.record) {
+			fmt.Fprintf(w, "%s\t%s\n", strings.ToUpper(counterpart.platform), counterpart.name)
+		} else {
+			printRecord(w, counterpart)
+		}
+		fmt.Fprintf(w, "IDENTICAL CERTIFICATE\t%s\n", yesNo(counterpart.identical(result.record)))
+		fmt.Fprintf(w, "IDENTICAL CHAIN\t%s\n", yesNo(counterpart.sameChain(result.record)))
+	}
+	w.Flush()
+	output.Print("%s%s", output.Cyan, b.String())
+}
+
+func printRecord(w *tabwriter.Writer, r certRecord) {
+	c := r.cert
+	fmt.Fprintf(w, "%s\t%s\n", strings.ToUpper(r.platform), r.name)
+	fmt.Fprintf(w, "SUBJECT\t%s\n", c.Subject)
+	fmt.Fprintf(w, "SANS\t%s\n", orNone(strings.Join(sans(c), ", ")))
+	fmt.Fprintf(w, "ISSUER\t%s\n", c.Issuer)
+	fmt.Fprintf(w, "SERIAL\t%s\n", strings.ToLower(c.SerialNumber.Text(16)))
+	fmt.Fprintf(w, "VALID FROM\t%s\n", c.NotBefore.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "VALID UNTIL\t%s\n", c.NotAfter.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "KEY\t%s\n", describePublicKey(c))
+	fmt.Fprintf(w, "KEY USAGE\t%s\n", orNone(strings.Join(keyUsages(c), ", ")))
+	fmt.Fprintf(w, "EXT KEY USAGE\t%s\n", orNone(strings.Join(extKeyUsages(c), ", ")))
+	fmt.Fprintf(w, "SHA-1\t%s\n", fingerprint(sha1.New(), c))
+	fmt.Fprintf(w, "SHA-256\t%s\n", fingerprint(sha256.New(), c))
+	if len(r.chain) == 0 {
+		fmt.Fprintf(w, "CHAIN\tnone\n")
+	}
+	for _, ca := range r.chain {
+		fmt.Fprintf(w, "CHAIN\t%s (until %s)\n", ca.Subject, ca.NotAfter.UTC().Format(time.RFC3339))
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// fingerprint returns the hash of the certificate in the upper case hex
+// format used by TPP
+func fingerprint(h hash.Hash, c *x509.Certificate) string {
+	h.Write(c.Raw)
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
+
+func sans(c *x509.Certificate) []string {
+	names := []string{}
+	for _, name := range c.DNSNames {
+		names = append(names, "DNS:"+name)
+	}
+	for _, ip := range c.IPAddresses {
+		names = append(names, "IP:"+ip.String())
+	}
+	for _, email := range c.EmailAddresses {
+		names = append(names, "email:"+email)
+	}
+	for _, uri := range c.URIs {
+		names = append(names, "URI:"+uri.String())
+	}
+	return names
+}
+
+func describePublicKey(c *x509.Certificate) string {
+	switch k := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return c.PublicKeyAlgorithm.String()
+}
+
+// keyUsageNames are the names CredHub uses for key usages
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digital_signature"},
+	{x509.KeyUsageContentCommitment, "non_repudiation"},
+	{x509.KeyUsageKeyEncipherment, "key_encipherment"},
+	{x509.KeyUsageDataEncipherment, "data_encipherment"},
+	{x509.KeyUsageKeyAgreement, "key_agreement"},
+	{x509.KeyUsageCertSign, "key_cert_sign"},
+	{x509.KeyUsageCRLSign, "crl_sign"},
+	{x509.KeyUsageEncipherOnly, "encipher_only"},
+	{x509.KeyUsageDecipherOnly, "decipher_only"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "server_auth",
+	x509.ExtKeyUsageClientAuth:      "client_auth",
+	x509.ExtKeyUsageCodeSigning:     "code_signing",
+	x509.ExtKeyUsageEmailProtection: "email_protection",
+	x509.ExtKeyUsageTimeStamping:    "timestamping",
+	x509.ExtKeyUsageOCSPSigning:     "ocsp_signing",
+}
+
+func keyUsages(c *x509.Certificate) []string {
+	names := []string{}
+	for _, u := range keyUsageNames {
+		if c.KeyUsage&u.usage != 0 {
+			names = append(names, u.name)
+		}
+	}
+	return names
+}
+
+func extKeyUsages(c *x509.Certificate) []string {
+	names := []string{}
+	for _, u := range c.ExtKeyUsage {
+		name, ok := extKeyUsageNames[u]
+		if !ok {
+			name = fmt.Sprintf("unknown(%d)", u)
+		}
+		names = append(names, name)
+	}
+	return names
+}
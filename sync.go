@@ -0,0 +1,279 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/newcontext-oss/credhub-venafi/config"
+	"github.com/newcontext-oss/credhub-venafi/k8sclient"
+	"github.com/newcontext-oss/credhub-venafi/output"
+	"github.com/newcontext-oss/credhub-venafi/vcclient"
+)
+
+// annotations and labels of the Secrets cv manages
+const (
+	annotationVenafiDN   = "cv.newcontext.com/venafi-dn"
+	annotationThumbprint = "cv.newcontext.com/thumbprint"
+	labelManagedBy       = "app.kubernetes.io/managed-by"
+	managedByCV          = "cv"
+)
+
+// actions of a sync
+const (
+	syncCreate    = "create"
+	syncUpdate    = "update"
+	syncUnchanged = "unchanged"
+	syncSkipped   = "skipped"
+	syncFailed    = "FAIL"
+)
+
+// maxSecretName is the longest name Kubernetes accepts for a Secret
+const maxSecretName = 253
+
+// invalidNameChars matches the runs of characters not allowed in a DNS-1123
+// subdomain
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// syncItem is the result of syncing one Venafi certificate
+type syncItem struct {
+	dn        string
+	namespace string
+	name      string
+	action    string
+	problems  []string
+}
+
+func (i syncItem) failed() bool {
+	return len(i.problems) > 0
+}
+
+func (i *syncItem) fail(action string, format string, args ...interface{}) {
+	i.action = action
+	i.problems = append(i.problems, fmt.Sprintf(format, args...))
+}
+
+// secretMapper decides the Secret a Venafi certificate is synced to
+type secretMapper struct {
+	// root is the policy folder that is synced
+	root      string
+	namespace string
+	mappings  []config.KubernetesMapping
+}
+
+// secretFor returns the namespace and name of the Secret for a certificate
+// DN. The mapping with the longest matching policy applies, certificates no
+// mapping applies to go to the default namespace with their path below the
+// synced folder.
+func (m secretMapper) secretFor(dn string) (string, string, error) {
+	namespace, prefix, folder := m.namespace, "", m.root
+	matched := ""
+	for _, mapping := range m.mappings {
+		policy := vcclient.PrependPolicyRoot(mapping.Policy)
+		if len(policy) > len(matched) && hasPolicyPrefix(dn, policy) {
+			matched = policy
+			namespace, prefix, folder = mapping.Namespace, mapping.Prefix, policy
+		}
+	}
+	if namespace == "" {
+		return "", "", fmt.Errorf("no namespace is configured for %s", dn)
+	}
+
+	path := dn
+	if hasPolicyPrefix(dn, folder) {
+		path = dn[len(folder):]
+	}
+	name := secretName(prefix + strings.Replace(strings.Trim(path, "\\"), "\\", "-", -1))
+	if name == "" {
+		return "", "", fmt.Errorf("could not derive a Secret name from %s", dn)
+	}
+	return namespace, name, nil
+}
+
+// hasPolicyPrefix tells whether dn is below the policy folder. TPP compares
+// DNs without regard to case.
+func hasPolicyPrefix(dn string, policy string) bool {
+	policy = strings.TrimSuffix(policy, "\\") + "\\"
+	return len(dn) > len(policy) && strings.EqualFold(dn[:len(policy)], policy)
+}
+
+// secretName turns a name into a valid Secret name, a lowercase DNS-1123
+// subdomain
+func secretName(name string) string {
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	if len(name) > maxSecretName {
+		name = name[:maxSecretName]
+	}
+	return strings.Trim(name, "-.")
+}
+
+// sync copies the Venafi certificates below a policy folder, with their
+// private keys, to kubernetes.io/tls Secrets. Secrets are annotated with the
+// DN and thumbprint of their certificate and only updated when the
+// thumbprint changed. Secrets cv did not create are left alone, and so are
+// certificates whose private key TPP does not hold.
+func (c *CV) sync(args *SyncCommand) ([]syncItem, error) {
+	output.Status("SYNCING...\n")
+
+	certs, err := c.vcert.List(args.VenafiLimit, args.VenafiRoot)
+	if err != nil {
+		return nil, err
+	}
+	mapper := secretMapper{root: args.VenafiRoot, namespace: args.Namespace, mappings: args.Mappings}
+
+	items := []syncItem{}
+	targets := map[string]string{}
+	failed := 0
+	for _, cert := range certs {
+		item := c.syncCert(cert, mapper, targets, args.DryRun)
+		if item.failed() {
+			failed++
+		}
+		items = append(items, item)
+	}
+
+	printSync(items)
+	if len(certs) == args.VenafiLimit {
+		output.Errorf("The Venafi limit was hit, consider increasing -vlimit to increase the number of allowed records.\n")
+	}
+	if failed > 0 {
+		return items, fmt.Errorf("%d of %d certificates could not be synced", failed, len(items))
+	}
+	return items, nil
+}
+
+// syncCert syncs one certificate. targets records the DN each Secret was
+// claimed by, so that two certificates mapped to the same Secret are noticed.
+func (c *CV) syncCert(cert certificate.CertificateInfo, mapper secretMapper, targets map[string]string, dryRun bool) syncItem {
+	item := syncItem{dn: cert.ID}
+	namespace, name, err := mapper.secretFor(cert.ID)
+	if err != nil {
+		item.fail(syncSkipped, "%s", err)
+		return item
+	}
+	item.namespace, item.name = namespace, name
+	key := namespace + "/" + name
+	if other, ok := targets[key]; ok {
+		item.fail(syncSkipped, "the Secret %s is already synced from %s", key, other)
+		return item
+	}
+	targets[key] = cert.ID
+
+	existing, err := c.kubernetes.GetSecret(namespace, name)
+	if err != nil {
+		item.fail(syncFailed, "could not read the Secret: %s", err)
+		return item
+	}
+	if existing != nil {
+		switch {
+		case existing.Labels[labelManagedBy] != managedByCV:
+			item.fail(syncSkipped, "the Secret %s exists and is not managed by cv", key)
+			return item
+		case !strings.EqualFold(existing.Annotations[annotationVenafiDN], cert.ID):
+			item.fail(syncSkipped, "the Secret %s is synced from %s", key, existing.Annotations[annotationVenafiDN])
+			return item
+		case strings.EqualFold(existing.Annotations[annotationThumbprint], cert.Thumbprint):
+			item.action = syncUnchanged
+			return item
+		}
+	}
+
+	// only keys held by TPP can be exported, others stay with whoever
+	// generated the CSR
+	held, err := c.vcert.HoldsPrivateKey(cert.ID)
+	if err != nil {
+		item.fail(syncFailed, "%s", err)
+		return item
+	}
+	if !held {
+		item.fail(syncSkipped, "Venafi does not hold the private key, the certificate was enrolled with a CSR generated outside of Venafi or imported without its key")
+		return item
+	}
+
+	item.action = syncCreate
+	if existing != nil {
+		item.action = syncUpdate
+	}
+	if dryRun {
+		return item
+	}
+
+	pcc, err := c.vcert.RetrieveKeyPair(cert.ID)
+	if err != nil {
+		item.fail(syncFailed, "could not export the certificate and its private key: %s", err)
+		return item
+	}
+	secret := tlsSecret(namespace, name, cert, pcc)
+	if existing != nil {
+		secret.ResourceVersion = existing.ResourceVersion
+		err = c.kubernetes.UpdateSecret(secret)
+	} else {
+		err = c.kubernetes.CreateSecret(secret)
+	}
+	if err != nil {
+		item.fail(syncFailed, "could not %s the Secret: %s", item.action, err)
+	}
+	return item
+}
+
+// tlsSecret returns a kubernetes.io/tls Secret with the certificate followed
+// by its chain in tls.crt, the private key in tls.key and the root of the
+// chain in ca.crt
+func tlsSecret(namespace string, name string, cert certificate.CertificateInfo, pcc *certificate.PEMCollection) *k8sclient.Secret {
+	data := map[string][]byte{
+		"tls.crt": []byte(pemChain(append([]string{pcc.Certificate}, pcc.Chain...))),
+		"tls.key": []byte(strings.TrimSpace(pcc.PrivateKey) + "\n"),
+	}
+	if len(pcc.Chain) > 0 {
+		data["ca.crt"] = []byte(strings.TrimSpace(pcc.Chain[len(pcc.Chain)-1]) + "\n")
+	}
+	return &k8sclient.Secret{
+		Namespace: namespace,
+		Name:      name,
+		Type:      k8sclient.SecretTypeTLS,
+		Labels:    map[string]string{labelManagedBy: managedByCV},
+		Annotations: map[string]string{
+			annotationVenafiDN:   cert.ID,
+			annotationThumbprint: cert.Thumbprint,
+		},
+		Data: data,
+	}
+}
+
+func printSync(items []syncItem) {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "VENAFI DN\tSECRET\tACTION\n")
+	for _, i := range items {
+		secret := "-"
+		if i.name != "" {
+			secret = i.namespace + "/" + i.name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", i.dn, secret, i.action)
+	}
+	w.Flush()
+	output.Print("%s%s", output.Cyan, b.String())
+
+	for _, i := range items {
+		for _, p := range i.problems {
+			output.Errorf("%s: %s\n", i.dn, p)
+		}
+	}
+}
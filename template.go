@@ -0,0 +1,103 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/newcontext-oss/credhub-venafi/config"
+)
+
+// loadTemplate reads the named template from the config file
+func loadTemplate(name string) (config.Template, error) {
+	userHomeDir, err := os.UserHomeDir()
+	if err != nil {
+		return config.Template{}, err
+	}
+	configYAML, err := config.ReadConfig(userHomeDir, ConfigFile)
+	if err != nil {
+		return config.Template{}, err
+	}
+	t, ok := configYAML.Templates[name]
+	if !ok {
+		return config.Template{}, fmt.Errorf("template '%s' not found in %s", name, ConfigFile)
+	}
+	return t, nil
+}
+
+// setFlags returns the names of the flags given on the command line
+func setFlags() map[string]bool {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// applyTemplate copies the template values into the command for every flag
+// that was not given on the command line
+func (v *GenerateAndStoreCommand) applyTemplate(t config.Template, set map[string]bool) error {
+	str := func(flagName string, value string, field *string) {
+		if !set[flagName] && value != "" {
+			*field = value
+		}
+	}
+	strs := func(flagName string, values []string, field *stringSlice) {
+		if !set[flagName] && len(values) > 0 {
+			*field = append(stringSlice{}, values...)
+		}
+	}
+	num := func(flagName string, value int, field *int) {
+		if !set[flagName] && value != 0 {
+			*field = value
+		}
+	}
+
+	str("o", t.Organization, &v.OrganizationName)
+	strs("ou", t.OrganizationalUnit, &v.OrganizationalUnit)
+	str("c", t.Country, &v.Country)
+	str("st", t.State, &v.State)
+	str("l", t.Locality, &v.Locality)
+	num("key-length", t.KeyLength, &v.KeyLength)
+	strs("key-usage", t.KeyUsage, &v.KeyUsage)
+	strs("ext-key-usage", t.ExtKeyUsage, &v.ExtKeyUsage)
+	// duration and validity-hours set the same validity, so a flag for
+	// either one overrides both template values
+	if !set["duration"] && !set["validity-hours"] {
+		num("duration", t.Duration, &v.Duration)
+		num("validity-hours", t.ValidityHours, &v.ValidityHours)
+	}
+	str("issuer-hint", t.IssuerHint, &v.IssuerHint)
+	str("csr-origin", t.CsrOrigin, &v.CsrOrigin)
+	str("ca", t.CA, &v.CA)
+	if !set["self-sign"] && t.SelfSign {
+		v.SelfSign = true
+	}
+	if !set["key-type"] && t.KeyType != "" {
+		err := v.KeyType.Set(t.KeyType)
+		if err != nil {
+			return fmt.Errorf("template key_type: %s", err)
+		}
+	}
+	if !set["key-curve"] && t.KeyCurve != "" {
+		err := v.KeyCurve.Set(t.KeyCurve)
+		if err != nil {
+			return fmt.Errorf("template key_curve: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vaultclient stores certificates in the KV version 2 secrets engine
+// of HashiCorp Vault and issues them with its PKI secrets engine, so that
+// Vault can take the place of CredHub as the secret store.
+package vaultclient
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/newcontext-oss/credhub-venafi/chclient"
+	"github.com/newcontext-oss/credhub-venafi/httpclient"
+	"github.com/newcontext-oss/credhub-venafi/output"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
+)
+
+var _ secretstore.Store = (*VaultProxy)(nil)
+
+// VaultProxy contains the config information for the Vault request proxy
+type VaultProxy struct {
+	BaseURL string
+	Token   string
+	// KVPath is the mount of a KV version 2 secrets engine followed by the
+	// folder holding the certificates, e.g. secret/venafi
+	KVPath string
+	// PKIPath is the mount of the PKI secrets engine and Role the role
+	// certificates are issued with
+	PKIPath           string
+	Role              string
+	SkipTLSValidation bool
+	// CACerts are PEM certificates trusted for Vault in addition to the
+	// system roots
+	CACerts []string
+	// HTTP sets the proxy and timeouts for Vault
+	HTTP httpclient.Settings
+
+	client *http.Client
+}
+
+// kvCertificate is the value of a certificate in the KV secrets engine
+type kvCertificate struct {
+	CA          string `json:"ca"`
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"private_key"`
+}
+
+// kvVersion is a version of a certificate in the KV secrets engine
+type kvVersion struct {
+	Data     kvCertificate `json:"data"`
+	Metadata struct {
+		Version     int    `json:"version"`
+		CreatedTime string `json:"created_time"`
+	} `json:"metadata"`
+}
+
+// GenerateCertificate issues a certificate with the PKI role and stores it.
+// Only the common name, alternative names and duration can be requested,
+// subject, key and usages are set by the role and rejected as unsupported.
+func (vp *VaultProxy) GenerateCertificate(name string, parameters secretstore.GenerateParameters, overwrite bool) (secretstore.Certificate, error) {
+	if !overwrite {
+		existing, err := vp.GetCertificate(name)
+		if err == nil {
+			return existing, nil
+		}
+		if !errors.Is(err, secretstore.ErrNotFound) {
+			return secretstore.Certificate{}, err
+		}
+	}
+	if parameters.SelfSign || parameters.IsCA || parameters.Ca != "" {
+		return secretstore.Certificate{}, fmt.Errorf("Vault issues certificates with the role %s of %s, self-signed and CA certificates can't be generated", vp.Role, vp.PKIPath)
+	}
+	unsupported := []string{}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"organization", parameters.Organization != ""},
+		{"organizational unit", parameters.OrganizationUnit != ""},
+		{"locality", parameters.Locality != ""},
+		{"state", parameters.State != ""},
+		{"country", parameters.Country != ""},
+		{"key length", parameters.KeyLength != 0},
+		{"key usage", len(parameters.KeyUsage) > 0},
+		{"extended key usage", len(parameters.ExtendedKeyUsage) > 0},
+	} {
+		if f.set {
+			unsupported = append(unsupported, f.name+" (set by the role "+vp.Role+")")
+		}
+	}
+	if len(unsupported) > 0 {
+		return secretstore.Certificate{}, &chclient.UnsupportedError{Platform: "Vault", Fields: unsupported}
+	}
+
+	dnsNames := []string{}
+	ips := []string{}
+	for _, n := range parameters.AlternativeNames {
+		if net.ParseIP(n) != nil {
+			ips = append(ips, n)
+		} else {
+			dnsNames = append(dnsNames, n)
+		}
+	}
+	req := map[string]string{"common_name": parameters.CommonName, "format": "pem"}
+	if len(dnsNames) > 0 {
+		req["alt_names"] = strings.Join(dnsNames, ",")
+	}
+	if len(ips) > 0 {
+		req["ip_sans"] = strings.Join(ips, ",")
+	}
+	if parameters.Duration > 0 {
+		req["ttl"] = fmt.Sprintf("%dh", parameters.Duration*24)
+	}
+	var resp struct {
+		Data struct {
+			Certificate string `json:"certificate"`
+			IssuingCA   string `json:"issuing_ca"`
+			PrivateKey  string `json:"private_key"`
+		} `json:"data"`
+	}
+	err := vp.request("POST", strings.Trim(vp.PKIPath, "/")+"/issue/"+vp.Role, nil, req, &resp)
+	if err != nil {
+		return secretstore.Certificate{}, fmt.Errorf("could not issue %s: %s", name, err)
+	}
+	output.Verbose("issued %s with role %s", name, vp.Role)
+
+	err = vp.PutCertificate(name, resp.Data.IssuingCA, resp.Data.Certificate, resp.Data.PrivateKey)
+	if err != nil {
+		return secretstore.Certificate{}, err
+	}
+	return vp.GetCertificate(name)
+}
+
+// PutCertificate stores a certificate as a new version and verifies it by
+// reading that version back
+func (vp *VaultProxy) PutCertificate(certName string, ca string, certificate string, privateKey string) error {
+	in := map[string]interface{}{
+		"data": kvCertificate{CA: ca, Certificate: certificate, PrivateKey: privateKey},
+	}
+	var resp struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}
+	err := vp.request("POST", vp.kvURL("data", certName), nil, in, &resp)
+	if err != nil {
+		return fmt.Errorf("could not store certificate %s in Vault: %s", certName, err)
+	}
+	return vp.verifyCertificate(certName, resp.Data.Version, certificate)
+}
+
+// verifyCertificate reads back a version of a certificate and checks that
+// its thumbprint matches the one that was written
+func (vp *VaultProxy) verifyCertificate(name string, version int, expected string) error {
+	want, err := chclient.GetThumbprint(expected)
+	if err != nil {
+		return fmt.Errorf("could not calculate thumbprint of certificate written to %s: %s", name, err)
+	}
+	cert, err := vp.getVersion(name, version)
+	if err != nil {
+		return fmt.Errorf("could not read back certificate %s from Vault: %s", name, err)
+	}
+	got, err := chclient.GetThumbprint(cert.Certificate)
+	if err != nil {
+		return fmt.Errorf("could not calculate thumbprint of certificate read back from %s: %s", name, err)
+	}
+	if got != want {
+		return fmt.Errorf("verification of %s failed: Vault has thumbprint %x, expected %x", name, got, want)
+	}
+	output.Verbose("verified %s in Vault with thumbprint %x", name, got)
+	return nil
+}
+
+// Name returns Vault
+func (vp *VaultProxy) Name() string {
+	return "Vault"
+}
+
+// DeleteCert deletes all versions of a certificate
+func (vp *VaultProxy) DeleteCert(name string) error {
+	return vp.request("DELETE", vp.kvURL("metadata", name), nil, nil, nil)
+}
+
+// List returns the names of the certificates below the KV path
+func (vp *VaultProxy) List() ([]secretstore.CertificateMetadata, error) {
+	certs := []secretstore.CertificateMetadata{}
+	folders := []string{""}
+	for len(folders) > 0 {
+		folder := folders[0]
+		folders = folders[1:]
+		var resp struct {
+			Data struct {
+				Keys []string `json:"keys"`
+			} `json:"data"`
+		}
+		err := vp.request("GET", vp.kvURL("metadata", folder), url.Values{"list": []string{"true"}}, nil, &resp)
+		if errors.Is(err, secretstore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range resp.Data.Keys {
+			if strings.HasSuffix(key, "/") {
+				folders = append(folders, folder+key)
+				continue
+			}
+			certs = append(certs, secretstore.CertificateMetadata{Name: "/" + folder + key})
+		}
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].Name < certs[j].Name })
+	return certs, nil
+}
+
+// GetCertificate returns the current version of a certificate
+func (vp *VaultProxy) GetCertificate(name string) (secretstore.Certificate, error) {
+	return vp.getVersion(name, 0)
+}
+
+// GetCertificateVersions returns all versions of a certificate that are not
+// deleted, newest first
+func (vp *VaultProxy) GetCertificateVersions(name string) ([]secretstore.Certificate, error) {
+	var resp struct {
+		Data struct {
+			Versions map[string]struct {
+				DeletionTime string `json:"deletion_time"`
+				Destroyed    bool   `json:"destroyed"`
+			} `json:"versions"`
+		} `json:"data"`
+	}
+	err := vp.request("GET", vp.kvURL("metadata", name), nil, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	numbers := []int{}
+	for v, state := range resp.Data.Versions {
+		n, err := strconv.Atoi(v)
+		if err != nil || state.DeletionTime != "" || state.Destroyed {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(numbers)))
+
+	certs := []secretstore.Certificate{}
+	for _, n := range numbers {
+		cert, err := vp.getVersion(name, n)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// GetCertificateMetadata returns the expiry of each version of a
+// certificate. Vault has no transitional versions.
+func (vp *VaultProxy) GetCertificateMetadata(name string) (secretstore.CertificateMetadata, error) {
+	versions, err := vp.GetCertificateVersions(name)
+	if err != nil {
+		return secretstore.CertificateMetadata{}, err
+	}
+	md := secretstore.CertificateMetadata{ID: name, Name: name}
+	for _, v := range versions {
+		version := secretstore.CertificateVersion{ID: v.ID}
+		block, _ := pem.Decode([]byte(v.Certificate))
+		if block != nil {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				version.ExpiryDate = cert.NotAfter.UTC().Format(time.RFC3339)
+				version.CertificateAuthority = cert.IsCA
+				version.SelfSigned = cert.CheckSignatureFrom(cert) == nil
+			}
+		}
+		md.Versions = append(md.Versions, version)
+	}
+	return md, nil
+}
+
+// ListKeys fails, as Vault only holds certificates for cv
+func (vp *VaultProxy) ListKeys(path string, types []string) ([]secretstore.Key, error) {
+	return nil, fmt.Errorf("rsa and ssh credentials are only supported with CredHub")
+}
+
+// getVersion returns a version of a certificate, or the current one for
+// version 0
+func (vp *VaultProxy) getVersion(name string, version int) (secretstore.Certificate, error) {
+	var query url.Values
+	if version > 0 {
+		query = url.Values{"version": []string{strconv.Itoa(version)}}
+	}
+	var resp struct {
+		Data kvVersion `json:"data"`
+	}
+	err := vp.request("GET", vp.kvURL("data", name), query, nil, &resp)
+	if err != nil {
+		return secretstore.Certificate{}, err
+	}
+	return secretstore.Certificate{
+		ID:          strconv.Itoa(resp.Data.Metadata.Version),
+		Name:        name,
+		CreatedAt:   resp.Data.Metadata.CreatedTime,
+		CA:          resp.Data.Data.CA,
+		Certificate: resp.Data.Data.Certificate,
+		PrivateKey:  resp.Data.Data.PrivateKey,
+	}, nil
+}
+
+// kvURL returns the resource of a certificate name in the data or metadata
+// tree of the KV secrets engine
+func (vp *VaultProxy) kvURL(tree string, name string) string {
+	path := strings.Trim(vp.KVPath, "/")
+	mount := path
+	folder := ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		mount, folder = path[:i], path[i+1:]
+	}
+	resource := mount + "/" + tree
+	if folder != "" {
+		resource += "/" + folder
+	}
+	return resource + "/" + strings.TrimPrefix(name, "/")
+}
+
+// httpClient returns the client for Vault
+func (vp *VaultProxy) httpClient() (*http.Client, error) {
+	if vp.client != nil {
+		return vp.client, nil
+	}
+	client, err := vp.HTTP.APIClient(vp.CACerts, vp.SkipTLSValidation)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure the Vault client: %s", err)
+	}
+	vp.client = client
+	return client, nil
+}
+
+// request sends a request to the Vault API and decodes the JSON response into
+// out. A 404 is returned as secretstore.ErrNotFound.
+func (vp *VaultProxy) request(method string, resource string, query url.Values, in interface{}, out interface{}) error {
+	u := strings.TrimSuffix(vp.BaseURL, "/") + "/v1/" + resource
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	client, err := vp.httpClient()
+	if err != nil {
+		return err
+	}
+	err = httpclient.JSONRequest(client, method, u, http.Header{"X-Vault-Token": []string{vp.Token}}, in, out, vaultError)
+	se, ok := err.(*httpclient.StatusError)
+	switch {
+	case !ok:
+		return err
+	case se.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%s: %w", resource, secretstore.ErrNotFound)
+	}
+	return fmt.Errorf("unexpected status from Vault %s: %s", resource, se)
+}
+
+// vaultError returns the messages of a Vault error response
+func vaultError(body []byte) (string, error) {
+	var e struct {
+		Errors []string `json:"errors"`
+	}
+	err := json.Unmarshal(body, &e)
+	return strings.Join(e.Errors, ", "), err
+}
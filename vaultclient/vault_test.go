@@ -0,0 +1,195 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaultclient_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/newcontext-oss/credhub-venafi/chclient"
+	"github.com/newcontext-oss/credhub-venafi/retry"
+	"github.com/newcontext-oss/credhub-venafi/secretstore"
+	"github.com/newcontext-oss/credhub-venafi/vaultclient"
+	"github.com/newcontext-oss/credhub-venafi/vaultclient/vaultfake"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeVault(t *testing.T) (*vaultfake.Server, *vaultclient.VaultProxy) {
+	server := vaultfake.NewServer("root-token")
+	t.Cleanup(server.Close)
+	server.AddRole("cv")
+	vp := &vaultclient.VaultProxy{
+		BaseURL: server.URL,
+		Token:   "root-token",
+		KVPath:  vaultfake.KVMount + "/venafi",
+		PKIPath: vaultfake.PKIMount,
+		Role:    "cv",
+	}
+	return server, vp
+}
+
+// selfSigned returns a PEM self-signed certificate for a common name
+func selfSigned(t *testing.T, cn string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestPutGetAndList(t *testing.T) {
+	server, vp := newFakeVault(t)
+	server.Put("elsewhere", map[string]interface{}{"certificate": "other"})
+
+	cert1, cert2 := selfSigned(t, "web"), selfSigned(t, "web")
+	assert.Nil(t, vp.PutCertificate("/web", "ca", cert1, "key"), "It should store and verify a certificate")
+	assert.Nil(t, vp.PutCertificate("/web", "ca", cert2, "key"))
+	assert.Nil(t, vp.PutCertificate("/team/api", "", selfSigned(t, "api"), ""))
+	assert.Len(t, server.Versions("venafi/web"), 2, "It should store below the KV path")
+
+	cert, err := vp.GetCertificate("/web")
+	assert.Nil(t, err, "It should read the current version")
+	assert.Equal(t, cert2, cert.Certificate)
+	assert.Equal(t, "ca", cert.CA)
+	assert.Equal(t, "2", cert.ID)
+	assert.Equal(t, "/web", cert.Name)
+
+	certs, err := vp.List()
+	assert.Nil(t, err, "It should list the certificates")
+	names := []string{}
+	for _, c := range certs {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"/team/api", "/web"}, names, "It should list folders recursively and only below the KV path")
+
+	_, err = vp.GetCertificate("/missing")
+	assert.True(t, errors.Is(err, secretstore.ErrNotFound), "It should report a missing certificate as ErrNotFound")
+
+	assert.Nil(t, vp.DeleteCert("/web"), "It should delete a certificate")
+	assert.Empty(t, server.Versions("venafi/web"))
+}
+
+func TestListEmpty(t *testing.T) {
+	_, vp := newFakeVault(t)
+	certs, err := vp.List()
+	assert.Nil(t, err, "It should list a KV path without secrets")
+	assert.Empty(t, certs)
+}
+
+func TestGenerateCertificate(t *testing.T) {
+	server, vp := newFakeVault(t)
+	cert, err := vp.GenerateCertificate("/issued", secretstore.GenerateParameters{CommonName: "issued.example.com", AlternativeNames: []string{"www.example.com", "10.0.0.1"}, Duration: 10}, false)
+	assert.Nil(t, err, "It should issue a certificate with the role")
+	assert.Equal(t, server.CA(), cert.CA)
+	assert.NotEmpty(t, cert.PrivateKey)
+
+	block, _ := pem.Decode([]byte(cert.Certificate))
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, "issued.example.com", parsed.Subject.CommonName)
+	assert.Equal(t, []string{"www.example.com"}, parsed.DNSNames)
+	assert.Equal(t, "10.0.0.1", parsed.IPAddresses[0].String())
+	assert.WithinDuration(t, time.Now().Add(10*24*time.Hour), parsed.NotAfter, time.Hour, "It should request the duration as ttl")
+
+	again, err := vp.GenerateCertificate("/issued", secretstore.GenerateParameters{CommonName: "issued.example.com"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Certificate, again.Certificate, "It should keep an existing certificate without overwrite")
+
+	_, err = vp.GenerateCertificate("/self", secretstore.GenerateParameters{CommonName: "self", SelfSign: true}, true)
+	assert.NotNil(t, err, "It should reject self-signed certificates")
+
+	_, err = vp.GenerateCertificate("/subject", secretstore.GenerateParameters{CommonName: "subject", Organization: "Example", KeyLength: 4096}, true)
+	assert.NotNil(t, err, "It should reject fields the role decides")
+	assert.Len(t, err.(*chclient.UnsupportedError).Fields, 2, "It should list every unsupported field")
+}
+
+func TestPutCertificateVerifies(t *testing.T) {
+	server, vp := newFakeVault(t)
+	server.Fail(http.MethodGet, "/v1/secret/data/venafi/unread", http.StatusForbidden, "permission denied")
+	err := vp.PutCertificate("/unread", "", selfSigned(t, "unread"), "")
+	assert.NotNil(t, err, "It should fail when the certificate can't be read back")
+	assert.Contains(t, err.Error(), "could not read back")
+
+	err = vp.PutCertificate("/invalid", "", "not a certificate", "")
+	assert.NotNil(t, err, "It should fail for a value that is not a certificate")
+}
+
+func TestVersionsAndMetadata(t *testing.T) {
+	server, vp := newFakeVault(t)
+	first, err := vp.GenerateCertificate("/rotated", secretstore.GenerateParameters{CommonName: "rotated", Duration: 1}, true)
+	assert.Nil(t, err)
+	second, err := vp.GenerateCertificate("/rotated", secretstore.GenerateParameters{CommonName: "rotated", Duration: 2}, true)
+	assert.Nil(t, err)
+	server.Put("venafi/rotated", map[string]interface{}{"certificate": second.Certificate})
+
+	versions, err := vp.GetCertificateVersions("/rotated")
+	assert.Nil(t, err, "It should read all versions")
+	assert.Len(t, versions, 3)
+	assert.Equal(t, "3", versions[0].ID, "It should return the newest version first")
+	assert.Equal(t, first.Certificate, versions[2].Certificate)
+
+	md, err := vp.GetCertificateMetadata("/rotated")
+	assert.Nil(t, err, "It should read the expiry of each version")
+	assert.Len(t, md.Versions, 3)
+	assert.Equal(t, "1", md.Versions[2].ID)
+	assert.NotEmpty(t, md.Versions[2].ExpiryDate)
+	assert.False(t, md.Versions[2].SelfSigned)
+}
+
+func TestRequestErrors(t *testing.T) {
+	server, vp := newFakeVault(t)
+	vp.Token = "wrong"
+	err := vp.PutCertificate("/denied", "", "cert", "")
+	assert.NotNil(t, err, "It should raise an error for a rejected token")
+	assert.Contains(t, err.Error(), "permission denied")
+
+	server, vp = newFakeVault(t)
+	server.Fail(http.MethodGet, "/v1/secret/data/venafi/proxied", http.StatusForbidden, "<html>blocked by proxy</html>")
+	_, err = vp.GetCertificate("/proxied")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "403 Forbidden <html>blocked by proxy</html>", "It should report a body that is not a Vault error")
+
+	_, vp = newFakeVault(t)
+	_, err = vp.ListKeys("/", []string{"rsa"})
+	assert.NotNil(t, err, "It should not list keys")
+
+	server, vp = newFakeVault(t)
+	r := retry.New("Vault", retry.Policy{Attempts: 3, Budget: 10})
+	r.Sleep = func(time.Duration) {}
+	rp := &secretstore.RetryProxy{Proxy: vp, Retrier: r}
+	assert.Nil(t, vp.PutCertificate("/retried", "", selfSigned(t, "retried"), ""))
+	server.Fail(http.MethodGet, "/v1/secret/data/venafi/retried", http.StatusBadGateway, "bad gateway")
+	_, err = rp.GetCertificate("/retried")
+	assert.Nil(t, err, "It should retry a read that failed with a 502")
+	assert.Equal(t, 1, r.Retries())
+}
@@ -0,0 +1,335 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vaultfake is a fake Vault server with a KV version 2 secrets engine
+// mounted at secret and a PKI secrets engine mounted at pki
+package vaultfake
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KVMount and PKIMount are the mounts of the secrets engines
+const (
+	KVMount  = "secret"
+	PKIMount = "pki"
+)
+
+// Server is a fake Vault server backed by in-memory storage
+type Server struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	token  string
+	roles  map[string]bool
+	kv     map[string][]*Version
+	faults []fault
+	nextSN int64
+	caCert *x509.Certificate
+	caKey  *rsa.PrivateKey
+	caPEM  string
+}
+
+// Version is a version of a KV secret
+type Version struct {
+	Data        map[string]interface{}
+	CreatedTime time.Time
+	Deleted     bool
+}
+
+type fault struct {
+	method string
+	path   string
+	status int
+	body   string
+}
+
+// NewServer starts a fake Vault server accepting token. Callers must call
+// Close when done.
+func NewServer(token string) *Server {
+	s := &Server{token: token, roles: map[string]bool{}, kv: map[string][]*Version{}}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Vault Fake CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	s.caCert, _ = x509.ParseCertificate(der)
+	s.caKey = key
+	s.caPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	s.nextSN = 1
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// CA returns the PEM certificate of the PKI secrets engine
+func (s *Server) CA() string {
+	return s.caPEM
+}
+
+// AddRole allows issuing certificates with a PKI role
+func (s *Server) AddRole(role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles[role] = true
+}
+
+// Fail makes the next request with method and path, such as
+// /v1/secret/data/venafi/cert, fail with status and body
+func (s *Server) Fail(method, path string, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault{method: method, path: path, status: status, body: body})
+}
+
+// Put stores a new version of a KV secret at path, relative to the mount
+func (s *Server) Put(path string, data map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(path, data)
+}
+
+// Versions returns the versions of a KV secret, oldest first
+func (s *Server) Versions(path string) []Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []Version{}
+	for _, v := range s.kv[strings.Trim(path, "/")] {
+		out = append(out, *v)
+	}
+	return out
+}
+
+func (s *Server) put(path string, data map[string]interface{}) int {
+	path = strings.Trim(path, "/")
+	s.kv[path] = append(s.kv[path], &Version{Data: data, CreatedTime: time.Now().UTC()})
+	return len(s.kv[path])
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.faults {
+		if f.method == r.Method && f.path == r.URL.Path {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			w.WriteHeader(f.status)
+			w.Write([]byte(f.body))
+			return
+		}
+	}
+	if r.Header.Get("X-Vault-Token") != s.token {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case strings.HasPrefix(path, KVMount+"/data/"):
+		s.handleData(w, r, strings.TrimPrefix(path, KVMount+"/data/"))
+	case strings.HasPrefix(path, KVMount+"/metadata/"):
+		s.handleMetadata(w, r, strings.TrimPrefix(path, KVMount+"/metadata/"))
+	case strings.HasPrefix(path, PKIMount+"/issue/"):
+		s.handleIssue(w, r, strings.TrimPrefix(path, PKIMount+"/issue/"))
+	default:
+		writeErrors(w, http.StatusNotFound)
+	}
+}
+
+func (s *Server) handleData(w http.ResponseWriter, r *http.Request, path string) {
+	path = strings.Trim(path, "/")
+	switch r.Method {
+	case http.MethodGet:
+		versions := s.kv[path]
+		n := len(versions)
+		if v := r.URL.Query().Get("version"); v != "" {
+			n, _ = strconv.Atoi(v)
+		}
+		if n < 1 || n > len(versions) || versions[n-1].Deleted {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+		v := versions[n-1]
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"data":     v.Data,
+			"metadata": map[string]interface{}{"version": n, "created_time": v.CreatedTime.Format(time.RFC3339Nano)},
+		}})
+	case http.MethodPost, http.MethodPut:
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		n := s.put(path, body.Data)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"version": n}})
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request, path string) {
+	switch {
+	case r.Method == "LIST" || (r.Method == http.MethodGet && r.URL.Query().Get("list") == "true"):
+		s.list(w, path)
+	case r.Method == http.MethodGet:
+		versions, ok := s.kv[strings.Trim(path, "/")]
+		if !ok {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+		out := map[string]interface{}{}
+		for i, v := range versions {
+			state := map[string]interface{}{"created_time": v.CreatedTime.Format(time.RFC3339Nano), "deletion_time": "", "destroyed": false}
+			if v.Deleted {
+				state["deletion_time"] = v.CreatedTime.Format(time.RFC3339Nano)
+			}
+			out[strconv.Itoa(i+1)] = state
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"versions": out, "current_version": len(versions)}})
+	case r.Method == http.MethodDelete:
+		delete(s.kv, strings.Trim(path, "/"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed)
+	}
+}
+
+// list returns the secrets and folders directly below path
+func (s *Server) list(w http.ResponseWriter, path string) {
+	prefix := strings.Trim(path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	seen := map[string]bool{}
+	for p := range s.kv {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(p, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			rest = rest[:i+1]
+		}
+		seen[rest] = true
+	}
+	if len(seen) == 0 {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+	keys := []string{}
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+}
+
+func (s *Server) handleIssue(w http.ResponseWriter, r *http.Request, role string) {
+	if !s.roles[role] {
+		writeErrors(w, http.StatusBadRequest, "unknown role: "+role)
+		return
+	}
+	var req struct {
+		CommonName string `json:"common_name"`
+		AltNames   string `json:"alt_names"`
+		IPSANs     string `json:"ip_sans"`
+		TTL        string `json:"ttl"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ttl := 30 * 24 * time.Hour
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		writeErrors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.nextSN++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(s.nextSN),
+		Subject:      pkix.Name{CommonName: req.CommonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, n := range strings.Split(req.AltNames, ",") {
+		if n != "" {
+			template.DNSNames = append(template.DNSNames, n)
+		}
+	}
+	for _, ip := range strings.Split(req.IPSANs, ",") {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			template.IPAddresses = append(template.IPAddresses, parsed)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, key.Public(), s.caKey)
+	if err != nil {
+		writeErrors(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+		"certificate":   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"issuing_ca":    s.caPEM,
+		"ca_chain":      []string{s.caPEM},
+		"private_key":   string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"serial_number": strconv.FormatInt(s.nextSN, 10),
+	}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeErrors(w http.ResponseWriter, status int, errors ...string) {
+	writeJSON(w, status, map[string]interface{}{"errors": append([]string{}, errors...)})
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcclient
+
+import (
+	"fmt"
+	"time"
+)
+
+// CertificateDetails are the attributes TPP keeps for a certificate object
+type CertificateDetails struct {
+	CN   string
+	SANS struct {
+		DNS, Email, IP, URI, UPN []string
+	}
+	Issuer string
+	// KeyAlgorithm is RSA or EC
+	KeyAlgorithm string
+	KeySize      int
+	ValidTo      time.Time
+	// CustomFields are the values of the custom fields by field name
+	CustomFields map[string][]string
+}
+
+// CertificateDetails returns the attributes and custom fields of a
+// certificate object
+func (v *VcertProxy) CertificateDetails(dn string) (*CertificateDetails, error) {
+	var guid struct {
+		GUID   string
+		Result int
+	}
+	err := v.request("POST", "vedsdk/config/dntoguid", struct{ ObjectDN string }{dn}, &guid)
+	if err != nil {
+		return nil, fmt.Errorf("could not find %s: %s", dn, err)
+	}
+	// the config API reports errors with a result code, 1 is success
+	if guid.Result != 1 {
+		return nil, fmt.Errorf("could not find %s: result %d", dn, guid.Result)
+	}
+
+	var resp struct {
+		CertificateDetails CertificateDetails
+		CustomFields       []struct {
+			Name  string
+			Value []string
+		}
+	}
+	err = v.request("GET", "vedsdk/certificates/"+guid.GUID, nil, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the details of %s: %s", dn, err)
+	}
+	details := resp.CertificateDetails
+	details.CustomFields = map[string][]string{}
+	for _, f := range resp.CustomFields {
+		details.CustomFields[f.Name] = f.Value
+	}
+	return &details, nil
+}
+
+// HoldsPrivateKey tells whether TPP holds the private key of a certificate
+// object and can export it. Keys of certificates enrolled with a CSR
+// generated elsewhere, or imported without their key, are not held.
+func (v *VcertProxy) HoldsPrivateKey(dn string) (bool, error) {
+	req := struct {
+		ObjectDN      string
+		AttributeName string
+	}{dn, "Private Key Vault Id"}
+	var resp struct {
+		Values []string
+		Result int
+	}
+	err := v.request("POST", "vedsdk/config/read", req, &resp)
+	if err != nil {
+		return false, fmt.Errorf("could not read the private key of %s: %s", dn, err)
+	}
+	// the config API reports errors with a result code, 1 is success
+	if resp.Result != 1 {
+		return false, fmt.Errorf("could not read the private key of %s: result %d", dn, resp.Result)
+	}
+	return len(resp.Values) > 0 && resp.Values[0] != "", nil
+}
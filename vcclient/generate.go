@@ -18,12 +18,17 @@ package vcclient
 // try to make it easier to understand.
 
 import (
+	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/newcontext-oss/credhub-venafi/output"
 )
 
 // CertArgs holds the arguments for certificate creation in vcert
@@ -32,6 +37,8 @@ type CertArgs struct {
 	CommonName         string
 	OrganizationName   string
 	SANDNS             []string
+	KeyType            certificate.KeyType
+	KeyLength          int
 	KeyCurve           certificate.EllipticCurve
 	OrganizationalUnit []string
 	Origin             string
@@ -41,33 +48,190 @@ type CertArgs struct {
 	SANEmail           []string
 	SANIP              []net.IP
 	KeyPassword        string
+	// ValidityHours requests a certificate that expires after the given
+	// number of hours instead of the CA default
+	ValidityHours int
+	// IssuerHint names the kind of CA behind the zone (microsoft, digicert
+	// or entrust) so that ValidityHours is sent in the attribute it reads
+	IssuerHint string
+	// CsrOrigin selects whether the CSR is generated locally, by Venafi or
+	// supplied in CSR
+	CsrOrigin certificate.CSrOriginOption
+	CSR       []byte
 }
 
-// Generate generates a certificate in vcert
+// CsrOrigins maps the CSR origins accepted by cv to the vcert options
+var CsrOrigins = map[string]certificate.CSrOriginOption{
+	"local":   certificate.LocalGeneratedCSR,
+	"service": certificate.ServiceGeneratedCSR,
+	"user":    certificate.UserProvidedCSR,
+}
+
+// CsrOriginName returns the name of o in CsrOrigins
+func CsrOriginName(o certificate.CSrOriginOption) string {
+	for name, origin := range CsrOrigins {
+		if origin == o {
+			return name
+		}
+	}
+	return ""
+}
+
+// Enrollment is a submitted certificate request that can be picked up. It
+// holds what is needed to complete the pickup later, including the locally
+// generated private key.
+type Enrollment struct {
+	PickupID    string
+	CsrOrigin   certificate.CSrOriginOption
+	KeyPassword string
+	PrivateKey  string
+}
+
+// PendingError is returned by Pickup when the certificate has not been issued
+// before the timeout, e.g. because it waits for approval
+type PendingError struct {
+	PickupID string
+	Status   string
+}
+
+func (e *PendingError) Error() string {
+	return fmt.Sprintf("certificate %s is still pending: %s", e.PickupID, e.Status)
+}
+
+// DefaultPickupTimeout is how long Generate waits for a certificate
+var DefaultPickupTimeout = 180 * time.Second
+
+// maxPickupInterval caps the time between two pickup attempts
+var maxPickupInterval = 30 * time.Second
+
+// Generate requests a certificate in vcert and waits for it to be issued
 func (v *VcertProxy) Generate(args *CertArgs) (*certificate.PEMCollection, error) {
+	e, err := v.Request(args)
+	if err != nil {
+		return nil, err
+	}
+	return v.Pickup(e, DefaultPickupTimeout)
+}
+
+// Request checks a certificate request against the zone policy and submits it
+// without waiting for the certificate
+func (v *VcertProxy) Request(args *CertArgs) (*Enrollment, error) {
 	req, err := buildGenerateRequest(args)
 	if err != nil {
 		return nil, err
 	}
 
-	requestID, privateKey, err := sendCertificateRequest(v.Client, req)
+	zone, err := v.Client.ReadZoneConfiguration()
+	if err != nil {
+		return nil, fmt.Errorf("could not read zone configuration: %s", err)
+	}
+	defaultKeyCurve(req, zone)
+	violations, err := PolicyViolations(zone, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(violations) > 0 {
+		return nil, &PolicyError{Violations: violations}
+	}
+	// vcert replaces key parameters the zone does not list instead of
+	// reporting them, so the key is generated as checked above
+	zone.AllowedKeyConfigurations = nil
+	err = v.Client.GenerateRequest(zone, req)
 	if err != nil {
 		return nil, err
 	}
 
-	pickupReq := &certificate.Request{
-		PickupID: requestID,
-		Timeout:  180 * time.Second,
+	e := &Enrollment{CsrOrigin: req.CsrOrigin, KeyPassword: req.KeyPassword}
+	if req.CsrOrigin == certificate.LocalGeneratedCSR {
+		pemBlock, err := certificate.GetPrivateKeyPEMBock(req.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		e.PrivateKey = string(pem.EncodeToMemory(pemBlock))
 	}
 
-	pcc, err := v.Client.RetrieveCertificate(pickupReq)
+	if args.ValidityHours > 0 {
+		e.PickupID, err = v.requestCertificate(req, args)
+	} else {
+		e.PickupID, err = v.Client.RequestCertificate(req)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("could not retrieve certificate using requestId %s: %s", requestID, err)
+		return nil, err
+	}
+	output.Verbose("Successfully submitted certificate request. Will pickup certificate by ID %s", e.PickupID)
+	return e, nil
+}
+
+// Pickup retrieves the certificate of an enrollment. While it is pending,
+// pickup is retried with a growing interval until the timeout has passed.
+// A zero timeout tries once.
+func (v *VcertProxy) Pickup(e *Enrollment, timeout time.Duration) (*certificate.PEMCollection, error) {
+	deadline := time.Now().Add(timeout)
+	interval := v.PickupInterval
+	if interval == 0 {
+		interval = 2 * time.Second
+	}
+
+	var pcc *certificate.PEMCollection
+	for {
+		var err error
+		pcc, err = v.Client.RetrieveCertificate(&certificate.Request{
+			PickupID:    e.PickupID,
+			CsrOrigin:   e.CsrOrigin,
+			KeyPassword: e.KeyPassword,
+		})
+		if err == nil {
+			break
+		}
+		pending, ok := err.(endpoint.ErrCertificatePending)
+		if !ok {
+			return nil, fmt.Errorf("could not retrieve certificate using requestId %s: %s", e.PickupID, err)
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return nil, &PendingError{PickupID: e.PickupID, Status: pending.Status}
+		}
+		output.Verbose("certificate %s is pending (%s), retrying in %s", e.PickupID, pending.Status, interval)
+		time.Sleep(interval)
+		interval *= 2
+		if interval > maxPickupInterval {
+			interval = maxPickupInterval
+		}
+	}
+
+	switch e.CsrOrigin {
+	case certificate.LocalGeneratedCSR:
+		pcc.PrivateKey = e.PrivateKey
+	case certificate.ServiceGeneratedCSR:
+		var err error
+		pcc.PrivateKey, err = DecryptPrivateKey(pcc.PrivateKey, e.KeyPassword)
+		if err != nil {
+			return nil, fmt.Errorf("could not read private key of %s: %s", e.PickupID, err)
+		}
+	case certificate.UserProvidedCSR:
+		// the private key stays with the owner of the CSR
+		pcc.PrivateKey = ""
 	}
-	pcc.PrivateKey = privateKey
 	return pcc, nil
 }
 
+// DecryptPrivateKey removes the password that protects a PEM private key
+// such as one exported from TPP. CredHub only stores unencrypted keys.
+func DecryptPrivateKey(key string, password string) (string, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return "", fmt.Errorf("no private key was returned")
+	}
+	// TPP exports keys with legacy PEM encryption
+	if !x509.IsEncryptedPEMBlock(block) {
+		return key, nil
+	}
+	der, err := x509.DecryptPEMBlock(block, []byte(password))
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})), nil
+}
+
 func buildGenerateRequest(v *CertArgs) (*certificate.Request, error) {
 	r := &certificate.Request{}
 	r.FriendlyName = v.Name
@@ -82,7 +246,13 @@ func buildGenerateRequest(v *CertArgs) (*certificate.Request, error) {
 	if len(v.SANDNS) != 0 {
 		r.DNSNames = v.SANDNS
 	}
+	r.KeyType = v.KeyType
 	r.KeyCurve = v.KeyCurve
+	r.KeyLength = v.KeyLength
+	// without a key length vcert picks the largest size the zone allows
+	if r.KeyType == certificate.KeyTypeRSA && r.KeyLength == 0 {
+		r.KeyLength = 2048
+	}
 	if len(v.OrganizationalUnit) > 0 {
 		subject.OrganizationalUnit = v.OrganizationalUnit
 	}
@@ -101,11 +271,37 @@ func buildGenerateRequest(v *CertArgs) (*certificate.Request, error) {
 	if len(v.SANIP) > 0 {
 		r.IPAddresses = v.SANIP
 	}
-	if v.KeyPassword == "" {
+	if v.KeyPassword != "" {
 		r.KeyPassword = v.KeyPassword
 	}
+	if v.ValidityHours < 0 {
+		return nil, fmt.Errorf("validity hours must not be negative")
+	}
+	if _, ok := validityAttributes[strings.ToLower(v.IssuerHint)]; !ok {
+		return nil, fmt.Errorf("unknown issuer hint '%s'", v.IssuerHint)
+	}
 	r.Subject = subject
 
+	r.CsrOrigin = v.CsrOrigin
+	switch v.CsrOrigin {
+	case certificate.LocalGeneratedCSR:
+	case certificate.ServiceGeneratedCSR:
+		// TPP only exports generated keys protected by a password
+		if v.KeyPassword == "" {
+			return nil, fmt.Errorf("a key password is required when Venafi generates the key")
+		}
+	case certificate.UserProvidedCSR:
+		if len(v.CSR) == 0 {
+			return nil, fmt.Errorf("a CSR is required when the CSR is user provided")
+		}
+		err := r.SetCSR(v.CSR)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CSR: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown CSR origin %d", v.CsrOrigin)
+	}
+
 	r.CustomFields = append(r.CustomFields, certificate.CustomField{
 		Type:  certificate.CustomFieldOrigin,
 		Name:  "Origin",
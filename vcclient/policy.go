@@ -0,0 +1,216 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcclient
+
+// This file contains the zone policy checks done before a certificate is
+// requested, so that every violation is reported at once instead of the
+// first one TPP rejects.
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
+)
+
+// PolicyError lists the ways a request violates the zone policy
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "request violates the zone policy:\n  - " + strings.Join(e.Violations, "\n  - ")
+}
+
+// ReadZone reads the configuration and policy of a zone. An empty zone reads
+// the configured one.
+func (v *VcertProxy) ReadZone(zone string) (*endpoint.ZoneConfiguration, error) {
+	if zone != "" {
+		v.Client.SetZone(zone)
+		defer v.Client.SetZone(v.Zone)
+	}
+	z, err := v.Client.ReadZoneConfiguration()
+	if err != nil {
+		return nil, fmt.Errorf("could not read zone configuration: %s", err)
+	}
+	return z, nil
+}
+
+// defaultKeyCurve sets an unset curve to the first one the zone allows
+func defaultKeyCurve(req *certificate.Request, zone *endpoint.ZoneConfiguration) {
+	if req.KeyType != certificate.KeyTypeECDSA || req.KeyCurve != certificate.EllipticCurveNotSet {
+		return
+	}
+	req.KeyCurve = certificate.EllipticCurveDefault
+	for _, allowed := range zone.AllowedKeyConfigurations {
+		if allowed.KeyType == certificate.KeyTypeECDSA && len(allowed.KeyCurves) > 0 {
+			req.KeyCurve = allowed.KeyCurves[0]
+			break
+		}
+	}
+}
+
+// PolicyViolations checks a request against the zone policy and returns all
+// violations. For a user provided CSR the contents of the CSR are checked.
+func PolicyViolations(zone *endpoint.ZoneConfiguration, req *certificate.Request) ([]string, error) {
+	subject := req.Subject
+	dnsNames, emails, ips := req.DNSNames, req.EmailAddresses, req.IPAddresses
+	keyType, keyLength, keyCurve := req.KeyType, req.KeyLength, req.KeyCurve
+	violations := []string{}
+
+	if req.CsrOrigin == certificate.UserProvidedCSR {
+		block, _ := pem.Decode(req.GetCSR())
+		if block == nil {
+			return nil, fmt.Errorf("could not decode CSR")
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse CSR: %s", err)
+		}
+		subject = csr.Subject
+		dnsNames, emails, ips = csr.DNSNames, csr.EmailAddresses, csr.IPAddresses
+		switch key := csr.PublicKey.(type) {
+		case *rsa.PublicKey:
+			keyType, keyLength = certificate.KeyTypeRSA, key.N.BitLen()
+		case *ecdsa.PublicKey:
+			keyType = certificate.KeyTypeECDSA
+			_ = keyCurve.Set(key.Curve.Params().Name)
+		}
+	}
+	wildcard := func(name string) bool {
+		if zone.AllowWildcards || !strings.HasPrefix(name, "*") {
+			return false
+		}
+		violations = append(violations, fmt.Sprintf("wildcard %s is not allowed", name))
+		return true
+	}
+	if wildcard(subject.CommonName) {
+		subject.CommonName = ""
+	}
+	violations = append(violations, subjectViolations(zone, subject)...)
+	for _, name := range dnsNames {
+		if !wildcard(name) && !matchesAny(name, zone.DnsSanRegExs) {
+			violations = append(violations, fmt.Sprintf("DNS SAN %s is not allowed", name))
+		}
+	}
+	for _, email := range emails {
+		if !matchesAny(email, zone.EmailSanRegExs) {
+			violations = append(violations, fmt.Sprintf("email SAN %s is not allowed", email))
+		}
+	}
+	for _, ip := range ips {
+		if !matchesAny(ip.String(), zone.IpSanRegExs) {
+			violations = append(violations, fmt.Sprintf("IP SAN %s is not allowed", ip))
+		}
+	}
+	if v := keyViolation(zone, keyType, keyLength, keyCurve); v != "" {
+		violations = append(violations, v)
+	}
+	return violations, nil
+}
+
+func subjectViolations(zone *endpoint.ZoneConfiguration, subject pkix.Name) []string {
+	violations := []string{}
+	check := func(field string, values []string, regexes []string) {
+		for _, value := range values {
+			if !matchesAny(value, regexes) {
+				violations = append(violations, fmt.Sprintf("%s %s is not allowed", field, value))
+			}
+		}
+	}
+	if subject.CommonName != "" {
+		check("common name", []string{subject.CommonName}, zone.SubjectCNRegexes)
+	}
+	check("organization", subject.Organization, zone.SubjectORegexes)
+	check("organizational unit", subject.OrganizationalUnit, zone.SubjectOURegexes)
+	check("country", subject.Country, zone.SubjectCRegexes)
+	check("state", subject.Province, zone.SubjectSTRegexes)
+	check("locality", subject.Locality, zone.SubjectLRegexes)
+	return violations
+}
+
+// keyViolation describes why the key is not allowed, or returns "" if it is.
+// TPP locks a minimum RSA key size rather than a list of sizes.
+func keyViolation(zone *endpoint.ZoneConfiguration, keyType certificate.KeyType, keyLength int, curve certificate.EllipticCurve) string {
+	if len(zone.AllowedKeyConfigurations) == 0 {
+		return ""
+	}
+	for _, allowed := range zone.AllowedKeyConfigurations {
+		if allowed.KeyType != keyType {
+			continue
+		}
+		switch keyType {
+		case certificate.KeyTypeRSA:
+			for _, size := range allowed.KeySizes {
+				if size <= keyLength {
+					return ""
+				}
+			}
+			if len(allowed.KeySizes) == 0 {
+				return ""
+			}
+			return fmt.Sprintf("%d bit RSA keys are not allowed", keyLength)
+		case certificate.KeyTypeECDSA:
+			for _, c := range allowed.KeyCurves {
+				if c == curve {
+					return ""
+				}
+			}
+			if len(allowed.KeyCurves) == 0 {
+				return ""
+			}
+			return fmt.Sprintf("the %s curve is not allowed", curve.String())
+		}
+	}
+	return fmt.Sprintf("%s keys are not allowed", keyType.String())
+}
+
+// matchesAny reports whether s matches one of the regular expressions. An
+// empty list allows nothing.
+func matchesAny(s string, regexes []string) bool {
+	for _, r := range regexes {
+		if matched, err := regexp.MatchString(r, s); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// SANTypesAllowed lists the SAN types a zone accepts
+func SANTypesAllowed(zone *endpoint.ZoneConfiguration) []string {
+	types := []string{}
+	for _, t := range []struct {
+		name    string
+		regexes []string
+	}{
+		{"dns", zone.DnsSanRegExs},
+		{"email", zone.EmailSanRegExs},
+		{"ip", zone.IpSanRegExs},
+		{"uri", zone.UriSanRegExs},
+		{"upn", zone.UpnSanRegExs},
+	} {
+		if len(t.regexes) > 0 {
+			types = append(types, t.name)
+		}
+	}
+	return types
+}
@@ -0,0 +1,196 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcclient
+
+import (
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/newcontext-oss/credhub-venafi/retry"
+)
+
+// RetryProxy retries the calls of another vcert proxy that fail with a
+// transient error
+type RetryProxy struct {
+	Proxy   IVcertProxy
+	Retrier *retry.Retrier
+}
+
+// PutCertificate uploads a certificate to vcert. Importing the same
+// certificate into the same object again has no further effect.
+func (r *RetryProxy) PutCertificate(certName string, cert string, privateKey string) error {
+	return r.Retrier.Do("import "+certName, retry.Idempotent, func() error {
+		return r.Proxy.PutCertificate(certName, cert, privateKey)
+	})
+}
+
+// List retrieves the list of certificates from vcert
+func (r *RetryProxy) List(vlimit int, zone string) ([]certificate.CertificateInfo, error) {
+	var certs []certificate.CertificateInfo
+	err := r.Retrier.Do("list "+zone, retry.Read, func() error {
+		var err error
+		certs, err = r.Proxy.List(vlimit, zone)
+		return err
+	})
+	return certs, err
+}
+
+// RetrieveCertificateByThumbprint fetches a certificate from vcert by the thumbprint
+func (r *RetryProxy) RetrieveCertificateByThumbprint(thumbprint string) (*certificate.PEMCollection, error) {
+	var pcc *certificate.PEMCollection
+	err := r.Retrier.Do("retrieve "+thumbprint, retry.Read, func() error {
+		var err error
+		pcc, err = r.Proxy.RetrieveCertificateByThumbprint(thumbprint)
+		return err
+	})
+	return pcc, err
+}
+
+// RetrieveCertificateByDN fetches the certificate of a vcert object
+func (r *RetryProxy) RetrieveCertificateByDN(dn string) (*certificate.PEMCollection, error) {
+	var pcc *certificate.PEMCollection
+	err := r.Retrier.Do("retrieve "+dn, retry.Read, func() error {
+		var err error
+		pcc, err = r.Proxy.RetrieveCertificateByDN(dn)
+		return err
+	})
+	return pcc, err
+}
+
+// RetrieveKeyPair fetches the certificate and private key of a vcert object
+func (r *RetryProxy) RetrieveKeyPair(dn string) (*certificate.PEMCollection, error) {
+	var pcc *certificate.PEMCollection
+	err := r.Retrier.Do("retrieve key of "+dn, retry.Read, func() error {
+		var err error
+		pcc, err = r.Proxy.RetrieveKeyPair(dn)
+		return err
+	})
+	return pcc, err
+}
+
+// Login creates a session with the TPP server
+func (r *RetryProxy) Login() error {
+	return r.Retrier.Do("login", retry.Idempotent, r.Proxy.Login)
+}
+
+// Logout revokes the access token created by Login
+func (r *RetryProxy) Logout() error {
+	return r.Retrier.Do("logout", retry.Idempotent, r.Proxy.Logout)
+}
+
+// Revoke revokes a certificate in vcert and optionally disables it
+func (r *RetryProxy) Revoke(args *RevokeArgs) error {
+	return r.Retrier.Do("revoke "+args.DN+args.Thumbprint, retry.Write, func() error {
+		return r.Proxy.Revoke(args)
+	})
+}
+
+// FindByThumbprint returns the DNs of all certificate objects with the given
+// thumbprint
+func (r *RetryProxy) FindByThumbprint(thumbprint string) ([]string, error) {
+	var dns []string
+	err := r.Retrier.Do("find "+thumbprint, retry.Read, func() error {
+		var err error
+		dns, err = r.Proxy.FindByThumbprint(thumbprint)
+		return err
+	})
+	return dns, err
+}
+
+// Delete removes a certificate object from TPP
+func (r *RetryProxy) Delete(dn string) error {
+	return r.Retrier.Do("delete "+dn, retry.Write, func() error {
+		return r.Proxy.Delete(dn)
+	})
+}
+
+// Generate requests a certificate and waits for it to be issued. The request
+// and the pickup are retried separately, so that a failed pickup does not
+// submit the request twice.
+func (r *RetryProxy) Generate(args *CertArgs) (*certificate.PEMCollection, error) {
+	e, err := r.Request(args)
+	if err != nil {
+		return nil, err
+	}
+	return r.Pickup(e, DefaultPickupTimeout)
+}
+
+// Request submits a certificate request
+func (r *RetryProxy) Request(args *CertArgs) (*Enrollment, error) {
+	var e *Enrollment
+	err := r.Retrier.Do("request "+args.CommonName, retry.Write, func() error {
+		var err error
+		e, err = r.Proxy.Request(args)
+		return err
+	})
+	return e, err
+}
+
+// Pickup retrieves the certificate of an enrollment
+func (r *RetryProxy) Pickup(e *Enrollment, timeout time.Duration) (*certificate.PEMCollection, error) {
+	var pcc *certificate.PEMCollection
+	err := r.Retrier.Do("pickup "+e.PickupID, retry.Read, func() error {
+		var err error
+		pcc, err = r.Proxy.Pickup(e, timeout)
+		return err
+	})
+	return pcc, err
+}
+
+// ReadZone returns the configuration of a zone
+func (r *RetryProxy) ReadZone(zone string) (*endpoint.ZoneConfiguration, error) {
+	var zc *endpoint.ZoneConfiguration
+	err := r.Retrier.Do("read zone "+zone, retry.Read, func() error {
+		var err error
+		zc, err = r.Proxy.ReadZone(zone)
+		return err
+	})
+	return zc, err
+}
+
+// ListSSHKeys lists the SSH key inventory
+func (r *RetryProxy) ListSSHKeys(limit int) ([]SSHKey, error) {
+	var keys []SSHKey
+	err := r.Retrier.Do("list SSH keys", retry.Read, func() error {
+		var err error
+		keys, err = r.Proxy.ListSSHKeys(limit)
+		return err
+	})
+	return keys, err
+}
+
+// CertificateDetails reads the attributes and custom fields of a certificate
+// object
+func (r *RetryProxy) CertificateDetails(dn string) (*CertificateDetails, error) {
+	var details *CertificateDetails
+	err := r.Retrier.Do("read details of "+dn, retry.Read, func() error {
+		var err error
+		details, err = r.Proxy.CertificateDetails(dn)
+		return err
+	})
+	return details, err
+}
+
+// HoldsPrivateKey tells whether TPP holds the private key of a certificate
+func (r *RetryProxy) HoldsPrivateKey(dn string) (bool, error) {
+	var held bool
+	err := r.Retrier.Do("read private key of "+dn, retry.Read, func() error {
+		var err error
+		held, err = r.Proxy.HoldsPrivateKey(dn)
+		return err
+	})
+	return held, err
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/Venafi/vcert/pkg/venafi/tpp"
+	"github.com/newcontext-oss/credhub-venafi/output"
+)
+
+// DefaultClientID is the TPP API integration cv requests tokens for
+const DefaultClientID = "vault-venafi"
+
+// DefaultScope is the scope of the tokens cv requests
+const DefaultScope = "certificate:manage,delete,discover"
+
+// expiryMargin is how long before its expiry an access token is refreshed
+const expiryMargin = time.Minute
+
+// Session is a TPP token grant kept between runs
+type Session struct {
+	BaseURL      string    `json:"base_url"`
+	ClientID     string    `json:"client_id"`
+	Scope        string    `json:"scope"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expires      time.Time `json:"expires"`
+}
+
+// valid tells whether the access token can still be used
+func (s *Session) valid() bool {
+	return s.AccessToken != "" && time.Now().Add(expiryMargin).Before(s.Expires)
+}
+
+func (v *VcertProxy) clientID() string {
+	if v.ClientID == "" {
+		return DefaultClientID
+	}
+	return v.ClientID
+}
+
+func (v *VcertProxy) scope() string {
+	if v.Scope == "" {
+		return DefaultScope
+	}
+	return v.Scope
+}
+
+// NewSession requests a new token grant with the username and password and
+// stores it in the session file
+func (v *VcertProxy) NewSession() error {
+	client, err := v.httpClient()
+	if err != nil {
+		return err
+	}
+	connector, err := v.connector(client)
+	if err != nil {
+		return err
+	}
+	s, err := v.grant(connector)
+	if err != nil {
+		return err
+	}
+	v.AccessToken = s.AccessToken
+	return v.writeSession(s)
+}
+
+// sessionToken returns the access token of the cached session. An expired
+// token is refreshed, and a new grant is requested if there is no usable
+// session. The session file is updated with new tokens.
+func (v *VcertProxy) sessionToken(client *http.Client) (string, error) {
+	s, err := v.readSession()
+	if err != nil {
+		output.Info("ignoring TPP session: %s\n", err)
+	}
+	if s != nil && s.valid() {
+		output.Info("vcert using cached access token\n")
+		return s.AccessToken, nil
+	}
+
+	connector, err := v.connector(client)
+	if err != nil {
+		return "", err
+	}
+	if s != nil && s.RefreshToken != "" {
+		resp, err := connector.RefreshAccessToken(&endpoint.Authentication{RefreshToken: s.RefreshToken, ClientId: s.ClientID})
+		if err == nil {
+			s.AccessToken = resp.Access_token
+			s.RefreshToken = resp.Refresh_token
+			s.Expires = time.Unix(int64(resp.Expires), 0)
+			output.Info("vcert refreshed access token\n")
+			return s.AccessToken, v.writeSession(s)
+		}
+		output.Info("could not refresh access token: %s\n", err)
+	}
+
+	if v.Username == "" || v.Password == "" {
+		return "", fmt.Errorf("no Venafi session, run `cv login -venafi` or set vcert_username and vcert_password")
+	}
+	s, err = v.grant(connector)
+	if err != nil {
+		return "", err
+	}
+	if v.SessionFile == "" {
+		// the token is only used by this run
+		v.mu.Lock()
+		v.createdToken = s.AccessToken
+		v.mu.Unlock()
+	}
+	return s.AccessToken, v.writeSession(s)
+}
+
+func (v *VcertProxy) connector(client *http.Client) (*tpp.Connector, error) {
+	trust, err := v.trustPool()
+	if err != nil {
+		return nil, err
+	}
+	connector, err := tpp.NewConnector(v.BaseURL, v.Zone, false, trust)
+	if err != nil {
+		return nil, fmt.Errorf("could not create tpp client: %s", err)
+	}
+	connector.SetHTTPClient(client)
+	return connector, nil
+}
+
+// grant requests an access and refresh token with the username and password
+func (v *VcertProxy) grant(connector *tpp.Connector) (*Session, error) {
+	resp, err := connector.GetRefreshToken(&endpoint.Authentication{
+		User: v.Username, Password: v.Password, ClientId: v.clientID(), Scope: v.scope()})
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch access token. Enable legacy auth support: %s", err)
+	}
+	output.Info("vcert created access token\n")
+	return &Session{
+		BaseURL:      v.BaseURL,
+		ClientID:     v.clientID(),
+		Scope:        v.scope(),
+		AccessToken:  resp.Access_token,
+		RefreshToken: resp.Refresh_token,
+		Expires:      time.Unix(int64(resp.Expires), 0),
+	}, nil
+}
+
+// readSession returns the cached session if it was granted for the server,
+// client id and scope in use, or nil
+func (v *VcertProxy) readSession() (*Session, error) {
+	if v.SessionFile == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(v.SessionFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{}
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", v.SessionFile, err)
+	}
+	if s.BaseURL != v.BaseURL || s.ClientID != v.clientID() || s.Scope != v.scope() {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// writeSession stores a session in the session file, readable only by the
+// user as it holds the tokens
+func (v *VcertProxy) writeSession(s *Session) error {
+	if v.SessionFile == "" {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(v.SessionFile), 0700)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(v.SessionFile, b, 0600)
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcclient
+
+import (
+	"fmt"
+)
+
+// sshPageSize is the number of keys requested from the SSH inventory at once
+var sshPageSize = 100
+
+// SSHKey is a key of the TPP SSH key inventory. The same key can be found at
+// several locations, each is listed as its own key.
+type SSHKey struct {
+	KeyID     string `json:"KeyId"`
+	KeysetID  string `json:"KeysetId"`
+	Algorithm string
+	KeyLength int
+	// Fingerprint is the SHA256 fingerprint of the public key
+	Fingerprint string `json:"FingerprintSHA256"`
+	Location    string
+	Username    string
+}
+
+// ListSSHKeys returns up to limit keys of the SSH key inventory
+func (v *VcertProxy) ListSSHKeys(limit int) ([]SSHKey, error) {
+	keys := []SSHKey{}
+	for len(keys) < limit {
+		req := struct {
+			Offset   int
+			PageSize int
+		}{len(keys), sshPageSize}
+		if limit-len(keys) < req.PageSize {
+			req.PageSize = limit - len(keys)
+		}
+		var resp struct {
+			Data    []SSHKey
+			Success bool
+			Error   string
+		}
+		err := v.request("POST", "vedsdk/SSH/KeyUsage", req, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("could not list SSH keys: %s", err)
+		}
+		if !resp.Success {
+			return nil, fmt.Errorf("could not list SSH keys: %s", resp.Error)
+		}
+		keys = append(keys, resp.Data...)
+		if len(resp.Data) < req.PageSize {
+			break
+		}
+	}
+	return keys, nil
+}
@@ -15,31 +15,47 @@
 package vcclient
 
 import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
-	"github.com/Venafi/vcert/pkg/venafi/tpp"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Venafi/vcert"
 	"github.com/Venafi/vcert/pkg/certificate"
 	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/newcontext-oss/credhub-venafi/httpclient"
 	"github.com/newcontext-oss/credhub-venafi/output"
 )
 
 var origin = "NewContext Credhub-Venafi"
-var CreatedAccessToken = false
 
 // IVcertProxy defines the interface for proxies that manage requests to vcert
 type IVcertProxy interface {
 	PutCertificate(certName string, cert string, privateKey string) error
 	List(vlimit int, zone string) ([]certificate.CertificateInfo, error)
 	RetrieveCertificateByThumbprint(thumprint string) (*certificate.PEMCollection, error)
+	RetrieveCertificateByDN(dn string) (*certificate.PEMCollection, error)
+	RetrieveKeyPair(dn string) (*certificate.PEMCollection, error)
 	Login() error
 	Logout() error
-	Revoke(thumbprint string) error
+	Revoke(args *RevokeArgs) error
+	FindByThumbprint(thumbprint string) ([]string, error)
+	Delete(dn string) error
 	Generate(args *CertArgs) (*certificate.PEMCollection, error)
+	Request(args *CertArgs) (*Enrollment, error)
+	Pickup(e *Enrollment, timeout time.Duration) (*certificate.PEMCollection, error)
+	ReadZone(zone string) (*endpoint.ZoneConfiguration, error)
+	ListSSHKeys(limit int) ([]SSHKey, error)
+	CertificateDetails(dn string) (*CertificateDetails, error)
+	HoldsPrivateKey(dn string) (bool, error)
 }
 
 // VcertProxy contains the necessary config information for a vcert proxy
@@ -52,12 +68,38 @@ type VcertProxy struct {
 	Client        endpoint.Connector
 	BaseURL       string
 	ConnectorType string
+	TrustBundle   string
+	// ClientID and Scope are requested for new tokens, DefaultClientID and
+	// DefaultScope are used if they are empty
+	ClientID string
+	Scope    string
+	// SessionFile keeps the TPP tokens between runs. Without it a new token
+	// is requested by every Login and revoked by Logout.
+	SessionFile string
+	// HTTP sets the proxy and timeouts for TPP
+	HTTP httpclient.Settings
+	// PickupInterval is the first wait between pickup attempts, it doubles
+	// with every attempt
+	PickupInterval time.Duration
+
+	apiKey string
+	// createdToken is the access token Login requested for this run only,
+	// which Logout revokes
+	createdToken string
+	mu           sync.Mutex
 }
 
-// PutCertificate uploads a certificate to vcert
+// PutCertificate uploads a certificate to vcert. The name is either an
+// object name in the zone or the full DN of the object to replace.
 func (v *VcertProxy) PutCertificate(certName string, cert string, privateKey string) error {
+	policyDN := ""
+	if strings.HasPrefix(certName, "\\VED\\") {
+		i := strings.LastIndex(certName, "\\")
+		policyDN, certName = certName[:i], certName[i+1:]
+	}
 	importReq := &certificate.ImportRequest{
 		// if PolicyDN is empty, it is taken from cfg.Zone
+		PolicyDN:        policyDN,
 		ObjectName:      certName,
 		CertificateData: cert,
 		PrivateKeyData:  privateKey,
@@ -77,9 +119,41 @@ func (v *VcertProxy) PutCertificate(certName string, cert string, privateKey str
 		return err
 	}
 	output.Verbose("%+v", importResp)
+	return v.verifyCertificate(importResp.CertificateDN, cert)
+}
+
+// verifyCertificate reads back the certificate stored at dn and checks that
+// its thumbprint matches the one that was written
+func (v *VcertProxy) verifyCertificate(dn string, expected string) error {
+	want, err := thumbprint(expected)
+	if err != nil {
+		return fmt.Errorf("could not calculate thumbprint of certificate written to %s: %s", dn, err)
+	}
+	pcc, err := v.Client.RetrieveCertificate(&certificate.Request{PickupID: dn})
+	if err != nil {
+		return fmt.Errorf("could not read back certificate %s from Venafi: %s", dn, err)
+	}
+	got, err := thumbprint(pcc.Certificate)
+	if err != nil {
+		return fmt.Errorf("could not calculate thumbprint of certificate read back from %s: %s", dn, err)
+	}
+	if got != want {
+		return fmt.Errorf("verification of %s failed: Venafi has thumbprint %s, expected %s", dn, got, want)
+	}
+	output.Verbose("verified %s in Venafi with thumbprint %s", dn, got)
 	return nil
 }
 
+// thumbprint returns the SHA-1 thumbprint of the first certificate in a PEM
+// string as upper case hex, the format used by TPP
+func thumbprint(certPEM string) (string, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return "", fmt.Errorf("could not decode certificate PEM")
+	}
+	return fmt.Sprintf("%X", sha1.Sum(block.Bytes)), nil
+}
+
 // List retrieves the list of certificates from vcert
 func (v *VcertProxy) List(limit int, zone string) ([]certificate.CertificateInfo, error) {
 	output.Info("vcert list from proxy")
@@ -107,10 +181,57 @@ func (v *VcertProxy) RetrieveCertificateByThumbprint(thumprint string) (*certifi
 	return v.Client.RetrieveCertificate(pickupReq)
 }
 
+// RetrieveCertificateByDN fetches the certificate of a vcert object
+func (v *VcertProxy) RetrieveCertificateByDN(dn string) (*certificate.PEMCollection, error) {
+	pickupReq := &certificate.Request{
+		PickupID: dn,
+	}
+
+	return v.Client.RetrieveCertificate(pickupReq)
+}
+
+// RetrieveKeyPair fetches the certificate, chain and private key of a vcert
+// object. TPP only holds the key of certificates it generated the CSR for.
+func (v *VcertProxy) RetrieveKeyPair(dn string) (*certificate.PEMCollection, error) {
+	password, err := exportPassword()
+	if err != nil {
+		return nil, err
+	}
+	pcc, err := v.Client.RetrieveCertificate(&certificate.Request{
+		PickupID:        dn,
+		FetchPrivateKey: true,
+		KeyPassword:     password,
+		ChainOption:     certificate.ChainOptionRootLast,
+	})
+	if err != nil {
+		return nil, err
+	}
+	pcc.PrivateKey, err = DecryptPrivateKey(pcc.PrivateKey, password)
+	if err != nil {
+		return nil, fmt.Errorf("could not read private key of %s: %s", dn, err)
+	}
+	return pcc, nil
+}
+
+// exportPassword returns a random password that protects a private key on
+// its way from TPP and meets the TPP password rules
+func exportPassword() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "Cv1!" + hex.EncodeToString(b), nil
+}
+
 // Login creates a session with the TPP server
 func (v *VcertProxy) Login() error {
 	var connectorType endpoint.ConnectorType
 	auth := endpoint.Authentication{}
+	client, err := v.httpClient()
+	if err != nil {
+		return err
+	}
 
 	switch v.ConnectorType {
 	case "tpp":
@@ -128,33 +249,26 @@ func (v *VcertProxy) Login() error {
 				Password: v.Password,
 			}
 		} else {
-			connector, err := tpp.NewConnector(v.BaseURL, v.Zone, false, nil)
+			token, err := v.sessionToken(client)
 			if err != nil {
-				return fmt.Errorf("could not create tpp client: %s", err)
+				return err
 			}
-
-			resp, err := connector.GetRefreshToken(&endpoint.Authentication{
-				User: v.Username, Password: v.Password, ClientId: "vault-venafi",
-				Scope: "certificate:manage,delete,discover"})
-			if err != nil {
-				return fmt.Errorf("could not fetch access token. Enable legacy auth support: %s", err)
-			}
-			CreatedAccessToken = true
-			v.AccessToken = resp.Access_token
+			v.AccessToken = token
 			auth = endpoint.Authentication{
-				AccessToken: resp.Access_token,
+				AccessToken: token,
 			}
-			output.Info("vcert created access token\n")
 		}
 	default:
 		return fmt.Errorf("connector type '%s' not found", v.ConnectorType)
 	}
 
 	conf := vcert.Config{
-		Credentials:   &auth,
-		BaseUrl:       v.BaseURL,
-		Zone:          v.Zone,
-		ConnectorType: connectorType,
+		Credentials:     &auth,
+		BaseUrl:         v.BaseURL,
+		Zone:            v.Zone,
+		ConnectorType:   connectorType,
+		ConnectionTrust: v.TrustBundle,
+		Client:          client,
 	}
 
 	c, err := vcert.NewClient(&conf)
@@ -166,44 +280,51 @@ func (v *VcertProxy) Login() error {
 	return nil
 }
 
-// Revoke revokes a certificate in vcert (delete is not available via the api)
-func (v *VcertProxy) Revoke(thumbprint string) error {
-	revokeReq := &certificate.RevocationRequest{
-		// CertificateDN: requestID,
-		Thumbprint: thumbprint,
-		Reason:     "key-compromise",
-		Comments:   "revocation comment below",
-		Disable:    false,
-	}
-
-	err := v.Client.RevokeCertificate(revokeReq)
-	if err != nil {
-		return err
-	}
+// RevocationReasons maps the reasons accepted by cv to the names used by vcert
+var RevocationReasons = map[string]string{
+	"unspecified":            "none",
+	"key-compromise":         "key-compromise",
+	"ca-compromise":          "ca-compromise",
+	"affiliation-changed":    "affiliation-changed",
+	"superseded":             "superseded",
+	"cessation":              "cessation-of-operation",
+	"cessation-of-operation": "cessation-of-operation",
+}
 
-	output.Verbose("Successfully submitted revocation request for thumbprint %s", thumbprint)
-	return nil
+// RevokeArgs contains the arguments for a revocation. The certificate is
+// selected by DN if set, otherwise by thumbprint.
+type RevokeArgs struct {
+	DN         string
+	Thumbprint string
+	Reason     string
+	Comment    string
+	Disable    bool
 }
 
-func sendCertificateRequest(c endpoint.Connector, enrollReq *certificate.Request) (requestID string, privateKey string, err error) {
-	err = c.GenerateRequest(nil, enrollReq)
-	if err != nil {
-		return "", "", err
+// Revoke revokes a certificate in vcert and optionally disables it
+func (v *VcertProxy) Revoke(args *RevokeArgs) error {
+	reason, ok := RevocationReasons[args.Reason]
+	if args.Reason == "" {
+		reason, ok = "none", true
 	}
-
-	requestID, err = c.RequestCertificate(enrollReq)
-	if err != nil {
-		return "", "", err
+	if !ok {
+		return fmt.Errorf("unknown revocation reason '%s'", args.Reason)
+	}
+	revokeReq := &certificate.RevocationRequest{
+		CertificateDN: args.DN,
+		Thumbprint:    args.Thumbprint,
+		Reason:        reason,
+		Comments:      args.Comment,
+		Disable:       args.Disable,
 	}
 
-	pemBlock, err := certificate.GetPrivateKeyPEMBock(enrollReq.PrivateKey)
+	err := v.Client.RevokeCertificate(revokeReq)
 	if err != nil {
-		return "", "", err
+		return err
 	}
-	privateKey = string(pem.EncodeToMemory(pemBlock))
 
-	output.Verbose("Successfully submitted certificate request. Will pickup certificate by ID %s", requestID)
-	return requestID, privateKey, nil
+	output.Verbose("Successfully submitted revocation request for %s%s", args.DN, args.Thumbprint)
+	return nil
 }
 
 // PrependPolicyRoot adds \Policy\ to the front of the zone string
@@ -219,25 +340,49 @@ func prependVEDRoot(zone string) string {
 	return "\\VED\\" + zone
 }
 
-// logout revokes a access token in tpp (delete is not available via the tpp client library)
-func (p *VcertProxy) Logout() error {
-	if CreatedAccessToken {
-		var bearer = "Bearer " + p.AccessToken
-		req, err := http.NewRequest("GET", p.BaseURL+"/vedauth/revoke/token", nil)
-		if err != nil {
-			return fmt.Errorf("could not connect to access token endpoint: %s", err)
-		}
-		req.Header.Set("Authorization", bearer)
-
-		// Send req using http Client
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			return fmt.Errorf("could not connect to access token endpoint endpoint: %s", err)
-		}
+// Logout revokes the access token requested by Login for this run. Tokens
+// from the config file or a cached session stay valid. Calling it again after
+// a successful revocation has no effect.
+func (v *VcertProxy) Logout() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.createdToken == "" {
+		return nil
+	}
 
-		defer resp.Body.Close()
-		output.Info("vcert revoking created access token")
+	// TPP revokes the token a GET is authorized with
+	req, err := http.NewRequest(http.MethodGet, v.apiURL("vedauth/revoke/token"), nil)
+	if err != nil {
+		return err
 	}
+	req.Header.Set("Authorization", "Bearer "+v.createdToken)
+	client, err := v.httpClient()
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not revoke access token: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("could not revoke access token: %s %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	v.createdToken = ""
+	output.Info("vcert revoked created access token\n")
 	return nil
 }
+
+// trustPool returns the certificate pool built from the trust bundle, or nil
+// to use the system roots
+func (v *VcertProxy) trustPool() (*x509.CertPool, error) {
+	if v.TrustBundle == "" {
+		return nil, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(v.TrustBundle)) {
+		return nil, fmt.Errorf("could not parse trust bundle")
+	}
+	return pool, nil
+}
@@ -0,0 +1,564 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcclient_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+
+	"github.com/newcontext-oss/credhub-venafi/retry"
+	"github.com/newcontext-oss/credhub-venafi/vcclient"
+	"github.com/newcontext-oss/credhub-venafi/vcclient/vcfake"
+	"github.com/stretchr/testify/assert"
+)
+
+const testZone = "Certificates\\Test"
+
+func newFakeTPP(t *testing.T) (*vcfake.Server, *vcclient.VcertProxy) {
+	server := vcfake.NewServer()
+	t.Cleanup(server.Close)
+	server.AddUser("tppadmin", "password")
+	server.AddZone(testZone, vcfake.OpenPolicy())
+
+	v := &vcclient.VcertProxy{
+		Username:      "tppadmin",
+		Password:      "password",
+		Zone:          testZone,
+		BaseURL:       server.URL,
+		ConnectorType: "tpp",
+		TrustBundle:   server.TrustBundle(),
+	}
+	if err := v.Login(); err != nil {
+		t.Fatal(err)
+	}
+	return server, v
+}
+
+func TestLogin(t *testing.T) {
+	server, v := newFakeTPP(t)
+	assert.True(t, server.TokenValid(v.AccessToken), "It should obtain an access token")
+	clientID, scope, _ := server.TokenGrant(v.AccessToken)
+	assert.Equal(t, "vault-venafi", clientID)
+	assert.Equal(t, "certificate:manage,delete,discover", scope)
+}
+
+func TestLoginSession(t *testing.T) {
+	server, _ := newFakeTPP(t)
+	sessionFile := filepath.Join(t.TempDir(), "venafi.json")
+	login := func() *vcclient.VcertProxy {
+		v := &vcclient.VcertProxy{Username: "tppadmin", Password: "password", Zone: testZone, BaseURL: server.URL, ConnectorType: "tpp", TrustBundle: server.TrustBundle(),
+			ClientID: "cv", Scope: "certificate:manage", SessionFile: sessionFile}
+		assert.Nil(t, v.Login())
+		return v
+	}
+
+	first := login()
+	clientID, scope, _ := server.TokenGrant(first.AccessToken)
+	assert.Equal(t, "cv", clientID, "It should request the configured client id")
+	assert.Equal(t, "certificate:manage", scope, "It should request the configured scope")
+	assert.Nil(t, first.Logout())
+	assert.True(t, server.TokenValid(first.AccessToken), "It should keep a cached token on logout")
+
+	second := login()
+	assert.Equal(t, first.AccessToken, second.AccessToken, "It should reuse the cached token")
+	assert.Equal(t, 2, server.Count(http.MethodPost, "/vedauth/authorize/oauth"), "It should not request another grant")
+
+	b, err := ioutil.ReadFile(sessionFile)
+	assert.Nil(t, err, "It should write the session file")
+	s := vcclient.Session{}
+	assert.Nil(t, json.Unmarshal(b, &s))
+	s.Expires = time.Now().Add(-time.Minute)
+	b, _ = json.Marshal(&s)
+	assert.Nil(t, ioutil.WriteFile(sessionFile, b, 0600))
+
+	refreshed := login()
+	assert.NotEqual(t, first.AccessToken, refreshed.AccessToken, "It should refresh an expired token")
+	assert.True(t, server.TokenValid(refreshed.AccessToken))
+	assert.Equal(t, 1, server.Count(http.MethodPost, "/vedauth/authorize/token"))
+	assert.Equal(t, refreshed.AccessToken, login().AccessToken, "It should cache the refreshed token")
+
+	noPassword := &vcclient.VcertProxy{Zone: testZone, BaseURL: server.URL, ConnectorType: "tpp", TrustBundle: server.TrustBundle(), SessionFile: filepath.Join(t.TempDir(), "venafi.json")}
+	assert.NotNil(t, noPassword.Login(), "It should require a session or credentials")
+}
+
+func TestLoginBadPassword(t *testing.T) {
+	server := vcfake.NewServer()
+	defer server.Close()
+	server.AddUser("tppadmin", "password")
+
+	v := &vcclient.VcertProxy{Username: "tppadmin", Password: "wrong", Zone: testZone, BaseURL: server.URL, ConnectorType: "tpp", TrustBundle: server.TrustBundle()}
+	assert.NotNil(t, v.Login(), "It should raise an error with bad credentials")
+}
+
+func TestLoginUntrusted(t *testing.T) {
+	server := vcfake.NewServer()
+	defer server.Close()
+	server.AddUser("tppadmin", "password")
+
+	v := &vcclient.VcertProxy{Username: "tppadmin", Password: "password", Zone: testZone, BaseURL: server.URL, ConnectorType: "tpp"}
+	assert.NotNil(t, v.Login(), "It should not trust the server without a trust bundle")
+}
+
+func TestLoginLegacy(t *testing.T) {
+	server := vcfake.NewServer()
+	defer server.Close()
+	server.AddUser("tppadmin", "password")
+	server.AddZone(testZone, vcfake.OpenPolicy())
+
+	v := &vcclient.VcertProxy{Username: "tppadmin", Password: "password", LegacyAuth: true, Zone: testZone, BaseURL: server.URL, ConnectorType: "tpp", TrustBundle: server.TrustBundle()}
+	assert.Nil(t, v.Login(), "It should authorize with an API key")
+	_, err := v.List(10, vcclient.PrependPolicyRoot(testZone))
+	assert.Nil(t, err, "It should use the API key")
+}
+
+func TestGenerate(t *testing.T) {
+	server, v := newFakeTPP(t)
+
+	pcc, err := v.Generate(&vcclient.CertArgs{Name: "generated", CommonName: "generated.example.com", SANDNS: []string{"www.example.com"}})
+	assert.Nil(t, err, "It should generate a certificate")
+	assert.Contains(t, pcc.Certificate, "BEGIN CERTIFICATE")
+	assert.Contains(t, pcc.PrivateKey, "PRIVATE KEY")
+	assert.Equal(t, []string{server.CA()}, pcc.Chain, "It should return the chain")
+
+	o, ok := server.Object("\\VED\\Policy\\" + testZone + "\\generated")
+	assert.True(t, ok, "It should store the certificate in the zone")
+	assert.Equal(t, "NewContext Credhub-Venafi", o.Origin)
+}
+
+func TestGeneratePending(t *testing.T) {
+	server, v := newFakeTPP(t)
+	server.SetPending(1)
+
+	pcc, err := v.Generate(&vcclient.CertArgs{CommonName: "pending.example.com"})
+	assert.Nil(t, err, "It should wait for a pending certificate")
+	assert.Contains(t, pcc.Certificate, "BEGIN CERTIFICATE")
+	// one pending poll and the pickup
+	assert.Equal(t, 2, server.Count(http.MethodPost, "/vedsdk/certificates/retrieve"))
+}
+
+func TestRequestAndPickup(t *testing.T) {
+	server, v := newFakeTPP(t)
+	server.SetPending(2)
+	v.PickupInterval = 10 * time.Millisecond
+
+	e, err := v.Request(&vcclient.CertArgs{CommonName: "approval.example.com"})
+	assert.Nil(t, err, "It should submit the request")
+	assert.Contains(t, e.PrivateKey, "PRIVATE KEY", "It should keep the local key for the pickup")
+
+	_, err = v.Pickup(e, 0)
+	assert.IsType(t, &vcclient.PendingError{}, err, "It should report a pending certificate")
+
+	pcc, err := v.Pickup(e, time.Second)
+	assert.Nil(t, err, "It should retry until the certificate is issued")
+	assert.Equal(t, e.PrivateKey, pcc.PrivateKey)
+}
+
+func TestGenerateErrors(t *testing.T) {
+	server, v := newFakeTPP(t)
+
+	server.Fail(http.MethodPost, "/vedsdk/certificates/request", http.StatusInternalServerError, `{"Error":"boom"}`)
+	_, err := v.Generate(&vcclient.CertArgs{CommonName: "failed.example.com"})
+	assert.NotNil(t, err, "It should raise an error when the request fails")
+
+	server.ExpireTokens()
+	_, err = v.Generate(&vcclient.CertArgs{CommonName: "expired.example.com"})
+	assert.NotNil(t, err, "It should raise an error when the token has expired")
+	assert.Empty(t, server.Objects())
+}
+
+func TestRetryProxy(t *testing.T) {
+	server, v := newFakeTPP(t)
+	r := retry.New("Venafi", retry.Policy{Attempts: 3, Budget: 10})
+	r.Sleep = func(time.Duration) {}
+	rp := &vcclient.RetryProxy{Proxy: v, Retrier: r}
+
+	server.Fail(http.MethodPost, "/vedsdk/certificates/request", http.StatusInternalServerError, `{"Error":"boom"}`)
+	_, err := rp.Generate(&vcclient.CertArgs{CommonName: "once.example.com"})
+	assert.NotNil(t, err, "It should not repeat a request the server may have processed")
+
+	server.Fail(http.MethodPost, "/vedsdk/certificates/request", http.StatusServiceUnavailable, `{"Error":"maintenance"}`)
+	server.Fail(http.MethodPost, "/vedsdk/certificates/retrieve", http.StatusBadGateway, `{"Error":"bad gateway"}`)
+	_, err = rp.Generate(&vcclient.CertArgs{CommonName: "retried.example.com"})
+	assert.Nil(t, err, "It should retry a refused request and a failed pickup")
+	assert.Len(t, server.Objects(), 1, "It should only submit the request once")
+	assert.Equal(t, 2, r.Retries())
+}
+
+func parseCert(t *testing.T, certPEM string) *x509.Certificate {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		t.Fatal("could not decode certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestGenerateKeyParameters(t *testing.T) {
+	_, v := newFakeTPP(t)
+
+	pcc, err := v.Generate(&vcclient.CertArgs{CommonName: "rsa.example.com", KeyLength: 3072})
+	assert.Nil(t, err, "It should generate an RSA certificate")
+	assert.Equal(t, 3072, parseCert(t, pcc.Certificate).PublicKey.(*rsa.PublicKey).N.BitLen())
+
+	pcc, err = v.Generate(&vcclient.CertArgs{CommonName: "ec.example.com", KeyType: certificate.KeyTypeECDSA, KeyCurve: certificate.EllipticCurveP384})
+	assert.Nil(t, err, "It should generate an ECDSA certificate")
+	assert.Equal(t, "P-384", parseCert(t, pcc.Certificate).PublicKey.(*ecdsa.PublicKey).Curve.Params().Name)
+	assert.Contains(t, pcc.PrivateKey, "EC PRIVATE KEY")
+}
+
+func TestGenerateKeyPolicy(t *testing.T) {
+	server, v := newFakeTPP(t)
+	policy := vcfake.OpenPolicy()
+	policy.KeyPair.KeyAlgorithm = vcfake.Value{Locked: true, Value: "RSA"}
+	policy.KeyPair.KeySize.Locked = true
+	policy.KeyPair.KeySize.Value = 4096
+	server.AddZone(testZone, policy)
+
+	_, err := v.Generate(&vcclient.CertArgs{CommonName: "small.example.com", KeyLength: 2048})
+	assert.NotNil(t, err, "It should not let the zone change the key size")
+	_, err = v.Generate(&vcclient.CertArgs{CommonName: "ec.example.com", KeyType: certificate.KeyTypeECDSA})
+	assert.NotNil(t, err, "It should not let the zone change the key type")
+	assert.Empty(t, server.Objects())
+}
+
+func TestGeneratePolicyViolations(t *testing.T) {
+	server, v := newFakeTPP(t)
+	policy := vcfake.OpenPolicy()
+	policy.WhitelistedDomains = []string{"example.com"}
+	policy.WildcardsAllowed = false
+	policy.SubjAltNameIpAllowed = false
+	policy.Subject.Organization = vcfake.Value{Locked: true, Value: "Acme"}
+	policy.KeyPair.KeyAlgorithm = vcfake.Value{Locked: true, Value: "RSA"}
+	policy.KeyPair.KeySize.Locked = true
+	policy.KeyPair.KeySize.Value = 4096
+	server.AddZone(testZone, policy)
+
+	_, err := v.Generate(&vcclient.CertArgs{
+		CommonName:       "bad.example.org",
+		SANDNS:           []string{"*.example.com"},
+		SANIP:            []net.IP{net.ParseIP("10.0.0.1")},
+		OrganizationName: "Evil",
+		KeyLength:        2048,
+	})
+	assert.NotNil(t, err, "It should refuse a request that violates the policy")
+	policyErr, ok := err.(*vcclient.PolicyError)
+	assert.True(t, ok, "It should report a policy error")
+	assert.Len(t, policyErr.Violations, 5, "It should report every violation: %s", err)
+	assert.Equal(t, 0, server.Count(http.MethodPost, "/vedsdk/certificates/request"), "It should not submit the request")
+
+	_, err = v.Generate(&vcclient.CertArgs{CommonName: "good.example.com", OrganizationName: "Acme", KeyLength: 4096})
+	assert.Nil(t, err, "It should accept a request within the policy")
+}
+
+func TestReadZone(t *testing.T) {
+	server, v := newFakeTPP(t)
+	policy := vcfake.OpenPolicy()
+	policy.WhitelistedDomains = []string{"other.com"}
+	server.AddZone("Other", policy)
+
+	z, err := v.ReadZone("Other")
+	assert.Nil(t, err, "It should read another zone")
+	assert.Len(t, z.SubjectCNRegexes, 1)
+	assert.Contains(t, z.SubjectCNRegexes[0], "other")
+
+	z, err = v.ReadZone("")
+	assert.Nil(t, err, "It should read the configured zone")
+	assert.Equal(t, []string{".*"}, z.SubjectCNRegexes)
+
+	_, err = v.ReadZone("Missing")
+	assert.NotNil(t, err, "It should raise an error for a missing zone")
+}
+
+func TestGenerateValidity(t *testing.T) {
+	_, v := newFakeTPP(t)
+
+	pcc, err := v.Generate(&vcclient.CertArgs{CommonName: "short.example.com", ValidityHours: 48})
+	assert.Nil(t, err, "It should generate a certificate with a validity")
+	notAfter := parseCert(t, pcc.Certificate).NotAfter
+	assert.WithinDuration(t, time.Now().Add(48*time.Hour), notAfter, 5*time.Minute)
+
+	_, err = v.Generate(&vcclient.CertArgs{CommonName: "hint.example.com", ValidityHours: 48, IssuerHint: "microsoft"})
+	assert.Nil(t, err, "It should accept a known issuer hint")
+	_, err = v.Generate(&vcclient.CertArgs{CommonName: "hint.example.com", ValidityHours: 48, IssuerHint: "unknown"})
+	assert.NotNil(t, err, "It should reject an unknown issuer hint")
+}
+
+func TestGenerateServiceCSR(t *testing.T) {
+	_, v := newFakeTPP(t)
+
+	_, err := v.Generate(&vcclient.CertArgs{CommonName: "service.example.com", CsrOrigin: certificate.ServiceGeneratedCSR})
+	assert.NotNil(t, err, "It should require a key password")
+
+	pcc, err := v.Generate(&vcclient.CertArgs{CommonName: "service.example.com", CsrOrigin: certificate.ServiceGeneratedCSR, KeyPassword: "secret"})
+	assert.Nil(t, err, "It should generate the key in Venafi")
+	assert.NotContains(t, pcc.PrivateKey, "ENCRYPTED", "It should decrypt the private key")
+	_, err = tls.X509KeyPair([]byte(pcc.Certificate), []byte(pcc.PrivateKey))
+	assert.Nil(t, err, "It should return the matching private key")
+}
+
+func TestGenerateUserCSR(t *testing.T) {
+	server, v := newFakeTPP(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "user.example.com"}}, key)
+	assert.Nil(t, err)
+	csr := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+
+	_, err = v.Generate(&vcclient.CertArgs{Name: "user", CsrOrigin: certificate.UserProvidedCSR})
+	assert.NotNil(t, err, "It should require a CSR")
+
+	pcc, err := v.Generate(&vcclient.CertArgs{Name: "user", CsrOrigin: certificate.UserProvidedCSR, CSR: csr})
+	assert.Nil(t, err, "It should sign the CSR")
+	assert.Empty(t, pcc.PrivateKey)
+	assert.Equal(t, key.N, parseCert(t, pcc.Certificate).PublicKey.(*rsa.PublicKey).N)
+	_, ok := server.Object("\\VED\\Policy\\" + testZone + "\\user")
+	assert.True(t, ok)
+}
+
+func TestPutCertificateAndRetrieve(t *testing.T) {
+	server, v := newFakeTPP(t)
+	pcc, err := v.Generate(&vcclient.CertArgs{CommonName: "source.example.com"})
+	assert.Nil(t, err)
+
+	err = v.PutCertificate("imported", pcc.Certificate, pcc.PrivateKey)
+	assert.Nil(t, err, "It should import a certificate")
+	o, ok := server.Object("\\VED\\Policy\\" + testZone + "\\imported")
+	assert.True(t, ok, "It should store the imported certificate")
+
+	found, err := v.RetrieveCertificateByThumbprint(o.Thumbprint)
+	assert.NotNil(t, err, "It should refuse to retrieve an ambiguous thumbprint")
+
+	server.Fail(http.MethodPost, "/vedsdk/certificates/import", http.StatusBadRequest, `{"Error":"import failed"}`)
+	assert.NotNil(t, v.PutCertificate("other", pcc.Certificate, pcc.PrivateKey), "It should raise an error when the import fails")
+
+	other, err := v.Generate(&vcclient.CertArgs{CommonName: "unique.example.com"})
+	assert.Nil(t, err)
+	o, _ = server.Object("\\VED\\Policy\\" + testZone + "\\unique.example.com")
+	found, err = v.RetrieveCertificateByThumbprint(o.Thumbprint)
+	assert.Nil(t, err, "It should retrieve a certificate by thumbprint")
+	assert.Equal(t, other.Certificate, found.Certificate)
+}
+
+func TestPutCertificateVerification(t *testing.T) {
+	server, v := newFakeTPP(t)
+	pcc, err := v.Generate(&vcclient.CertArgs{CommonName: "verified.example.com"})
+	assert.Nil(t, err)
+	other, err := v.Generate(&vcclient.CertArgs{CommonName: "other.example.com"})
+	assert.Nil(t, err)
+
+	stale := fmt.Sprintf(`{"CertificateData":"%s","Format":"Base64"}`, base64.StdEncoding.EncodeToString([]byte(other.Certificate)))
+	server.Fail(http.MethodPost, "/vedsdk/certificates/retrieve", http.StatusOK, stale)
+	err = v.PutCertificate("imported", pcc.Certificate, pcc.PrivateKey)
+	assert.NotNil(t, err, "It should raise an error when the stored certificate does not match")
+	assert.Contains(t, err.Error(), "verification of")
+}
+
+func TestListPaging(t *testing.T) {
+	server, v := newFakeTPP(t)
+	pcc, err := v.Generate(&vcclient.CertArgs{CommonName: "first.example.com"})
+	assert.Nil(t, err)
+	for i := 0; i < 510; i++ {
+		_, err := server.AddCertificate(testZone, fmt.Sprintf("copy%d", i), pcc.Certificate, "")
+		assert.Nil(t, err)
+	}
+	server.AddZone("Other", vcfake.OpenPolicy())
+	server.AddCertificate("Other", "elsewhere", pcc.Certificate, "")
+
+	certs, err := v.List(1000, vcclient.PrependPolicyRoot(testZone))
+	assert.Nil(t, err, "It should list certificates")
+	assert.Len(t, certs, 511, "It should page through all certificates in the zone")
+	assert.Equal(t, "first.example.com", certs[0].CN)
+	assert.Equal(t, 2, server.Count(http.MethodGet, "/vedsdk/certificates/"))
+
+	certs, err = v.List(5, vcclient.PrependPolicyRoot(testZone))
+	assert.Nil(t, err)
+	assert.Len(t, certs, 5, "It should honor the limit")
+}
+
+func TestListSSHKeys(t *testing.T) {
+	server, v := newFakeTPP(t)
+	for i := 0; i < 150; i++ {
+		server.AddSSHKey(vcfake.SSHKey{Algorithm: "RSA", KeyLength: 2048, FingerprintSHA256: fmt.Sprintf("SHA256:key%d", i), Location: "host.example.com", Username: "deploy"})
+	}
+
+	keys, err := v.ListSSHKeys(1000)
+	assert.Nil(t, err, "It should list the SSH key inventory")
+	assert.Len(t, keys, 150, "It should page through all keys")
+	assert.Equal(t, "SHA256:key0", keys[0].Fingerprint)
+	assert.Equal(t, "deploy", keys[0].Username)
+	assert.NotEmpty(t, keys[0].KeyID)
+	assert.Equal(t, 2, server.Count(http.MethodPost, "/vedsdk/ssh/keyusage"))
+
+	keys, err = v.ListSSHKeys(5)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 5, "It should honor the limit")
+
+	server.Fail(http.MethodPost, "/vedsdk/ssh/keyusage", http.StatusForbidden, `{"Error":"insufficient scope"}`)
+	_, err = v.ListSSHKeys(5)
+	assert.NotNil(t, err, "It should raise an error when the inventory can't be read")
+	assert.Contains(t, err.Error(), "insufficient scope")
+}
+
+func TestRevoke(t *testing.T) {
+	server, v := newFakeTPP(t)
+	_, err := v.Generate(&vcclient.CertArgs{CommonName: "revoked.example.com"})
+	assert.Nil(t, err)
+	o := server.Objects()[0]
+
+	assert.Nil(t, v.Revoke(&vcclient.RevokeArgs{Thumbprint: o.Thumbprint, Reason: "superseded", Comment: "replaced"}), "It should revoke a certificate")
+	o, _ = server.Object(o.DN)
+	assert.True(t, o.Revoked)
+	assert.False(t, o.Disabled)
+	assert.Equal(t, 4, o.RevocationReason)
+	assert.Equal(t, "replaced", o.Comments)
+
+	_, err = v.Generate(&vcclient.CertArgs{CommonName: "disabled.example.com"})
+	assert.Nil(t, err)
+	dn := "\\VED\\Policy\\" + testZone + "\\disabled.example.com"
+	assert.Nil(t, v.Revoke(&vcclient.RevokeArgs{DN: dn, Reason: "cessation", Disable: true}), "It should revoke a certificate by DN")
+	o, _ = server.Object(dn)
+	assert.True(t, o.Revoked && o.Disabled, "It should disable the certificate")
+	assert.Equal(t, 5, o.RevocationReason)
+
+	assert.NotNil(t, v.Revoke(&vcclient.RevokeArgs{Thumbprint: "0000"}), "It should raise an error for an unknown thumbprint")
+	assert.NotNil(t, v.Revoke(&vcclient.RevokeArgs{DN: dn, Reason: "bored"}), "It should raise an error for an unknown reason")
+}
+
+func TestRetrieveKeyPair(t *testing.T) {
+	server, v := newFakeTPP(t)
+	pcc, err := v.Generate(&vcclient.CertArgs{CommonName: "exported.example.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, v.PutCertificate("with-key", pcc.Certificate, pcc.PrivateKey))
+	o, _ := server.Object("\\VED\\Policy\\" + testZone + "\\with-key")
+
+	exported, err := v.RetrieveKeyPair(o.DN)
+	assert.Nil(t, err, "It should retrieve the certificate with its key")
+	assert.Equal(t, strings.TrimSpace(pcc.PrivateKey), strings.TrimSpace(exported.PrivateKey), "It should decrypt the exported key")
+	assert.Equal(t, strings.TrimSpace(pcc.Certificate), strings.TrimSpace(exported.Certificate))
+	assert.NotEmpty(t, exported.Chain, "It should include the chain")
+
+	_, err = v.RetrieveKeyPair(server.Objects()[0].DN)
+	assert.NotNil(t, err, "It should raise an error when TPP holds no key")
+}
+
+func TestCertificateDetails(t *testing.T) {
+	server, v := newFakeTPP(t)
+	_, err := v.Generate(&vcclient.CertArgs{Name: "detailed", CommonName: "detailed.example.com", SANDNS: []string{"www.example.com"}})
+	assert.Nil(t, err)
+	o := server.Objects()[0]
+	assert.True(t, server.SetCustomField(o.DN, "Cost Center", "1234"))
+
+	details, err := v.CertificateDetails(o.DN)
+	assert.Nil(t, err, "It should read the details of a certificate object")
+	assert.Equal(t, "detailed.example.com", details.CN)
+	assert.Equal(t, []string{"www.example.com"}, details.SANS.DNS)
+	assert.Contains(t, details.Issuer, "CN=", "It should read the issuer")
+	assert.Equal(t, "RSA", details.KeyAlgorithm)
+	assert.Equal(t, 2048, details.KeySize)
+	assert.False(t, details.ValidTo.IsZero())
+	assert.Equal(t, []string{"1234"}, details.CustomFields["Cost Center"], "It should read the custom fields")
+
+	_, err = v.CertificateDetails("\\VED\\Policy\\missing")
+	assert.NotNil(t, err, "It should raise an error for a missing object")
+}
+
+func TestHoldsPrivateKey(t *testing.T) {
+	server, v := newFakeTPP(t)
+	pcc, err := v.Generate(&vcclient.CertArgs{Name: "local", CommonName: "local.example.com"})
+	assert.Nil(t, err)
+	held, err := v.HoldsPrivateKey(server.Objects()[0].DN)
+	assert.Nil(t, err)
+	assert.False(t, held, "It should tell that the key of a CSR generated by cv is not held")
+
+	assert.Nil(t, v.PutCertificate("imported", pcc.Certificate, pcc.PrivateKey))
+	o, _ := server.Object("\\VED\\Policy\\" + testZone + "\\imported")
+	held, err = v.HoldsPrivateKey(o.DN)
+	assert.Nil(t, err)
+	assert.True(t, held, "It should tell that an imported key is held")
+
+	_, err = v.HoldsPrivateKey("\\VED\\Policy\\missing")
+	assert.NotNil(t, err, "It should raise an error for a missing object")
+}
+
+func TestFindAndDelete(t *testing.T) {
+	server, v := newFakeTPP(t)
+	pcc, err := v.Generate(&vcclient.CertArgs{CommonName: "deleted.example.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, v.PutCertificate("copy", pcc.Certificate, pcc.PrivateKey))
+	o := server.Objects()[0]
+
+	dns, err := v.FindByThumbprint(o.Thumbprint)
+	assert.Nil(t, err, "It should search by thumbprint")
+	assert.Len(t, dns, 2, "It should find every object with the thumbprint")
+
+	assert.Nil(t, v.Delete(o.DN), "It should delete a certificate object")
+	_, ok := server.Object(o.DN)
+	assert.False(t, ok)
+	assert.Len(t, server.Objects(), 1)
+	assert.NotNil(t, v.Delete(o.DN), "It should raise an error for a missing object")
+
+	legacy := &vcclient.VcertProxy{Username: "tppadmin", Password: "password", LegacyAuth: true, Zone: testZone, BaseURL: server.URL, ConnectorType: "tpp", TrustBundle: server.TrustBundle()}
+	assert.Nil(t, legacy.Login())
+	assert.Nil(t, legacy.Delete(server.Objects()[0].DN), "It should delete using an API key")
+	assert.Empty(t, server.Objects())
+}
+
+func TestLogout(t *testing.T) {
+	server, v := newFakeTPP(t)
+	token := v.AccessToken
+
+	assert.Nil(t, v.Logout(), "It should log out")
+	assert.False(t, server.TokenValid(token), "It should revoke the access token")
+	assert.Nil(t, v.Logout(), "It should only revoke the token once")
+	assert.Equal(t, 1, server.Count(http.MethodGet, "/vedauth/revoke/token"))
+
+	configured := &vcclient.VcertProxy{AccessToken: v.AccessToken, Zone: testZone, BaseURL: server.URL, ConnectorType: "tpp", TrustBundle: server.TrustBundle()}
+	assert.Nil(t, configured.Login())
+	assert.Nil(t, configured.Logout())
+	assert.Equal(t, 1, server.Count(http.MethodGet, "/vedauth/revoke/token"), "It should not revoke a configured token")
+}
+
+func TestLogoutFailure(t *testing.T) {
+	server, v := newFakeTPP(t)
+
+	server.Fail(http.MethodGet, "/vedauth/revoke/token", http.StatusInternalServerError, "boom")
+	err := v.Logout()
+	assert.NotNil(t, err, "It should report a failed revocation")
+	assert.Contains(t, err.Error(), "500", "It should report the status")
+	assert.True(t, server.TokenValid(v.AccessToken))
+
+	assert.Nil(t, v.Logout(), "It should retry the revocation")
+	assert.False(t, server.TokenValid(v.AccessToken))
+}
@@ -0,0 +1,686 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcfake
+
+// This file contains the certificate endpoints of the fake WebSDK.
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value is a policy value that may be locked
+type Value struct {
+	Locked bool
+	Value  string
+}
+
+// Policy is the policy returned by certificates/checkpolicy. The JSON shape
+// matches the TPP WebSDK.
+type Policy struct {
+	CertificateAuthority Value
+	CsrGeneration        Value
+	KeyGeneration        Value
+	KeyPair              struct {
+		KeyAlgorithm Value
+		KeySize      struct {
+			Locked bool
+			Value  int
+		}
+		EllipticCurve Value
+	}
+	ManagementType Value
+
+	PrivateKeyReuseAllowed  bool
+	SubjAltNameDnsAllowed   bool
+	SubjAltNameEmailAllowed bool
+	SubjAltNameIpAllowed    bool
+	SubjAltNameUpnAllowed   bool
+	SubjAltNameUriAllowed   bool
+	Subject                 struct {
+		City               Value
+		Country            Value
+		Organization       Value
+		OrganizationalUnit struct {
+			Locked bool
+			Values []string
+		}
+		State Value
+	}
+	UniqueSubjectEnforced bool
+	WhitelistedDomains    []string
+	WildcardsAllowed      bool
+}
+
+// OpenPolicy returns a policy that allows all subjects, SAN types, wildcards
+// and keys
+func OpenPolicy() Policy {
+	return Policy{
+		SubjAltNameDnsAllowed:   true,
+		SubjAltNameEmailAllowed: true,
+		SubjAltNameIpAllowed:    true,
+		SubjAltNameUriAllowed:   true,
+		SubjAltNameUpnAllowed:   true,
+		WildcardsAllowed:        true,
+		PrivateKeyReuseAllowed:  true,
+	}
+}
+
+type sanItem struct {
+	Type int
+	Name string
+}
+
+func (s *Server) handleCheckPolicy(w http.ResponseWriter, r *http.Request) {
+	var body struct{ PolicyDN string }
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	policy, ok := s.zones[policyDN(body.PolicyDN)]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "PolicyDN: " + body.PolicyDN + " does not exist"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Policy": policy})
+}
+
+func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		PolicyDN        string
+		ObjectName      string
+		Subject         string
+		PKCS10          string
+		SubjectAltNames []sanItem
+		KeyAlgorithm    string
+		KeyBitSize      int
+		EllipticCurve   string
+		Origin          string
+
+		CASpecificAttributes []struct {
+			Name  string
+			Value string
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zone := policyDN(body.PolicyDN)
+	if _, ok := s.zones[zone]; !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "PolicyDN: " + body.PolicyDN + " does not exist"})
+		return
+	}
+
+	var template *x509.Certificate
+	var pub interface{}
+	var key crypto.Signer
+	var err error
+	keyPEM := ""
+	if body.PKCS10 != "" {
+		block, _ := pem.Decode([]byte(body.PKCS10))
+		if block == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "PKCS10 could not be decoded"})
+			return
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err == nil {
+			err = csr.CheckSignature()
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "PKCS10 is invalid: " + err.Error()})
+			return
+		}
+		template = &x509.Certificate{
+			Subject:        csr.Subject,
+			DNSNames:       csr.DNSNames,
+			IPAddresses:    csr.IPAddresses,
+			EmailAddresses: csr.EmailAddresses,
+			URIs:           csr.URIs,
+		}
+		pub = csr.PublicKey
+	} else {
+		key, keyPEM, err = generateKey(body.KeyAlgorithm, body.KeyBitSize, body.EllipticCurve)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+			return
+		}
+		template = &x509.Certificate{Subject: pkix.Name{CommonName: body.Subject}}
+		for _, san := range body.SubjectAltNames {
+			switch san.Type {
+			case 1:
+				template.EmailAddresses = append(template.EmailAddresses, san.Name)
+			case 2:
+				template.DNSNames = append(template.DNSNames, san.Name)
+			case 7:
+				template.IPAddresses = append(template.IPAddresses, net.ParseIP(san.Name))
+			}
+		}
+		pub = key.Public()
+	}
+	for _, attr := range body.CASpecificAttributes {
+		if !strings.HasSuffix(attr.Name, "Specific End Date") {
+			continue
+		}
+		end, err := time.Parse("2006-01-02 15:04:05", attr.Value)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"Error": attr.Name + " is invalid: " + err.Error()})
+			return
+		}
+		template.NotAfter = end
+	}
+
+	name := body.ObjectName
+	if name == "" {
+		name = template.Subject.CommonName
+	}
+	if name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "Certificate object name or subject is required"})
+		return
+	}
+
+	der, err := s.sign(template, pub)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	o, err := s.storePEM(zone+"\\"+name, certPEM, keyPEM, body.Origin)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+		return
+	}
+	o.pending = s.pending
+	writeJSON(w, http.StatusOK, map[string]string{"CertificateDN": o.DN, "Guid": o.GUID})
+}
+
+func (s *Server) handleRetrieve(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		CertificateDN     string
+		Format            string
+		Password          string
+		IncludePrivateKey bool
+		IncludeChain      bool
+		RootFirstOrder    bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[strings.ToLower(body.CertificateDN)]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "Certificate " + body.CertificateDN + " does not exist"})
+		return
+	}
+	if o.Disabled {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "Certificate " + body.CertificateDN + " is disabled"})
+		return
+	}
+	if o.pending > 0 {
+		o.pending--
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"Status": "Post CSR", "Stage": 500})
+		return
+	}
+
+	data := []string{}
+	if body.IncludeChain && body.RootFirstOrder {
+		data = append(data, s.caPEM)
+	}
+	data = append(data, o.Certificate)
+	if body.IncludeChain && !body.RootFirstOrder {
+		data = append(data, s.caPEM)
+	}
+	if body.IncludePrivateKey && o.PrivateKey != "" {
+		if body.Password == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "Password is required to export the private key"})
+			return
+		}
+		block, _ := pem.Decode([]byte(o.PrivateKey))
+		encrypted, err := x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(body.Password), x509.PEMCipherAES256)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+			return
+		}
+		data = append(data, string(pem.EncodeToMemory(encrypted)))
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"CertificateData": base64.StdEncoding.EncodeToString([]byte(strings.Join(data, ""))),
+		"Format":          "Base64",
+		"Filename":        o.DN[strings.LastIndex(o.DN, "\\")+1:] + ".cer",
+	})
+}
+
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		PolicyDN        string
+		ObjectName      string
+		CertificateData string
+		PrivateKeyData  string
+		Password        string
+		Reconcile       bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	zone := policyDN(body.PolicyDN)
+	if _, ok := s.zones[zone]; !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "PolicyDN: " + body.PolicyDN + " does not exist"})
+		return
+	}
+
+	name := body.ObjectName
+	if name == "" {
+		cert, err := parseCertificate(body.CertificateData)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+			return
+		}
+		name = cert.Subject.CommonName
+	}
+	o, err := s.storePEM(zone+"\\"+name, body.CertificateData, body.PrivateKeyData, "")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"CertificateDN":      o.DN,
+		"CertId":             o.GUID,
+		"Guid":               o.GUID,
+		"CertificateVaultId": s.nextID,
+	})
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		CertificateDN string
+		Thumbprint    string
+		Reason        int
+		Comments      string
+		Disable       bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found *object
+	if body.CertificateDN != "" {
+		found = s.objects[strings.ToLower(body.CertificateDN)]
+	} else {
+		for _, o := range s.objects {
+			if strings.EqualFold(o.Thumbprint, body.Thumbprint) {
+				found = o
+			}
+		}
+	}
+	if found == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"Success": false, "Error": "Certificate does not exist"})
+		return
+	}
+	if found.Revoked {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Requested": false, "Success": true})
+		return
+	}
+	found.Revoked = true
+	found.Disabled = body.Disable
+	found.RevocationReason = body.Reason
+	found.Comments = body.Comments
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Requested": true, "Success": true})
+}
+
+type x509Info struct {
+	CN   string
+	SANS struct {
+		DNS, Email, IP, URI, UPN []string
+	}
+	Serial     string
+	Thumbprint string
+	ValidFrom  time.Time
+	ValidTo    time.Time
+}
+
+type listedCertificate struct {
+	DN       string
+	Guid     string
+	Name     string
+	ParentDn string
+	X509     x509Info
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"Error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := []*object{}
+	for _, dn := range s.order {
+		o, ok := s.objects[dn]
+		if !ok {
+			continue
+		}
+		if parent := q.Get("ParentDNRecursive"); parent != "" && !strings.HasPrefix(strings.ToLower(o.DN), strings.ToLower(parent)+"\\") {
+			continue
+		}
+		if parent := q.Get("ParentDN"); parent != "" && !strings.EqualFold(o.DN[:strings.LastIndex(o.DN, "\\")], parent) {
+			continue
+		}
+		if tp := q.Get("Thumbprint"); tp != "" && !strings.EqualFold(o.Thumbprint, tp) {
+			continue
+		}
+		if after := q.Get("ValidToGreater"); after != "" {
+			t, err := time.Parse(time.RFC3339, after)
+			if err == nil && !o.cert.NotAfter.After(t) {
+				continue
+			}
+		}
+		matches = append(matches, o)
+	}
+
+	total := len(matches)
+	if offset, err := strconv.Atoi(q.Get("offset")); err == nil && offset > 0 {
+		if offset > len(matches) {
+			offset = len(matches)
+		}
+		matches = matches[offset:]
+	}
+	if limit, err := strconv.Atoi(q.Get("limit")); err == nil && limit >= 0 && limit < len(matches) {
+		matches = matches[:limit]
+	}
+
+	out := []listedCertificate{}
+	for _, o := range matches {
+		out = append(out, o.listed())
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Certificates": out, "TotalCount": total})
+}
+
+func (s *Server) handleCertificateGUID(w http.ResponseWriter, r *http.Request, guid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	guid = strings.Trim(guid, "{}")
+	var found *object
+	for _, o := range s.objects {
+		if strings.EqualFold(strings.Trim(o.GUID, "{}"), guid) {
+			found = o
+		}
+	}
+	if found == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "Certificate does not exist"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		details := struct {
+			x509Info
+			Issuer       string
+			KeyAlgorithm string
+			KeySize      int
+		}{x509Info: found.listed().X509, Issuer: found.cert.Issuer.String()}
+		switch pub := found.cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			details.KeyAlgorithm, details.KeySize = "RSA", pub.N.BitLen()
+		case *ecdsa.PublicKey:
+			details.KeyAlgorithm, details.KeySize = "EC", pub.Curve.Params().BitSize
+		}
+		fields := []interface{}{}
+		for name, values := range found.CustomFields {
+			fields = append(fields, map[string]interface{}{"Name": name, "Type": "1", "Value": values})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"CertificateDetails": details,
+			"CustomFields":       fields,
+			"Consumers":          []string{},
+			"DN":                 found.DN,
+			"Guid":               found.GUID,
+		})
+	case http.MethodPut:
+		var body struct {
+			AttributeData []struct {
+				Name  string
+				Value []string
+			}
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for _, a := range body.AttributeData {
+			if a.Name == "Origin" && len(a.Value) > 0 {
+				found.Origin = a.Value[0]
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Success": true})
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"Error": "method not allowed"})
+	}
+}
+
+func (s *Server) handleConfigDelete(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ObjectDN  string
+		Recursive bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.ToLower(body.ObjectDN)
+	if _, ok := s.objects[key]; !ok {
+		// TPP reports config errors in the result code with a 200 status
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Result": 400, "Error": "Object " + body.ObjectDN + " does not exist"})
+		return
+	}
+	delete(s.objects, key)
+	for i, dn := range s.order {
+		if dn == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Result": 1})
+}
+
+// handleConfigRead returns the attributes of a certificate object. Only the
+// Private Key Vault Id is known, it is set when the server holds the key.
+func (s *Server) handleConfigRead(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ObjectDN      string
+		AttributeName string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[strings.ToLower(body.ObjectDN)]
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Result": 400, "Error": "Object " + body.ObjectDN + " does not exist"})
+		return
+	}
+	values := []string{}
+	if body.AttributeName == "Private Key Vault Id" && o.PrivateKey != "" {
+		values = append(values, "1")
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Result": 1, "Values": values})
+}
+
+func (s *Server) handleDNToGUID(w http.ResponseWriter, r *http.Request) {
+	var body struct{ ObjectDN string }
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.objects[strings.ToLower(body.ObjectDN)]; ok {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ClassName": "X509 Certificate", "GUID": o.GUID, "Result": 1, "Revision": 1})
+		return
+	}
+	if _, ok := s.zones[body.ObjectDN]; ok {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ClassName": "Policy", "GUID": "{policy}", "Result": 1, "Revision": 1})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Result": 400})
+}
+
+// sign issues a certificate from the fake CA. The caller must hold s.mu.
+func (s *Server) sign(template *x509.Certificate, pub interface{}) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Minute)
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().AddDate(1, 0, 0)
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	return x509.CreateCertificate(rand.Reader, template, s.caCert, pub, s.caKey)
+}
+
+// storePEM creates or replaces the certificate object at dn. The caller must
+// hold s.mu.
+func (s *Server) storePEM(dn, certPEM, keyPEM, origin string) (*object, error) {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.ToLower(dn)
+	o, ok := s.objects[key]
+	if !ok {
+		s.nextID++
+		o = &object{}
+		o.DN = dn
+		o.GUID = fmt.Sprintf("{%08d-0000-0000-0000-000000000000}", s.nextID)
+		s.objects[key] = o
+		s.order = append(s.order, key)
+	}
+	o.cert = cert
+	o.Certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	o.PrivateKey = keyPEM
+	o.Thumbprint = thumbprint(cert)
+	o.Origin = origin
+	o.Revoked = false
+	o.Disabled = false
+	o.RevocationReason = 0
+	o.Comments = ""
+	o.pending = 0
+	return o, nil
+}
+
+func (o *object) listed() listedCertificate {
+	info := x509Info{
+		CN:         o.cert.Subject.CommonName,
+		Serial:     strings.ToUpper(o.cert.SerialNumber.Text(16)),
+		Thumbprint: o.Thumbprint,
+		ValidFrom:  o.cert.NotBefore.UTC(),
+		ValidTo:    o.cert.NotAfter.UTC(),
+	}
+	info.SANS.DNS = o.cert.DNSNames
+	info.SANS.Email = o.cert.EmailAddresses
+	for _, ip := range o.cert.IPAddresses {
+		info.SANS.IP = append(info.SANS.IP, ip.String())
+	}
+	for _, u := range o.cert.URIs {
+		info.SANS.URI = append(info.SANS.URI, u.String())
+	}
+	i := strings.LastIndex(o.DN, "\\")
+	return listedCertificate{DN: o.DN, Guid: o.GUID, Name: o.DN[i+1:], ParentDn: o.DN[:i], X509: info}
+}
+
+// generateKey creates the key for a service generated CSR
+func generateKey(algorithm string, size int, curve string) (crypto.Signer, string, error) {
+	switch algorithm {
+	case "", "RSA":
+		if size == 0 {
+			size = 2048
+		}
+		key, err := rsa.GenerateKey(rand.Reader, size)
+		if err != nil {
+			return nil, "", err
+		}
+		return key, string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})), nil
+	case "ECC":
+		curves := map[string]elliptic.Curve{"P256": elliptic.P256(), "P384": elliptic.P384(), "P521": elliptic.P521()}
+		c, ok := curves[curve]
+		if !ok {
+			return nil, "", errors.New("EllipticCurve " + curve + " is not supported")
+		}
+		key, err := ecdsa.GenerateKey(c, rand.Reader)
+		if err != nil {
+			return nil, "", err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, "", err
+		}
+		return key, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+	}
+	return nil, "", errors.New("KeyAlgorithm " + algorithm + " is not supported")
+}
+
+func parseCertificate(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		der, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, errors.New("certificate data could not be decoded")
+		}
+		return x509.ParseCertificate(der)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func thumbprint(cert *x509.Certificate) string {
+	return strings.ToUpper(fmt.Sprintf("%x", sha1.Sum(cert.Raw)))
+}
@@ -0,0 +1,504 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vcfake provides an in-memory Venafi TPP WebSDK server for tests.
+// It implements the subset of the WebSDK used by the vcert TPP connector:
+// oauth and legacy authorization, policy, request, retrieve, import, revoke,
+// certificate listing and search, object deletion and token revocation.
+package vcfake
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake TPP server backed by in-memory storage. It always uses
+// TLS since the vcert TPP connector only talks https.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	users   map[string]string
+	tokens  map[string]*token
+	apiKeys map[string]bool
+	zones   map[string]Policy
+	objects map[string]*object
+	order   []string
+	sshKeys []SSHKey
+	faults  []fault
+	counts  map[string]int
+	pending int
+	nextID  int
+	caCert  *x509.Certificate
+	caKey   *rsa.PrivateKey
+	caPEM   string
+}
+
+type token struct {
+	access   string
+	refresh  string
+	clientID string
+	scope    string
+	expired  bool
+	revoked  bool
+}
+
+type fault struct {
+	method string
+	path   string
+	status int
+	body   string
+	delay  time.Duration
+}
+
+// Object describes a certificate object stored in the fake server
+type Object struct {
+	DN               string
+	GUID             string
+	Thumbprint       string
+	Certificate      string
+	PrivateKey       string
+	Origin           string
+	Revoked          bool
+	Disabled         bool
+	RevocationReason int
+	Comments         string
+	// CustomFields are the values of the custom fields by field name
+	CustomFields map[string][]string
+}
+
+type object struct {
+	Object
+	cert    *x509.Certificate
+	pending int
+}
+
+// NewServer starts a fake TPP server. Callers must call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		users:   map[string]string{},
+		tokens:  map[string]*token{},
+		apiKeys: map[string]bool{},
+		zones:   map[string]Policy{},
+		objects: map[string]*object{},
+		counts:  map[string]int{},
+	}
+	if err := s.createCA(); err != nil {
+		panic(fmt.Sprintf("vcfake: could not create CA: %s", err))
+	}
+	s.Server = httptest.NewTLSServer(s.handler())
+	return s
+}
+
+// TrustBundle returns the PEM encoded certificate of the server for use as a
+// connection trust bundle
+func (s *Server) TrustBundle() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}))
+}
+
+// CA returns the PEM encoded CA certificate used to sign issued certificates
+func (s *Server) CA() string {
+	return s.caPEM
+}
+
+// AddUser registers a user for oauth and legacy authorization
+func (s *Server) AddUser(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[username] = password
+}
+
+// AddZone creates a policy folder that certificates can be requested or
+// imported into
+func (s *Server) AddZone(zone string, policy Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zones[policyDN(zone)] = policy
+}
+
+// ExpireTokens marks every issued access token as expired
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		t.expired = true
+	}
+}
+
+// TokenValid reports whether an access token is known, unexpired and not
+// revoked
+func (s *Server) TokenValid(access string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[access]
+	return ok && !t.expired && !t.revoked
+}
+
+// TokenGrant returns the client id and scope an access token was issued for
+func (s *Server) TokenGrant(access string) (clientID string, scope string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[access]
+	if !ok {
+		return "", "", false
+	}
+	return t.clientID, t.scope, true
+}
+
+// Fail makes the next request matching method and path return the given
+// status code and body. The path is relative to the server root, for example
+// "/vedsdk/certificates/request".
+func (s *Server) Fail(method, path string, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault{method: method, path: strings.ToLower(path), status: status, body: body})
+}
+
+// Delay makes the next request matching method and path wait for d before it
+// is handled, to simulate a slow or hung server
+func (s *Server) Delay(method, path string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault{method: method, path: strings.ToLower(path), delay: d})
+}
+
+// SetPending makes certificates requested from now on report as pending for
+// the given number of retrieve calls before they are issued
+func (s *Server) SetPending(polls int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = polls
+}
+
+// Count returns the number of requests received for method and path
+func (s *Server) Count(method, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[method+" "+strings.ToLower(path)]
+}
+
+// Objects returns all stored certificate objects in creation order
+func (s *Server) Objects() []Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []Object{}
+	for _, dn := range s.order {
+		if o, ok := s.objects[dn]; ok {
+			out = append(out, o.Object)
+		}
+	}
+	return out
+}
+
+// Object returns the stored certificate object with the given DN
+func (s *Server) Object(dn string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[strings.ToLower(dn)]
+	if !ok {
+		return Object{}, false
+	}
+	return o.Object, true
+}
+
+// SetCustomField sets the values of a custom field of a certificate object
+func (s *Server) SetCustomField(dn, name string, values ...string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[strings.ToLower(dn)]
+	if !ok {
+		return false
+	}
+	if o.CustomFields == nil {
+		o.CustomFields = map[string][]string{}
+	}
+	o.CustomFields[name] = values
+	return true
+}
+
+// AddCertificate stores a certificate directly, bypassing the API
+func (s *Server) AddCertificate(zone, name, certificate, privateKey string) (Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.storePEM(policyDN(zone)+"\\"+name, certificate, privateKey, "")
+	if err != nil {
+		return Object{}, err
+	}
+	return o.Object, nil
+}
+
+func (s *Server) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.ToLower(r.URL.Path)
+
+		s.mu.Lock()
+		s.counts[r.Method+" "+path]++
+		f, faulted := s.takeFault(r.Method, path)
+		s.mu.Unlock()
+
+		if faulted {
+			if f.delay > 0 {
+				select {
+				case <-time.After(f.delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			if f.status != 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(f.status)
+				w.Write([]byte(f.body))
+				return
+			}
+		}
+
+		switch {
+		case path == "/vedauth/authorize/oauth":
+			s.handleAuthorizeOAuth(w, r)
+		case path == "/vedauth/authorize/token":
+			s.handleRefreshToken(w, r)
+		case path == "/vedauth/revoke/token":
+			s.handleRevokeToken(w, r)
+		case path == "/vedsdk/authorize/" || path == "/vedsdk/authorize":
+			s.handleAuthorize(w, r)
+		default:
+			if !s.authorized(w, r) {
+				return
+			}
+			s.route(w, r, path)
+		}
+	})
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request, path string) {
+	switch {
+	case path == "/vedsdk/" || path == "/vedsdk":
+		w.WriteHeader(http.StatusOK)
+	case path == "/vedsdk/certificates/checkpolicy":
+		s.handleCheckPolicy(w, r)
+	case path == "/vedsdk/certificates/request":
+		s.handleRequest(w, r)
+	case path == "/vedsdk/certificates/retrieve":
+		s.handleRetrieve(w, r)
+	case path == "/vedsdk/certificates/import":
+		s.handleImport(w, r)
+	case path == "/vedsdk/certificates/revoke":
+		s.handleRevoke(w, r)
+	case path == "/vedsdk/certificates/":
+		s.handleSearch(w, r)
+	case strings.HasPrefix(path, "/vedsdk/certificates/"):
+		s.handleCertificateGUID(w, r, strings.TrimPrefix(r.URL.Path[len("/vedsdk/certificates/"):], "/"))
+	case path == "/vedsdk/config/dntoguid":
+		s.handleDNToGUID(w, r)
+	case path == "/vedsdk/config/delete":
+		s.handleConfigDelete(w, r)
+	case path == "/vedsdk/config/read":
+		s.handleConfigRead(w, r)
+	case path == "/vedsdk/ssh/keyusage":
+		s.handleSSHKeyUsage(w, r)
+	case path == "/vedsdk/metadata/get":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Data": []interface{}{}, "Locked": false})
+	case path == "/vedsdk/metadata/getitems":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Items": []interface{}{}, "Locked": false})
+	case path == "/vedsdk/metadata/set":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Result": 0, "Locked": false})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"Error": "Unknown resource " + r.URL.Path})
+	}
+}
+
+// takeFault removes and returns the first fault matching the request. The
+// caller must hold s.mu.
+func (s *Server) takeFault(method, path string) (fault, bool) {
+	for i, f := range s.faults {
+		if f.method == method && f.path == path {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			return f, true
+		}
+	}
+	return fault{}, false
+}
+
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key := r.Header.Get("x-venafi-api-key"); key != "" && s.apiKeys[key] {
+		return true
+	}
+	access := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	t, ok := s.tokens[access]
+	switch {
+	case !ok || t.revoked:
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token", "error_description": "The access token is invalid"})
+	case t.expired:
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "expired_token", "error_description": "The access token has expired"})
+	default:
+		return true
+	}
+	return false
+}
+
+func (s *Server) handleAuthorizeOAuth(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ClientID string `json:"client_id"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Scope    string `json:"scope"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	password, ok := s.users[body.Username]
+	if !ok || password != body.Password {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "Username/password combination not valid"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.issueToken(body.ClientID, body.Scope, body.Username))
+}
+
+func (s *Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ClientID     string `json:"client_id"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for access, t := range s.tokens {
+		if t.refresh == body.RefreshToken && !t.revoked && t.clientID == body.ClientID {
+			delete(s.tokens, access)
+			writeJSON(w, http.StatusOK, s.issueToken(t.clientID, t.scope, ""))
+			return
+		}
+	}
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "Grant has been revoked, has expired, or the refresh token is invalid"})
+}
+
+func (s *Server) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	access := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[access]
+	if !ok || t.revoked {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token", "error_description": "The access token is invalid"})
+		return
+	}
+	t.revoked = true
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string
+		Password string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	password, ok := s.users[body.Username]
+	if !ok || password != body.Password {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"Error": "Username/password combination not valid"})
+		return
+	}
+	s.nextID++
+	key := fmt.Sprintf("apikey-%d", s.nextID)
+	s.apiKeys[key] = true
+	writeJSON(w, http.StatusOK, map[string]string{"APIKey": key, "ValidUntil": "/Date(" + fmt.Sprint(time.Now().Add(time.Hour).Unix()*1000) + ")/"})
+}
+
+// issueToken creates a new access and refresh token pair. The caller must
+// hold s.mu.
+func (s *Server) issueToken(clientID, scope, identity string) map[string]interface{} {
+	s.nextID++
+	t := &token{
+		access:   fmt.Sprintf("access-%d", s.nextID),
+		refresh:  fmt.Sprintf("refresh-%d", s.nextID),
+		clientID: clientID,
+		scope:    scope,
+	}
+	s.tokens[t.access] = t
+	return map[string]interface{}{
+		"access_token":  t.access,
+		"refresh_token": t.refresh,
+		"expires":       time.Now().Add(time.Hour).Unix(),
+		"identity":      identity,
+		"scope":         scope,
+		"token_type":    "Bearer",
+	}
+}
+
+func (s *Server) createCA() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake TPP CA", Organization: []string{"Venafi"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return err
+	}
+	s.caCert, err = x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	s.caKey = key
+	s.caPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return nil
+}
+
+// policyDN normalizes a zone to the form \VED\Policy\zone
+func policyDN(zone string) string {
+	zone = strings.TrimPrefix(zone, "\\")
+	zone = strings.TrimPrefix(zone, "VED\\")
+	zone = strings.TrimPrefix(zone, "Policy")
+	zone = strings.TrimPrefix(zone, "\\")
+	if zone == "" {
+		return "\\VED\\Policy"
+	}
+	return "\\VED\\Policy\\" + zone
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// SSHKey is an entry of the SSH key inventory of the fake server
+type SSHKey struct {
+	KeyID             string `json:"KeyId"`
+	KeysetID          string `json:"KeysetId"`
+	Algorithm         string
+	KeyLength         int
+	FingerprintSHA256 string
+	Location          string
+	Username          string
+}
+
+// AddSSHKey adds a key to the SSH key inventory. The key and keyset ids are
+// assigned if they are empty.
+func (s *Server) AddSSHKey(key SSHKey) SSHKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	if key.KeyID == "" {
+		key.KeyID = fmt.Sprintf("%d", s.nextID)
+	}
+	if key.KeysetID == "" {
+		key.KeysetID = fmt.Sprintf("{keyset-%d}", s.nextID)
+	}
+	s.sshKeys = append(s.sshKeys, key)
+	return key
+}
+
+func (s *Server) handleSSHKeyUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"Error": "method not allowed"})
+		return
+	}
+	var req struct {
+		Offset   int
+		PageSize int
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"Success": false, "Error": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := s.sshKeys
+	if req.Offset > len(keys) {
+		req.Offset = len(keys)
+	}
+	keys = keys[req.Offset:]
+	if req.PageSize > 0 && req.PageSize < len(keys) {
+		keys = keys[:req.PageSize]
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Data": append([]SSHKey{}, keys...), "Success": true})
+}
@@ -0,0 +1,268 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vcclient
+
+// This file contains direct calls to the TPP WebSDK for the operations that
+// vcert does not provide.
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/newcontext-oss/credhub-venafi/output"
+)
+
+// apiURL returns the absolute URL of a WebSDK resource such as
+// "vedsdk/config/delete". Like vcert, https is always used.
+func (v *VcertProxy) apiURL(resource string) string {
+	base := strings.TrimSuffix(v.BaseURL, "/")
+	base = strings.TrimSuffix(base, "/vedsdk")
+	switch {
+	case strings.HasPrefix(base, "https://"):
+	case strings.HasPrefix(base, "http://"):
+		base = "https://" + strings.TrimPrefix(base, "http://")
+	default:
+		base = "https://" + base
+	}
+	return base + "/" + resource
+}
+
+// httpClient returns a client that trusts the configured trust bundle and
+// uses the configured proxy and timeouts. It is used for all calls to TPP,
+// including those made by vcert.
+func (v *VcertProxy) httpClient() (*http.Client, error) {
+	trust, err := v.trustPool()
+	if err != nil {
+		return nil, err
+	}
+	var tlsConfig *tls.Config
+	if trust != nil {
+		tlsConfig = &tls.Config{RootCAs: trust}
+	}
+	client := v.HTTP.Client(tlsConfig)
+	if client.Timeout == 0 {
+		client.Timeout = defaultRequestTimeout
+	}
+	return client, nil
+}
+
+// defaultRequestTimeout is the request timeout vcert uses for its own clients
+var defaultRequestTimeout = 10 * time.Second
+
+// request sends an authenticated request to the WebSDK and decodes the JSON
+// response into out
+func (v *VcertProxy) request(method string, resource string, in interface{}, out interface{}) error {
+	return v.doRequest(method, resource, in, out, true)
+}
+
+func (v *VcertProxy) doRequest(method string, resource string, in interface{}, out interface{}, authenticate bool) error {
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+	req, err := http.NewRequest(method, v.apiURL(resource), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case !authenticate:
+	case v.AccessToken != "":
+		req.Header.Set("Authorization", "Bearer "+v.AccessToken)
+	case v.LegacyAuth:
+		key, err := v.legacyAPIKey()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Venafi-Api-Key", key)
+	}
+
+	client, err := v.httpClient()
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %s", resource, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from %s: %s %s", resource, resp.Status, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// legacyAPIKey authorizes with username and password and caches the api key
+func (v *VcertProxy) legacyAPIKey() (string, error) {
+	if v.apiKey != "" {
+		return v.apiKey, nil
+	}
+	auth := struct {
+		Username string
+		Password string
+	}{v.Username, v.Password}
+	var resp struct {
+		APIKey string
+	}
+	err := v.doRequest("POST", "vedsdk/authorize/", auth, &resp, false)
+	if err != nil {
+		return "", fmt.Errorf("could not authorize with APIKey: %s", err)
+	}
+	v.apiKey = resp.APIKey
+	return v.apiKey, nil
+}
+
+// FindByThumbprint returns the DNs of all certificate objects with the given
+// thumbprint
+func (v *VcertProxy) FindByThumbprint(thumbprint string) ([]string, error) {
+	var resp struct {
+		Certificates []struct {
+			DN string
+		}
+	}
+	err := v.request("GET", "vedsdk/certificates/?Thumbprint="+url.QueryEscape(thumbprint), nil, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("could not search for thumbprint %s: %s", thumbprint, err)
+	}
+	dns := []string{}
+	for _, c := range resp.Certificates {
+		dns = append(dns, c.DN)
+	}
+	return dns, nil
+}
+
+// Delete removes a certificate object from TPP
+func (v *VcertProxy) Delete(dn string) error {
+	req := struct {
+		ObjectDN  string
+		Recursive bool
+	}{dn, false}
+	var resp struct {
+		Result int
+		Error  string
+	}
+	err := v.request("POST", "vedsdk/config/delete", req, &resp)
+	if err != nil {
+		return fmt.Errorf("could not delete %s: %s", dn, err)
+	}
+	// the config API reports errors with a result code, 1 is success
+	if resp.Result != 1 {
+		return fmt.Errorf("could not delete %s: result %d %s", dn, resp.Result, resp.Error)
+	}
+	output.Verbose("Successfully deleted %s", dn)
+	return nil
+}
+
+// validityAttributes maps issuer hints to the CA specific attribute that
+// sets the end date of a requested certificate
+var validityAttributes = map[string]string{
+	"":          "Specific End Date",
+	"microsoft": "Microsoft CA:Specific End Date",
+	"digicert":  "DigiCert CA:Specific End Date",
+	"entrust":   "EntrustNET CA:Specific End Date",
+}
+
+type nameValue struct {
+	Name  string
+	Value string
+}
+
+type sanItem struct {
+	Type int
+	Name string
+}
+
+// requestCertificate submits a certificate request with an end date. vcert
+// cannot set CA specific attributes, so the request is sent directly; the
+// body otherwise matches the one vcert sends.
+func (v *VcertProxy) requestCertificate(req *certificate.Request, args *CertArgs) (string, error) {
+	body := struct {
+		PolicyDN                string
+		ObjectName              string      `json:",omitempty"`
+		Subject                 string      `json:",omitempty"`
+		PKCS10                  string      `json:",omitempty"`
+		SubjectAltNames         []sanItem   `json:",omitempty"`
+		KeyAlgorithm            string      `json:",omitempty"`
+		KeyBitSize              int         `json:",omitempty"`
+		EllipticCurve           string      `json:",omitempty"`
+		CASpecificAttributes    []nameValue `json:",omitempty"`
+		Origin                  string
+		DisableAutomaticRenewal bool
+	}{
+		PolicyDN:                PrependPolicyRoot(v.Zone),
+		ObjectName:              req.FriendlyName,
+		Origin:                  origin,
+		DisableAutomaticRenewal: true,
+	}
+	if req.CsrOrigin == certificate.ServiceGeneratedCSR {
+		body.Subject = req.Subject.CommonName
+		for _, name := range req.EmailAddresses {
+			body.SubjectAltNames = append(body.SubjectAltNames, sanItem{1, name})
+		}
+		for _, name := range req.DNSNames {
+			body.SubjectAltNames = append(body.SubjectAltNames, sanItem{2, name})
+		}
+		for _, ip := range req.IPAddresses {
+			body.SubjectAltNames = append(body.SubjectAltNames, sanItem{7, ip.String()})
+		}
+	} else {
+		body.PKCS10 = string(req.GetCSR())
+	}
+	switch req.KeyType {
+	case certificate.KeyTypeRSA:
+		body.KeyAlgorithm = "RSA"
+		body.KeyBitSize = req.KeyLength
+	case certificate.KeyTypeECDSA:
+		body.KeyAlgorithm = "ECC"
+		body.EllipticCurve = req.KeyCurve.String()
+	}
+	end := time.Now().UTC().Add(time.Duration(args.ValidityHours) * time.Hour)
+	body.CASpecificAttributes = []nameValue{
+		{"Origin", origin},
+		{validityAttributes[strings.ToLower(args.IssuerHint)], end.Format("2006-01-02 15:04:05")},
+	}
+
+	var resp struct {
+		CertificateDN string
+		Error         string
+	}
+	err := v.request("POST", "vedsdk/certificates/request", body, &resp)
+	if err != nil {
+		return "", fmt.Errorf("could not request certificate: %s", err)
+	}
+	if resp.CertificateDN == "" {
+		return "", fmt.Errorf("could not request certificate: %s", resp.Error)
+	}
+	return resp.CertificateDN, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 New Context, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/newcontext-oss/credhub-venafi/output"
+)
+
+// check results of a verification
+const (
+	checkOK      = "ok"
+	checkFailed  = "FAIL"
+	checkSkipped = "-"
+)
+
+// verifyItem is the result of verifying one CredHub certificate
+type verifyItem struct {
+	name      string
+	key       string
+	chain     string
+	venafi    string
+	venafiDNs []string
+	problems  []string
+}
+
+func (i verifyItem) failed() bool {
+	return len(i.problems) > 0
+}
+
+func (i *verifyItem) fail(format string, args ...interface{}) string {
+	i.problems = append(i.problems, fmt.Sprintf(format, args...))
+	return checkFailed
+}
+
+// verify checks that the private key of CredHub certificates matches the
+// certificate, that the certificate validates against the stored CA and that
+// Venafi holds the same certificate
+func (c *CV) verify(args *VerifyCommand) ([]verifyItem, error) {
+	names := []string{args.Name}
+	if args.All {
+		certs, err := c.store.List()
+		if err != nil {
+			return nil, err
+		}
+		names = []string{}
+		for _, cert := range certs {
+			names = append(names, cert.Name)
+		}
+	}
+
+	items := []verifyItem{}
+	failed := 0
+	for _, name := range names {
+		item := c.verifyCert(name)
+		if item.failed() {
+			failed++
+		}
+		items = append(items, item)
+	}
+
+	printVerify(items)
+	if failed > 0 {
+		return items, fmt.Errorf("%d of %d certificates failed verification", failed, len(items))
+	}
+	return items, nil
+}
+
+func (c *CV) verifyCert(name string) verifyItem {
+	item := verifyItem{name: name, key: checkSkipped, chain: checkSkipped, venafi: checkSkipped}
+	cert, err := c.store.GetCertificate(name)
+	if err != nil {
+		item.fail("could not get the certificate from %s: %s", c.store.Name(), err)
+		return item
+	}
+	record, err := parseRecord(targetCredhub, name, cert.Certificate, []string{cert.CA})
+	if err != nil {
+		item.fail("%s", err)
+		return item
+	}
+
+	item.key = verifyKey(&item, record.cert, cert.PrivateKey)
+	item.chain = verifyChain(&item, record)
+
+	dns, err := c.vcert.FindByThumbprint(fingerprint(sha1.New(), record.cert))
+	switch {
+	case err != nil:
+		item.venafi = item.fail("could not search Venafi: %s", err)
+	case len(dns) == 0:
+		item.venafi = item.fail("Venafi does not hold the certificate")
+	default:
+		item.venafiDNs = dns
+		item.venafi = checkOK
+	}
+	return item
+}
+
+// verifyKey checks that the private key belongs to the certificate. A
+// certificate stored without a key, as for a CSR submitted without it, is
+// skipped.
+func verifyKey(item *verifyItem, cert *x509.Certificate, keyPEM string) string {
+	if keyPEM == "" {
+		return checkSkipped
+	}
+	signer, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return item.fail("could not parse the private key: %s", err)
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return item.fail("the private key does not belong to the certificate")
+	}
+	return checkOK
+}
+
+// verifyChain checks that the certificate validates against the stored CA
+// certificates. A certificate stored without a CA is skipped.
+func verifyChain(item *verifyItem, record certRecord) string {
+	if len(record.chain) == 0 {
+		return checkSkipped
+	}
+	roots := x509.NewCertPool()
+	for _, ca := range record.chain {
+		roots.AddCert(ca)
+	}
+	_, err := record.cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if err != nil {
+		return item.fail("the certificate does not validate against the stored CA: %s", err)
+	}
+	return checkOK
+}
+
+func printVerify(items []verifyItem) {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tKEY\tCHAIN\tVENAFI\n")
+	for _, i := range items {
+		venafi := i.venafi
+		if len(i.venafiDNs) > 0 {
+			venafi = strings.Join(i.venafiDNs, ", ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", i.name, i.key, i.chain, venafi)
+	}
+	w.Flush()
+	output.Print("%s%s", output.Cyan, b.String())
+
+	for _, i := range items {
+		for _, p := range i.problems {
+			output.Errorf("%s: %s\n", i.name, p)
+		}
+	}
+}